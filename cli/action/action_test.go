@@ -2,6 +2,7 @@ package action
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/jeromelesaux/dsk/dsk"
@@ -20,7 +21,12 @@ func TestOptionsBuilder(t *testing.T) {
 		WithHidden(true).
 		WithRemoveHeader(true).
 		WithRawImport(true).
-		WithRawExport(true)
+		WithRawExport(true).
+		WithReadOnly(true).
+		WithArchived(true).
+		WithMergePolicy("rename").
+		WithSectorOrder("outback").
+		WithHFEV3(true)
 
 	assert.True(t, op.quiet)
 	assert.True(t, op.format)
@@ -33,6 +39,11 @@ func TestOptionsBuilder(t *testing.T) {
 	assert.True(t, op.removeHeader)
 	assert.True(t, op.rawImport)
 	assert.True(t, op.rawExport)
+	assert.True(t, op.readOnly)
+	assert.True(t, op.archived)
+	assert.Equal(t, "rename", op.mergePolicy)
+	assert.Equal(t, "outback", op.sectorOrder)
+	assert.True(t, op.hfeV3)
 }
 
 func TestDskDescriptorDefaultsAndBuilder(t *testing.T) {
@@ -55,7 +66,7 @@ func TestAmsdosFileDescriptorDefaultsAndBuilder(t *testing.T) {
 	a := NewAmsdosFileDescriptor()
 	assert.Equal(t, AmsdosTypeAscii, a.Type)
 
-	a = a.WithAddHeader(true).WithType(AmsdosTypeBinary).WithUser(5).WithPath("hello")
+	a = a.WithAddHeader(true).WithType(AmsdosTypeBinary).WithUser(5).WithPath("hello").WithNewName("world").WithNewUser(2)
 	a.WithExec(0x1234)
 	a.WithLoad(0x5678)
 	assert.True(t, a.addHeader)
@@ -64,6 +75,8 @@ func TestAmsdosFileDescriptorDefaultsAndBuilder(t *testing.T) {
 	assert.Equal(t, uint16(0x1234), a.Exec)
 	assert.Equal(t, uint16(0x5678), a.Load)
 	assert.Equal(t, "hello", a.Path)
+	assert.Equal(t, "world", a.NewName)
+	assert.Equal(t, uint16(2), a.NewUser)
 }
 
 func TestAmsdosFileDescriptorAddExecAddLoad(t *testing.T) {
@@ -139,3 +152,36 @@ func TestSnaActionDoSnaActionsFormat(t *testing.T) {
 	}
 	assert.True(t, exists)
 }
+
+func TestCompactOptions(t *testing.T) {
+	opts, err := compactOptions("loader.bin,1:0-3:8,5:1:2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"loader.bin"}, opts.Keep)
+	assert.Equal(t, []dsk.SectorRange{
+		{First: dsk.TrackSector{Cyl: 1, Sect: 0}, Last: dsk.TrackSector{Cyl: 3, Sect: 8}},
+		{First: dsk.TrackSector{Cyl: 5, Head: 1, Sect: 2}, Last: dsk.TrackSector{Cyl: 5, Head: 1, Sect: 2}},
+	}, opts.KeepSectors)
+
+	_, err = compactOptions("1:x-3:8")
+	assert.ErrorIs(t, err, dsk.ErrorSectorRange)
+	_, err = compactOptions("1:2:3:4")
+	assert.ErrorIs(t, err, dsk.ErrorSectorRange)
+}
+
+func TestImportImgHeads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gotek.img")
+	assert.NoError(t, os.WriteFile(path, make([]byte, 80*2*9*512), 0o644))
+
+	desc := NewDskDescriptor().WithHead(1).WithFormat("data").WithType(dsk.EXTENDED_DSK_TYPE)
+	_, onError, message, _ := ImportImg(path, *desc, string(dsk.OrderSides))
+	assert.True(t, onError)
+	assert.Contains(t, message, "160 cylinders")
+
+	d, onError, message, _ := ImportImg(path, *desc.WithHead(2), string(dsk.OrderSides))
+	assert.False(t, onError, message)
+	assert.Equal(t, uint8(80), d.Entry.NbTracks)
+	assert.Equal(t, uint8(2), d.Entry.NbHeads)
+
+	_, onError, _, _ = ImportImg(path, *desc.WithHead(3), string(dsk.OrderSides))
+	assert.True(t, onError)
+}
@@ -9,12 +9,15 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/jeromelesaux/dsk/amsdos"
 	"github.com/jeromelesaux/dsk/cli/msg"
 	"github.com/jeromelesaux/dsk/dsk"
+	"github.com/jeromelesaux/dsk/flux"
 	"github.com/jeromelesaux/dsk/hfe"
+	"github.com/jeromelesaux/dsk/ipf"
 	"github.com/jeromelesaux/dsk/utils"
 )
 
@@ -33,6 +36,7 @@ type DskDescriptor struct {
 	Type          int
 	SizeToExtract int
 	FolderPath    string
+	Format        string
 }
 
 func NewDskDescriptor() *DskDescriptor {
@@ -70,12 +74,19 @@ func (d *DskDescriptor) WithType(dskType int) *DskDescriptor {
 	return d
 }
 
+func (d *DskDescriptor) WithFormat(format string) *DskDescriptor {
+	d.Format = format
+	return d
+}
+
 type AmsdosFileDescriptor struct {
 	Path      string
 	Exec      uint16
 	Load      uint16
 	User      uint16
 	Type      AmsdosType
+	NewName   string
+	NewUser   uint16
 	addHeader bool
 }
 
@@ -113,6 +124,16 @@ func (a *AmsdosFileDescriptor) WithPath(path string) *AmsdosFileDescriptor {
 	return a
 }
 
+func (a *AmsdosFileDescriptor) WithNewName(name string) *AmsdosFileDescriptor {
+	a.NewName = name
+	return a
+}
+
+func (a *AmsdosFileDescriptor) WithNewUser(user uint16) *AmsdosFileDescriptor {
+	a.NewUser = user
+	return a
+}
+
 func (a *AmsdosFileDescriptor) WithPaths(s ...string) *AmsdosFileDescriptor {
 	for _, v := range s {
 		if v != "" {
@@ -160,8 +181,11 @@ type Action struct {
 }
 
 func (a Action) DskIsSet() bool {
-	hfeExists, _ := a.hfeIsSet()
-	return a.Path != "" || hfeExists
+	hfeExists, _ := a.taskIsSet(ActionHFEFileinfoDsk)
+	imgExists, _ := a.taskIsSet(ActionImgFile)
+	fluxExists, _ := a.taskIsSet(ActionFluxFile)
+	ipfExists, _ := a.taskIsSet(ActionIPFFile)
+	return a.Path != "" || hfeExists || imgExists || fluxExists || ipfExists
 }
 
 func (a *Action) WithOptions(options Options) *Action {
@@ -198,9 +222,9 @@ func (a *Action) WithDskActions(actions *DskTasks) *Action {
 	return a
 }
 
-func (a *Action) hfeIsSet() (bool, DskTaskFile) {
+func (a *Action) taskIsSet(task DskTask) (bool, DskTaskFile) {
 	for _, action := range a.tasks.a {
-		if action.a == ActionHFEFileinfoDsk {
+		if action.a == task {
 			return true, action
 		}
 	}
@@ -208,8 +232,37 @@ func (a *Action) hfeIsSet() (bool, DskTaskFile) {
 }
 
 func (a *Action) SetDsk() (onError bool, message, hint string) {
-	hfeIsSet, hfeTask := a.hfeIsSet()
-	if hfeIsSet {
+	hfeIsSet, hfeTask := a.taskIsSet(ActionHFEFileinfoDsk)
+	imgIsSet, imgTask := a.taskIsSet(ActionImgFile)
+	fluxIsSet, fluxTask := a.taskIsSet(ActionFluxFile)
+	ipfIsSet, ipfTask := a.taskIsSet(ActionIPFFile)
+	if ipfIsSet {
+		ipfDisk, err := ipf.Open(ipfTask.File)
+		if err != nil {
+			return true, "Error while reading IPF file", err.Error()
+		}
+		disk, err := ipfDisk.ToDSK()
+		if err != nil {
+			return true, "Error while converting IPF to DSK", err.Error()
+		}
+		a.d = *disk
+	} else if fluxIsSet {
+		img, err := flux.Open(fluxTask.File)
+		if err != nil {
+			return true, "Error while reading flux image", err.Error()
+		}
+		disk, err := img.ToDSK()
+		if err != nil {
+			return true, "Error while decoding flux image to DSK", err.Error()
+		}
+		a.d = *disk
+	} else if imgIsSet {
+		disk, onError, message, hint := ImportImg(imgTask.File, a.desc, a.options.sectorOrder)
+		if onError {
+			return onError, message, hint
+		}
+		a.d = *disk
+	} else if hfeIsSet {
 		hfeDisk, err := hfe.Open(hfeTask.File)
 		if err != nil {
 			return true, "Error while reading HFE file", err.Error()
@@ -228,8 +281,55 @@ func (a *Action) SetDsk() (onError bool, message, hint string) {
 	return false, "", ""
 }
 
-func ConvertDSKToHFE(d dsk.DSK, filepath string) (onError bool, message, hint string) {
-	err := hfe.FromDSK(&d, filepath)
+// ImportImg reads the raw sector image with the geometry of the disk format
+// of the descriptor, data by default. The heads of the descriptor turn a
+// single sided format into a double sided one.
+func ImportImg(path string, desc DskDescriptor, order string) (d *dsk.DSK, onError bool, message, hint string) {
+	format := desc.Format
+	if format == "" {
+		format = "data"
+	}
+	p, err := dsk.LookupDiskParams(format)
+	if err != nil {
+		return nil, true, fmt.Sprintf("Error disk format (%s) error %v", format, err), "Use one of data, vendor, ibm, parados, romdos-d1, romdos-d2, romdos-d10, ms800"
+	}
+	// a single sided format may be stored on both sides of the image
+	if desc.Head > int(p.Heads) {
+		if desc.Head > 2 {
+			return nil, true, fmt.Sprintf("Cannot read raw image (%s) with %d heads\n", path, desc.Head), "Set -head to 1 or 2"
+		}
+		p.Heads = uint8(desc.Head)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, true, fmt.Sprintf("Cannot open file %s error :%v\n", path, err), "Check your file path"
+	}
+	defer f.Close()
+	d, err = dsk.ImportRaw(f, p, dsk.SectorOrder(order), desc.Type)
+	if err != nil {
+		return nil, true, fmt.Sprintf("Cannot read raw image (%s) error :%v\n", path, err), "Check the geometry set with -diskformat and -head and the order set with -order"
+	}
+	return d, false, "", ""
+}
+
+func ConvertDSKToImg(d dsk.DSK, path, order string) (onError bool, message, hint string) {
+	f, err := os.Create(path)
+	if err != nil {
+		return true, fmt.Sprintf("Error while write file (%s) error %v\n", path, err), "Check your raw image file path."
+	}
+	defer f.Close()
+	if err := d.ExportRaw(f, dsk.SectorOrder(order)); err != nil {
+		return true, fmt.Sprintf("Cannot export dsk to raw image (%s) error :%v\n", path, err), "Check your dsk with option -dsk yourdsk.dsk -analyze"
+	}
+	return false, "", ""
+}
+
+func ConvertDSKToHFE(d dsk.DSK, filepath string, v3 bool) (onError bool, message, hint string) {
+	write := hfe.FromDSK
+	if v3 {
+		write = hfe.FromDSKV3
+	}
+	err := write(&d, filepath)
 	if err != nil {
 		return true, "Error while converting DSK to HFE", err.Error()
 	}
@@ -255,11 +355,33 @@ func (a *Action) DoDskActions() (onError bool, message, hint string) {
 		case ActionListBasic:
 			onError, message, hint = ListBasic(a.d, a.fd.Path)
 		case ActionAnalyseDsk:
-			onError, message, hint = AnalyseDsk(a.d, a.Path)
+			if hfeIsSet, hfeTask := a.taskIsSet(ActionHFEFileinfoDsk); hfeIsSet {
+				onError, message, hint = AnalyseHFE(hfeTask.File)
+			} else {
+				onError, message, hint = AnalyseDsk(a.d, a.Path)
+			}
 		case ActionPutFileDsk:
-			onError, message, hint = PutFileDsk(a.d, a.Path, a.fd, a.options.hidden, a.options.force, a.options.quiet)
+			onError, message, hint = PutFileDsk(a.d, a.Path, a.fd, a.options.hidden, a.options.readOnly, a.options.force, a.options.quiet)
 		case ActionRemoveFileDsk:
 			onError, message, hint = RemoveFileDsk(a.d, a.Path, a.fd.Path)
+		case ActionRenameFileDsk:
+			onError, message, hint = RenameFileDsk(a.d, a.Path, a.fd, a.options.force)
+		case ActionChangeUserFileDsk:
+			onError, message, hint = ChangeUserFileDsk(a.d, a.Path, a.fd, a.options.force)
+		case ActionAttributesFileDsk:
+			onError, message, hint = AttributesFileDsk(a.d, a.Path, a.fd, dsk.Attributes{ReadOnly: a.options.readOnly, System: a.options.hidden, Archived: a.options.archived})
+		case ActionUndeleteFileDsk:
+			onError, message, hint = UndeleteFileDsk(a.d, a.Path, a.fd.Path, a.fd.User)
+		case ActionFsckDsk:
+			onError, message, hint = FsckDsk(a.d, a.Path, action.File)
+		case ActionCompactDsk:
+			onError, message, hint = CompactDsk(a.d, a.Path, action.File)
+		case ActionDiffDsk:
+			onError, message, hint = DiffDsk(a.d, a.Path, action.File, a.desc, a.options.quiet)
+		case ActionMergeDsk:
+			onError, message, hint = MergeDsk(a.d, a.Path, action.File, a.options.mergePolicy, a.desc, a.options.quiet)
+		case ActionConvertDsk:
+			onError, message, hint = ConvertDsk(a.d, a.Path, action.File)
 		case ActionGetFileDsk:
 			onError, message, hint = GetFileWithOptions(a.d, a.desc, a.fd, a.options)
 		case ActionAsciiFileDsk:
@@ -276,7 +398,9 @@ func (a *Action) DoDskActions() (onError bool, message, hint string) {
 		case ActionFileinfoDsk:
 			onError, message, hint = FileinfoDsk(a.d, a.fd.Path)
 		case ActionConvertDSKToHFE:
-			onError, message, hint = ConvertDSKToHFE(a.d, action.File)
+			onError, message, hint = ConvertDSKToHFE(a.d, action.File, a.options.hfeV3)
+		case ActionConvertDSKToImg:
+			onError, message, hint = ConvertDSKToImg(a.d, action.File, a.options.sectorOrder)
 		default:
 			if !listAlreadyDone {
 				onError, message, hint = ListDsk(a.d, a.Path)
@@ -440,8 +564,20 @@ func FormatDsk(filepath string, desc DskDescriptor, vendorFormat bool, dataForma
 		return true, fmt.Sprintf("Error while write file (%s) error %v", desc.Path, err), "Check your dsk file path."
 	}
 	defer f.Close()
-	fmt.Fprintf(os.Stderr, "Formating number of sectors (%d), tracks (%d), head number (%d)\n", desc.Sector, desc.Track, desc.Head)
 	var dskFile *dsk.DSK
+	if desc.Format != "" {
+		p, err := dsk.LookupDiskParams(desc.Format)
+		if err != nil {
+			return true, fmt.Sprintf("Error disk format (%s) error %v", desc.Format, err), "Use one of data, vendor, ibm, parados, romdos-d1, romdos-d2, romdos-d10, ms800"
+		}
+		fmt.Fprintf(os.Stderr, "Formating %s number of sectors (%d), tracks (%d), head number (%d)\n", p.Name, p.SectorsPerTrack(), p.Tracks, p.Heads)
+		dskFile = dsk.FormatDskWithParams(p, p.Tracks, p.Heads, desc.Type)
+		if err := dskFile.Write(f); err != nil {
+			return true, fmt.Sprintf("Error while write file (%s) error %v\n", desc.Path, err), "Check your dsk file with option -dsk yourdsk.dsk -analyze"
+		}
+		return false, "", ""
+	}
+	fmt.Fprintf(os.Stderr, "Formating number of sectors (%d), tracks (%d), head number (%d)\n", desc.Sector, desc.Track, desc.Head)
 	if dataFormat {
 		dskFile = dsk.FormatDsk(uint8(desc.Sector), uint8(desc.Track), uint8(desc.Head), dsk.DataFormat, desc.Type)
 	} else {
@@ -556,7 +692,30 @@ func AnalyseDsk(d dsk.DSK, dskPath string) (onError bool, message, hint string)
 	return false, "", ""
 }
 
-func PutFileDsk(d dsk.DSK, dskPath string, desc AmsdosFileDescriptor, hide, force, quiet bool) (onError bool, message, hint string) {
+// AnalyseHFE decodes every track of the HFE file and displays the sectors
+// found with their CRC status and the length of the bitstreams
+func AnalyseHFE(hfePath string) (onError bool, message, hint string) {
+	h, err := hfe.Open(hfePath)
+	if err != nil {
+		return true, fmt.Sprintf("Error while reading HFE file (%s) error %v\n", hfePath, err), "Check your HFE file path"
+	}
+	fmt.Fprintf(os.Stdout, "HFE file (%s) %s: %d tracks, %d sides, %d kbps, %d rpm\n",
+		hfePath, h.Header.Signature, h.Header.NumTracks, h.Header.NumSides, h.Header.BitRate, h.Header.FloppyRPM)
+	report := hfe.Analyze(h)
+	for _, t := range report.Tracks {
+		fmt.Fprint(os.Stdout, t.String())
+		for _, problem := range t.Problems() {
+			fmt.Fprintf(os.Stdout, "  error: %s\n", problem)
+		}
+	}
+	if !report.OK() {
+		return true, fmt.Sprintf("%d track(s) with errors in HFE file (%s)\n", report.Errors(), hfePath), "The dump is damaged, dump the disk again"
+	}
+	fmt.Fprintf(os.Stdout, "HFE file (%s) decoded without error\n", hfePath)
+	return false, "", ""
+}
+
+func PutFileDsk(d dsk.DSK, dskPath string, desc AmsdosFileDescriptor, hide, readOnly, force, quiet bool) (onError bool, message, hint string) {
 	if desc.Path == "" {
 		msg.ExitOnError("amsdosfile option is empty, set it.", "dsk -dsk output.dsk -put hello.bin -exec \"#1000\" -load 500")
 	}
@@ -567,21 +726,29 @@ func PutFileDsk(d dsk.DSK, dskPath string, desc AmsdosFileDescriptor, hide, forc
 	} else {
 		if indice != dsk.NOT_FOUND && force {
 			// suppress file
-			err := d.RemoveFile(uint8(indice))
+			err := d.RemoveFile(indice)
 			if err != nil {
 				msg.ExitOnError(fmt.Sprintf("error while removing file %v", err), "check your dsk content")
 			}
 		}
+		content, err := os.ReadFile(desc.Path)
+		if err != nil {
+			return true, fmt.Sprintf("Error while reading file (%s) error :%v\n", desc.Path, err), "Check your file path"
+		}
+		opts := dsk.FileOptions{User: uint8(desc.User), System: hide, ReadOnly: readOnly}
+		informations := fmt.Sprintf("execute address [#%.4x], loading address [#%.4x]\n", desc.Exec, desc.Load)
 		switch desc.Type {
 		case AmsdosTypeAscii:
-			informations := fmt.Sprintf("execute address [#%.4x], loading address [#%.4x]\n", desc.Exec, desc.Load)
-			if err := d.PutFile(desc.Path, dsk.MODE_ASCII, 0, 0, desc.User, false, false, hide); err != nil {
+			opts.Type = dsk.MODE_ASCII
+			if _, err := d.AddFile(desc.Path, content, opts); err != nil {
 				return true, fmt.Sprintf("Error while inserted file (%s) in dsk (%s) error :%v\n", desc.Path, dskPath, err), "Check your dsk  with option -dsk yourdsk.dsk -analyze"
 			}
 			msg.ResumeAction(dskPath, "put ascii", desc.Path, informations, quiet)
 		case AmsdosTypeBinary:
-			informations := fmt.Sprintf("execute address [#%.4x], loading address [#%.4x]\n", desc.Exec, desc.Load)
-			if err := d.PutFile(desc.Path, dsk.MODE_BINAIRE, desc.Load, desc.Exec, desc.User, false, false, hide); err != nil {
+			opts.Type = dsk.MODE_BINAIRE
+			opts.Load = desc.Load
+			opts.Exec = desc.Exec
+			if _, err := d.AddFile(desc.Path, content, opts); err != nil {
 				return true, fmt.Sprintf("Error while inserted file (%s) in dsk (%s) error :%v\n", desc.Path, dskPath, err), "Check your dsk  with option -dsk yourdsk.dsk -analyze"
 			}
 			msg.ResumeAction(dskPath, "put binary", desc.Path, informations, quiet)
@@ -610,7 +777,7 @@ func RemoveFileDsk(d dsk.DSK, dskPath, fileInDsk string) (onError bool, message,
 	if indice == dsk.NOT_FOUND {
 		return true, fmt.Sprintf("File (%s) not found in dsk (%s)\n", fileInDsk, dskPath), "Check you dsk"
 	}
-	if err := d.RemoveFile(uint8(indice)); err != nil {
+	if err := d.RemoveFile(indice); err != nil {
 		fmt.Fprintf(os.Stderr, "Error while removing file %s (indice:%d) error :%v\n", fileInDsk, indice, err)
 	} else {
 		fmt.Fprintf(os.Stderr, "File (%.8s.%.3s) deleted in dsk (%s)\n",
@@ -629,6 +796,288 @@ func RemoveFileDsk(d dsk.DSK, dskPath, fileInDsk string) (onError bool, message,
 	return false, "", ""
 }
 
+// removeExisting removes the file of the user named like newName when force
+// is set, following the -put semantic for existing files
+func removeExisting(d *dsk.DSK, newName string, user uint16, force bool) (onError bool, message, hint string) {
+	target := dsk.GetNomDir(newName)
+	f, err := d.LookupFile(uint8(user), target.Nom, target.Ext)
+	if errors.Is(err, dsk.ErrorFileNotFound) {
+		return false, "", ""
+	}
+	if !force {
+		return true, fmt.Sprintf("File %s already exists in user %d\n", newName, user), "use -force to replace it"
+	}
+	if err := d.RemoveFile(f.Indices[0]); err != nil {
+		return true, fmt.Sprintf("error while removing file %v", err), "check your dsk content"
+	}
+	return false, "", ""
+}
+
+func RenameFileDsk(d dsk.DSK, dskPath string, desc AmsdosFileDescriptor, force bool) (onError bool, message, hint string) {
+	if desc.Path == "" || desc.NewName == "" {
+		return true, "rename or newname option is empty, set it.", "dsk -dsk output.dsk -rename hello.bin -newname world.bin"
+	}
+	if dsk.GetNomAmsdos(desc.Path) != dsk.GetNomAmsdos(desc.NewName) {
+		if onError, message, hint = removeExisting(&d, desc.NewName, desc.User, force); onError {
+			return onError, message, hint
+		}
+	}
+	f, err := d.Rename(desc.Path, uint8(desc.User), desc.NewName)
+	if err != nil {
+		return true, fmt.Sprintf("Cannot rename file (%s) in dsk (%s) error :%v\n", desc.Path, dskPath, err), "Check your dsk with option -dsk yourdsk.dsk -list"
+	}
+	if onError, message, hint = SaveDsk(d, dskPath); onError {
+		return onError, message, hint
+	}
+	fmt.Fprintf(os.Stderr, "File (%s) renamed to (%s) in dsk (%s)\n", desc.Path, f.Filename(), dskPath)
+	return false, "", ""
+}
+
+func ChangeUserFileDsk(d dsk.DSK, dskPath string, desc AmsdosFileDescriptor, force bool) (onError bool, message, hint string) {
+	if desc.Path == "" {
+		return true, "chuser option is empty, set it.", "dsk -dsk output.dsk -chuser hello.bin -user 0 -newuser 2"
+	}
+	if desc.NewUser != desc.User {
+		if onError, message, hint = removeExisting(&d, desc.Path, desc.NewUser, force); onError {
+			return onError, message, hint
+		}
+	}
+	f, err := d.ChangeUser(desc.Path, uint8(desc.User), uint8(desc.NewUser))
+	if err != nil {
+		return true, fmt.Sprintf("Cannot change the user of file (%s) in dsk (%s) error :%v\n", desc.Path, dskPath, err), "Check your dsk with option -dsk yourdsk.dsk -list"
+	}
+	if onError, message, hint = SaveDsk(d, dskPath); onError {
+		return onError, message, hint
+	}
+	fmt.Fprintf(os.Stderr, "File (%s) moved from user %d to user %d in dsk (%s)\n", f.Filename(), desc.User, f.User, dskPath)
+	return false, "", ""
+}
+
+func AttributesFileDsk(d dsk.DSK, dskPath string, desc AmsdosFileDescriptor, attrs dsk.Attributes) (onError bool, message, hint string) {
+	if desc.Path == "" {
+		return true, "attrib option is empty, set it.", "dsk -dsk output.dsk -attrib hello.bin -readonly -hide"
+	}
+	f, err := d.SetAttributes(desc.Path, uint8(desc.User), attrs)
+	if err != nil {
+		return true, fmt.Sprintf("Cannot set the attributes of file (%s) in dsk (%s) error :%v\n", desc.Path, dskPath, err), "Check your dsk with option -dsk yourdsk.dsk -list"
+	}
+	if onError, message, hint = SaveDsk(d, dskPath); onError {
+		return onError, message, hint
+	}
+	fmt.Fprintf(os.Stderr, "File (%s) attributes read-only:%t system:%t archived:%t in dsk (%s)\n", f.Filename(), attrs.ReadOnly, attrs.System, attrs.Archived, dskPath)
+	return false, "", ""
+}
+
+func UndeleteFileDsk(d dsk.DSK, dskPath, fileInDsk string, user uint16) (onError bool, message, hint string) {
+	if fileInDsk == "" {
+		return true, "amsdosfile option is empty, set it.", "dsk -dsk output.dsk -undelete hello.bin"
+	}
+	df, err := d.Undelete(fileInDsk, uint8(user))
+	if errors.Is(err, dsk.ErrorFileNotFound) {
+		deleted, _ := d.ListDeleted()
+		names := make([]string, 0)
+		for _, v := range deleted {
+			names = append(names, v.File.Filename())
+		}
+		return true, fmt.Sprintf("File (%s) not found in the erased files of dsk (%s)\n", fileInDsk, dskPath), fmt.Sprintf("Erased files : %s", strings.Join(names, ", "))
+	}
+	fmt.Fprintf(os.Stderr, "File (%s) %d/%d blocs intact\n", df.File.Filename(), df.IntactBlocs(), len(df.Blocs))
+	if err != nil {
+		if errors.Is(err, dsk.ErrorBlocsReused) {
+			return true, fmt.Sprintf("Cannot undelete file (%s), blocs %v are used by another file\n", fileInDsk, df.ReusedBlocs), "Extract the intact part with -rawexport"
+		}
+		return true, fmt.Sprintf("Cannot undelete file (%s) error :%v\n", fileInDsk, err), "Check your dsk with option -dsk yourdsk.dsk -list"
+	}
+	f, err := os.Create(dskPath)
+	if err != nil {
+		return true, fmt.Sprintf("Error while write file (%s) error %v\n", dskPath, err), "Check your dsk path file"
+	}
+	defer f.Close()
+	if err := d.Write(f); err != nil {
+		return true, fmt.Sprintf("Error while write file (%s) error %v\n", dskPath, err), "Check your dsk  with option -dsk yourdsk.dsk -analyze"
+	}
+	fmt.Fprintf(os.Stderr, "File (%s) restored in user %d of dsk (%s)\n", df.File.Filename(), user, dskPath)
+	return false, "", ""
+}
+
+func FsckDsk(d dsk.DSK, dskPath, repairPath string) (onError bool, message, hint string) {
+	var report dsk.FsckReport
+	var repaired *dsk.DSK
+	if repairPath != "" {
+		repaired, report = d.Repair()
+	} else {
+		report = d.Fsck()
+	}
+	var notFixed int
+	for _, issue := range report.Issues {
+		fmt.Fprintf(os.Stdout, "%s\n", issue.String())
+		if !issue.Fixed {
+			notFixed++
+		}
+	}
+	if report.OK() {
+		fmt.Fprintf(os.Stdout, "Dsk (%s) filesystem is consistent\n", dskPath)
+		return false, "", ""
+	}
+	if repaired != nil {
+		if onError, message, hint = SaveDsk(*repaired, repairPath); onError {
+			return onError, message, hint
+		}
+		fmt.Fprintf(os.Stderr, "Repaired dsk saved in (%s)\n", repairPath)
+	}
+	if notFixed > 0 {
+		return true, fmt.Sprintf("%d issue(s) not fixed in dsk (%s)\n", notFixed, dskPath), "Use -repair fixed.dsk to fix what can be safely fixed"
+	}
+	return false, "", ""
+}
+
+// parseTrackSector reads a sector position as track:sector or track:head:sector
+func parseTrackSector(s string) (dsk.TrackSector, error) {
+	var pos dsk.TrackSector
+	fields := strings.Split(s, ":")
+	values := make([]int, len(fields))
+	for i, f := range fields {
+		v, err := strconv.Atoi(f)
+		if err != nil {
+			return pos, fmt.Errorf("%w: %s", dsk.ErrorSectorRange, s)
+		}
+		values[i] = v
+	}
+	switch len(values) {
+	case 2:
+		pos.Cyl, pos.Sect = values[0], values[1]
+	case 3:
+		pos.Cyl, pos.Head, pos.Sect = values[0], values[1], values[2]
+	default:
+		return pos, fmt.Errorf("%w: %s", dsk.ErrorSectorRange, s)
+	}
+	return pos, nil
+}
+
+// compactOptions reads the -keep list: file names and sector ranges as
+// first-last, each sector as track:sector or track:head:sector
+func compactOptions(keep string) (dsk.CompactOptions, error) {
+	opts := dsk.CompactOptions{}
+	if keep == "" {
+		return opts, nil
+	}
+	for _, k := range strings.Split(keep, ",") {
+		if !strings.Contains(k, ":") {
+			opts.Keep = append(opts.Keep, k)
+			continue
+		}
+		first, last, found := strings.Cut(k, "-")
+		if !found {
+			last = first
+		}
+		var r dsk.SectorRange
+		var err error
+		if r.First, err = parseTrackSector(first); err != nil {
+			return opts, err
+		}
+		if r.Last, err = parseTrackSector(last); err != nil {
+			return opts, err
+		}
+		opts.KeepSectors = append(opts.KeepSectors, r)
+	}
+	return opts, nil
+}
+
+func CompactDsk(d dsk.DSK, dskPath, keep string) (onError bool, message, hint string) {
+	opts, err := compactOptions(keep)
+	if err != nil {
+		return true, fmt.Sprintf("Cannot compact dsk (%s) error :%v\n", dskPath, err), "Set the sectors to keep as track:sector-track:sector (e.g. 1:0-3:8)"
+	}
+	moved, err := d.Compact(opts)
+	if err != nil {
+		if errors.Is(err, dsk.ErrorInconsistentFs) {
+			return true, fmt.Sprintf("Cannot compact dsk (%s) error :%v\n", dskPath, err), "Check your dsk with option -dsk yourdsk.dsk -fsck"
+		}
+		return true, fmt.Sprintf("Cannot compact dsk (%s) error :%v\n", dskPath, err), "Check the files set with -keep"
+	}
+	if onError, message, hint = SaveDsk(d, dskPath); onError {
+		return onError, message, hint
+	}
+	fmt.Fprintf(os.Stderr, "Dsk (%s) compacted, %d bloc(s) moved\n", dskPath, moved)
+	return false, "", ""
+}
+
+func ConvertDsk(d dsk.DSK, dskPath, format string) (onError bool, message, hint string) {
+	var c *dsk.DSK
+	var err error
+	switch strings.ToLower(format) {
+	case "dsk":
+		if c, err = dsk.ConvertToStandard(&d); err != nil {
+			return true, fmt.Sprintf("Cannot convert dsk (%s) error :%v\n", dskPath, err),
+				fmt.Sprintf("Keep this dsk as an extended dsk, %s", strings.Join(d.StandardIssues(), "; "))
+		}
+	case "edsk":
+		if c, err = dsk.ConvertToExtended(&d); err != nil {
+			return true, fmt.Sprintf("Cannot convert dsk (%s) error :%v\n", dskPath, err), "Keep this dsk as a standard dsk, its tracks are too big for an extended dsk"
+		}
+	default:
+		return true, fmt.Sprintf("Unknown dsk format (%s)\n", format), "Use -convert dsk or -convert edsk"
+	}
+	if onError, message, hint = SaveDsk(*c, dskPath); onError {
+		return onError, message, hint
+	}
+	fmt.Fprintf(os.Stderr, "Dsk (%s) converted to %s\n", dskPath, strings.ToLower(format))
+	return false, "", ""
+}
+
+func DiffDsk(d dsk.DSK, dskPath, otherPath string, desc DskDescriptor, quiet bool) (onError bool, message, hint string) {
+	if _, err := os.Stat(otherPath); err != nil {
+		return true, fmt.Sprintf("Cannot read dsk (%s) error :%v\n", otherPath, err), "dsk -dsk reference.dsk -diff rebuilt.dsk"
+	}
+	other, onError, message, hint := OpenDsk(otherPath, desc, quiet)
+	if onError {
+		return onError, message, hint
+	}
+	report := dsk.Diff(&d, &other)
+	for _, v := range report.Differences {
+		fmt.Fprintf(os.Stdout, "%s\n", v.String())
+	}
+	if !report.Equal() {
+		return true, fmt.Sprintf("%d difference(s) between dsk (%s) and dsk (%s)\n", len(report.Differences), dskPath, otherPath), "The differences are listed above"
+	}
+	fmt.Fprintf(os.Stdout, "Dsk (%s) and dsk (%s) are identical\n", dskPath, otherPath)
+	return false, "", ""
+}
+
+func MergeDsk(d dsk.DSK, dskPath, paths, policy string, desc DskDescriptor, quiet bool) (onError bool, message, hint string) {
+	sources := make([]*dsk.DSK, 0)
+	sourcePaths := strings.Split(paths, ",")
+	for _, path := range sourcePaths {
+		if _, err := os.Stat(path); err != nil {
+			return true, fmt.Sprintf("Cannot read dsk (%s) error :%v\n", path, err), "dsk -dsk output.dsk -merge first.dsk,second.dsk -policy rename"
+		}
+		src, onError, message, hint := OpenDsk(path, desc, quiet)
+		if onError {
+			return onError, message, hint
+		}
+		sources = append(sources, &src)
+	}
+	merged, err := d.Merge(dsk.MergePolicy(policy), sources...)
+	if err != nil {
+		if errors.Is(err, dsk.ErrorNoBloc) || errors.Is(err, dsk.ErrorNoDirEntry) {
+			return true, fmt.Sprintf("Files do not fit in dsk (%s) error :%v\n", dskPath, err), "Use a bigger disk format with -diskformat or merge fewer dsk"
+		}
+		return true, fmt.Sprintf("Cannot merge into dsk (%s) error :%v\n", dskPath, err), "Use -policy skip, overwrite, rename or user-shift"
+	}
+	if onError, message, hint = SaveDsk(d, dskPath); onError {
+		return onError, message, hint
+	}
+	for _, m := range merged {
+		switch {
+		case m.Skipped:
+			fmt.Fprintf(os.Stderr, "File (%s) user %d of dsk (%s) skipped\n", m.File.Filename(), m.File.User, sourcePaths[m.Source])
+		default:
+			fmt.Fprintf(os.Stderr, "File (%s) user %d of dsk (%s) merged as (%s) user %d\n", m.File.Filename(), m.File.User, sourcePaths[m.Source], m.Filename(), m.User)
+		}
+	}
+	return false, "", ""
+}
+
 func GetFileDsk(d dsk.DSK, fileInDsk, dskPath, directory string, removeHeader, quiet bool) (onError bool, message, hint string) {
 	if fileInDsk == "" {
 		return true, "amsdosfile option is empty, set it.", "dsk -dsk output.dsk -get hello.bin"
@@ -638,17 +1087,13 @@ func GetFileDsk(d dsk.DSK, fileInDsk, dskPath, directory string, removeHeader, q
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error while getting the catalogue in dsk error :%v\n", err)
 		}
-		var lastFilename string
-		for indice, v := range d.Catalogue {
-			if v.User != dsk.USER_DELETED && v.NbPages != 0 {
+		for _, indice := range d.GetFilesIndices() {
+			v := d.Catalogue[indice]
+			if v.NbPages != 0 {
 				var nom, ext string
 				nom = dsk.ToAscii(v.Nom[:])
 				ext = dsk.ToAscii(v.Ext[:])
 				filename := fmt.Sprintf("%s.%s", nom, ext)
-				if lastFilename == filename {
-					continue
-				}
-				lastFilename = filename
 				fmt.Fprintf(os.Stderr, "Filename to get : %s\n", filename)
 				content, err := d.GetFileIn(filename, indice)
 				if err != nil {
@@ -772,17 +1217,18 @@ func RawExportDsk(d dsk.DSK, fileInDsk string, desc DskDescriptor, size int, qui
 		desc.Path,
 		size,
 	)
-	endedTrack, endedSector, content := d.ExtractRawFile(uint16(size), desc.Track, desc.Sector)
+	endedTrack, endedHead, endedSector, content := d.ExtractRawFile(uint16(size), desc.Track, 0, desc.Sector)
 
 	if err := utils.Save(fileInDsk, content); err != nil {
 		return true, fmt.Sprintf("Error while write file (%s) error %v\n", fileInDsk, err), "Check your dsk  with option -dsk yourdsk.dsk -analyze"
 	}
-	informations := fmt.Sprintf("raw extract to file [%s] size [%d] starting at track [%d] sector [%d] and ending at track [%d] sector [%d]",
+	informations := fmt.Sprintf("raw extract to file [%s] size [%d] starting at track [%d] sector [%d] and ending at track [%d] head [%d] sector [%d]",
 		fileInDsk,
 		size,
 		desc.Track,
 		desc.Sector,
 		endedTrack,
+		endedHead,
 		endedSector)
 	msg.ResumeAction(desc.Path, "raw export ", fileInDsk, informations, quiet)
 	return false, "", ""
@@ -840,7 +1286,7 @@ func FileinfoDsk(d dsk.DSK, fileInDsk string) (onError bool, message, hint strin
 		}
 		isAmsdos, header := amsdos.CheckAmsdos(content)
 		if !isAmsdos {
-			entry, err := d.GetInfoDirEntry(uint8(indice))
+			entry, err := d.GetInfoDirEntry(indice)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Error while getting file entry in dsk error :%v\n", err)
 			}
@@ -875,7 +1321,7 @@ func RawImportDataInDsk(d dsk.DSK, fileInDsk string, desc DskDescriptor, content
 	if desc.Sector == 9 {
 		fmt.Fprintf(os.Stdout, "Warning the starting sector is set as default : [%d]\n", desc.Sector)
 	}
-	endedTrack, endedSector, err := d.CopyRawFile(content, uint16(len(content)), desc.Track, desc.Sector)
+	endedTrack, endedHead, endedSector, err := d.CopyRawFile(content, uint16(len(content)), desc.Track, 0, desc.Sector)
 	if err != nil {
 		return true, fmt.Sprintf("Cannot write file %s error :%v\n", fileInDsk, err), "Check your file path"
 	}
@@ -888,12 +1334,13 @@ func RawImportDataInDsk(d dsk.DSK, fileInDsk string, desc DskDescriptor, content
 	if err := d.Write(f); err != nil {
 		return true, fmt.Sprintf("Error while write file (%s) error %v\n", desc.Path, err), "Check your dsk  with option -dsk yourdsk.dsk -analyze"
 	}
-	informations := fmt.Sprintf("raw copy file [%s] size [%d] starting at track [%d] sector [%d] and ending at track [%d] sector [%d]",
+	informations := fmt.Sprintf("raw copy file [%s] size [%d] starting at track [%d] sector [%d] and ending at track [%d] head [%d] sector [%d]",
 		fileInDsk,
 		len(content),
 		desc.Track,
 		desc.Sector,
 		endedTrack,
+		endedHead,
 		endedSector)
 	msg.ResumeAction(desc.Path, "raw import ", fileInDsk, informations, quiet)
 	return false, "", ""
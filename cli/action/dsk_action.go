@@ -11,6 +11,15 @@ var (
 	ActionAnalyseDsk         DskTask = "analyze"
 	ActionPutFileDsk         DskTask = "put"
 	ActionRemoveFileDsk      DskTask = "remove"
+	ActionRenameFileDsk      DskTask = "rename"
+	ActionChangeUserFileDsk  DskTask = "chuser"
+	ActionAttributesFileDsk  DskTask = "attrib"
+	ActionUndeleteFileDsk    DskTask = "undelete"
+	ActionFsckDsk            DskTask = "fsck"
+	ActionCompactDsk         DskTask = "compact"
+	ActionDiffDsk            DskTask = "diff"
+	ActionMergeDsk           DskTask = "merge"
+	ActionConvertDsk         DskTask = "convert"
 	ActionGetFileDsk         DskTask = "get"
 	ActionGetAllFileDsk      DskTask = "getall"
 	ActionAsciiFileDsk       DskTask = "ascii"
@@ -20,6 +29,10 @@ var (
 	ActionHFEFileinfoDsk     DskTask = "hfe"
 	ActionConvertHFEToDSK    DskTask = "todsk"
 	ActionConvertDSKToHFE    DskTask = "tohfe"
+	ActionImgFile            DskTask = "img"
+	ActionConvertDSKToImg    DskTask = "toimg"
+	ActionFluxFile           DskTask = "flux"
+	ActionIPFFile            DskTask = "ipf"
 )
 
 type DskTaskFile struct {
@@ -87,6 +100,30 @@ func (a *DskTasks) WithActionRemoveFileDsk(path string, isSet bool) *DskTasks {
 	}
 	return a
 }
+func (a *DskTasks) WithActionRenameFileDsk(path string, isSet bool) *DskTasks {
+	if isSet {
+		a.a = append(a.a, DskTaskFile{File: path, a: ActionRenameFileDsk})
+	}
+	return a
+}
+func (a *DskTasks) WithActionChangeUserFileDsk(path string, isSet bool) *DskTasks {
+	if isSet {
+		a.a = append(a.a, DskTaskFile{File: path, a: ActionChangeUserFileDsk})
+	}
+	return a
+}
+func (a *DskTasks) WithActionAttributesFileDsk(path string, isSet bool) *DskTasks {
+	if isSet {
+		a.a = append(a.a, DskTaskFile{File: path, a: ActionAttributesFileDsk})
+	}
+	return a
+}
+func (a *DskTasks) WithActionUndeleteFileDsk(path string, isSet bool) *DskTasks {
+	if isSet {
+		a.a = append(a.a, DskTaskFile{File: path, a: ActionUndeleteFileDsk})
+	}
+	return a
+}
 func (a *DskTasks) WithActionGetFileDsk(path string, isSet bool) *DskTasks {
 	if isSet {
 		a.a = append(a.a, DskTaskFile{File: path, a: ActionGetFileDsk})
@@ -145,3 +182,66 @@ func (a *DskTasks) WithActionConvertDSKToHFE(path string, isSet bool) *DskTasks
 	}
 	return a
 }
+
+func (a *DskTasks) WithActionImgFile(path string, isSet bool) *DskTasks {
+	if isSet {
+		a.a = append(a.a, DskTaskFile{File: path, a: ActionImgFile})
+	}
+	return a
+}
+
+func (a *DskTasks) WithActionConvertDSKToImg(path string, isSet bool) *DskTasks {
+	if isSet {
+		a.a = append(a.a, DskTaskFile{File: path, a: ActionConvertDSKToImg})
+	}
+	return a
+}
+
+func (a *DskTasks) WithActionFluxFile(path string, isSet bool) *DskTasks {
+	if isSet {
+		a.a = append(a.a, DskTaskFile{File: path, a: ActionFluxFile})
+	}
+	return a
+}
+
+func (a *DskTasks) WithActionIPFFile(path string, isSet bool) *DskTasks {
+	if isSet {
+		a.a = append(a.a, DskTaskFile{File: path, a: ActionIPFFile})
+	}
+	return a
+}
+
+func (a *DskTasks) WithActionFsckDsk(repairPath string, isSet bool) *DskTasks {
+	if isSet {
+		a.a = append(a.a, DskTaskFile{File: repairPath, a: ActionFsckDsk})
+	}
+	return a
+}
+
+func (a *DskTasks) WithActionCompactDsk(keep string, isSet bool) *DskTasks {
+	if isSet {
+		a.a = append(a.a, DskTaskFile{File: keep, a: ActionCompactDsk})
+	}
+	return a
+}
+
+func (a *DskTasks) WithActionDiffDsk(path string, isSet bool) *DskTasks {
+	if isSet {
+		a.a = append(a.a, DskTaskFile{File: path, a: ActionDiffDsk})
+	}
+	return a
+}
+
+func (a *DskTasks) WithActionMergeDsk(paths string, isSet bool) *DskTasks {
+	if isSet {
+		a.a = append(a.a, DskTaskFile{File: paths, a: ActionMergeDsk})
+	}
+	return a
+}
+
+func (a *DskTasks) WithActionConvertDsk(format string, isSet bool) *DskTasks {
+	if isSet {
+		a.a = append(a.a, DskTaskFile{File: format, a: ActionConvertDsk})
+	}
+	return a
+}
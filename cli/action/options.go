@@ -12,6 +12,11 @@ type Options struct {
 	removeHeader bool
 	rawImport    bool
 	rawExport    bool
+	readOnly     bool
+	archived     bool
+	mergePolicy  string
+	sectorOrder  string
+	hfeV3        bool
 }
 
 func NewOptions() *Options {
@@ -72,3 +77,28 @@ func (o *Options) WithRawExport(rawExport bool) *Options {
 	o.rawExport = rawExport
 	return o
 }
+
+func (o *Options) WithReadOnly(readOnly bool) *Options {
+	o.readOnly = readOnly
+	return o
+}
+
+func (o *Options) WithArchived(archived bool) *Options {
+	o.archived = archived
+	return o
+}
+
+func (o *Options) WithMergePolicy(policy string) *Options {
+	o.mergePolicy = policy
+	return o
+}
+
+func (o *Options) WithSectorOrder(sectorOrder string) *Options {
+	o.sectorOrder = sectorOrder
+	return o
+}
+
+func (o *Options) WithHFEV3(hfeV3 bool) *Options {
+	o.hfeV3 = hfeV3
+	return o
+}
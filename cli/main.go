@@ -26,6 +26,12 @@ var (
 	disassemble    = flag.String("disassemble", "", "Disassemble an AMSDOS file.")
 	get            = flag.String("get", "", "\tExtract a file from the DSK file.")
 	remove         = flag.String("remove", "", "Remove the AMSDOS file from the DSK file.")
+	undelete       = flag.String("undelete", "", "Recover an erased AMSDOS file of the DSK file into the user area set by -user.")
+	rename         = flag.String("rename", "", "Rename the AMSDOS file of the DSK file to the name set by -newname.")
+	newName        = flag.String("newname", "", "New name of the file renamed with -rename.")
+	chuser         = flag.String("chuser", "", "Move the AMSDOS file of the user area set by -user to the user area set by -newuser.")
+	newUser        = flag.Int("newuser", 0, "New user number of the file moved with -chuser.")
+	attrib         = flag.String("attrib", "", "Set the attributes of the AMSDOS file of the DSK file from -readonly, -hide and -archive.")
 	basic          = flag.String("basic", "", "Display a basic AMSDOS file.")
 	put            = flag.String("put", "", "\tInsert the AMSDOS file into the DSK file.")
 	executeAddress = flag.String("exec", "", "Execution address for the inserted file (hexadecimal format, e.g., #170 allowed).")
@@ -34,11 +40,12 @@ var (
 	force          = flag.Bool("force", false, "Force overwrite of an existing file in the DSK.")
 	//fileType       = flag.String("type", "", "Type of the inserted file: 'ascii' or 'binary'.")
 	snaPath      = flag.String("sna", "", "\tPath to the SNA file to handle.")
-	analyse      = flag.Bool("analyze", false, "Analyze and display the DSK header.")
+	analyse      = flag.Bool("analyze", false, "Analyze and display the DSK header, or decode and check every track of the HFE file given with -hfe.")
 	cpcType      = flag.Int("cpctype", 2, "CPC type for SNA import: 0 = CPC464, 1 = CPC664, 2 = CPC6128, 3 = Unknown, 4 = CPCPlus6128, 5 = CPCPlus464, 6 = GX4000.")
 	screenMode   = flag.Int("screenmode", 1, "Screen mode parameter for SNA files.")
 	vendorFormat = flag.Bool("vendor", false, "Use vendor format for formatting (sector count = #09, last track = #27).")
 	dataFormat   = flag.Bool("data", true, "Use data format for formatting (sector count = #09, last track = #27).")
+	diskFormat   = flag.String("diskformat", "", "Disk format preset for formatting: data, vendor, ibm, parados, romdos-d1, romdos-d2, romdos-d10 or ms800.")
 	rawimport    = flag.Bool("rawimport", false, "Perform a raw import of an AMSDOS file. Requires '-dsk', '-track', and '-sector' options. \n\t\tCopies the file directly starting from the specified track and sector. e.g.: dsk -dsk mydskfile.dsk -put file.bin -rawimport -track 1 -sector 0")
 	rawexport    = flag.Bool("rawexport", false, "Perform a raw export of an AMSDOS file. Requires '-dsk', '-track', '-sector', and '-size' options. \n\t\tExtracts the file content from the specified track and sector up to the given size. e.g.: dsk -dsk mydskfile.dsk -get file.bin -rawexport -track 1 -sector 0 -size 16384")
 	size         = flag.Int("size", 0, "Size of data to extract for 'rawexport'. See 'rawexport' for details.")
@@ -48,10 +55,26 @@ var (
 	quiet        = flag.Bool("quiet", false, "Suppress unnecessary output (useful for scripting).")
 	stdoutOpt    = flag.Bool("stdout", false, "To redirect to stdout when using get file")
 	hidden       = flag.Bool("hide", false, "Hide the imported file")
+	readOnly     = flag.Bool("readonly", false, "Set the read-only attribute of the imported file")
+	archived     = flag.Bool("archive", false, "Set the archive attribute of the file (with -attrib)")
 	removeHeader = flag.Bool("removeheader", false, "Remove amsdos header from exported file")
 	hfeFilepath  = flag.String("hfe", "", "Path to the HFE file to handle.")
-	toDsk        = flag.String("todsk", "", "Convert the specified HFE file to DSK format.")
+	toDsk        = flag.String("todsk", "", "Convert the HFE, raw image, flux image or IPF file to the specified DSK file.")
 	toHfe        = flag.String("tohfe", "", "Convert the specified DSK file to HFE format.")
+	hfeV3        = flag.Bool("hfev3", false, "Write the HFE file of -tohfe in the HFE v3 format (weak sectors, bit rate per track).")
+	imgFilepath  = flag.String("img", "", "Path to the raw sector image file (.img, .raw) to handle, its geometry is given by -diskformat and -head.")
+	toImg        = flag.String("toimg", "", "Convert the DSK file to the specified raw sector image file (.img, .raw).")
+	fluxFilepath = flag.String("flux", "", "Path to the flux image to handle: a SuperCard Pro file (.scp) or a KryoFlux stream directory.")
+	ipfFilepath  = flag.String("ipf", "", "Path to the IPF (SPS/CAPS) image to handle.")
+	order        = flag.String("order", "sides", "Track order of the raw sector image: sides, outout or outback.")
+	fsck         = flag.Bool("fsck", false, "Check the consistency of the DSK filesystem (cross-linked blocks, orphan extents, ...).")
+	repair       = flag.String("repair", "", "Check the DSK filesystem and write a repaired copy to the specified DSK file.")
+	compact      = flag.Bool("compact", false, "Rewrite the files of the DSK in contiguous blocks and clean the deleted entries of the directory.")
+	diff         = flag.String("diff", "", "Compare the DSK file to the specified DSK file, file by file and sector by sector.")
+	merge        = flag.String("merge", "", "Comma separated list of DSK files whose files are merged into the DSK file.")
+	policy       = flag.String("policy", "skip", "Name conflict policy of -merge: skip, overwrite, rename or user-shift.")
+	keep         = flag.String("keep", "", "Comma separated list of files and sector ranges left in place by -compact (e.g. LOADER.BIN,DATA.BIN,1:0-3:8), a sector is track:sector or track:head:sector.")
+	convert      = flag.String("convert", "", "Convert the DSK file to a standard (dsk) or an extended (edsk) DSK.")
 
 	appVersion = "0.37"
 	version    = flag.Bool("version", false, "Display the application version and exit.")
@@ -62,13 +85,19 @@ func main() {
 
 	flag.Usage = sampleUsage
 	flag.Parse()
+	if flag.NArg() == 3 && flag.Arg(0) == "diff" {
+		// dsk diff a.dsk b.dsk
+		*dskPath, *diff = flag.Arg(1), flag.Arg(2)
+	}
 
 	fd := action.NewAmsdosFileDescriptor().
 		WithUser(uint16(*user)).
 		AddExec(*executeAddress).
 		AddLoad(*loadingAddress).
 		WithAddHeader(*executeAddress != "" || *loadingAddress != "").
-		WithPaths(*put, *get, *basic, *hexa, *disassemble, *ascii, *remove, *info)
+		WithNewName(*newName).
+		WithNewUser(uint16(*newUser)).
+		WithPaths(*put, *get, *basic, *hexa, *disassemble, *ascii, *remove, *undelete, *rename, *chuser, *attrib, *info)
 
 	opts := action.NewOptions().
 		WithQuiet(*quiet).
@@ -79,6 +108,11 @@ func main() {
 		WithVendorFormat(*vendorFormat).
 		WithStdout(*stdoutOpt).
 		WithHidden(*hidden).
+		WithReadOnly(*readOnly).
+		WithArchived(*archived).
+		WithMergePolicy(*policy).
+		WithSectorOrder(*order).
+		WithHFEV3(*hfeV3).
 		WithRemoveHeader(*removeHeader).
 		WithRawImport(*rawimport).
 		WithRawExport(*rawexport)
@@ -92,6 +126,10 @@ func main() {
 		WithActionAnalyseDsk(*dskPath, *analyse).
 		WithActionPutFileDsk(*dskPath, *put != "").
 		WithActionRemoveFileDsk(*dskPath, *remove != "").
+		WithActionRenameFileDsk(*dskPath, *rename != "").
+		WithActionChangeUserFileDsk(*dskPath, *chuser != "").
+		WithActionAttributesFileDsk(*dskPath, *attrib != "").
+		WithActionUndeleteFileDsk(*dskPath, *undelete != "").
 		WithActionGetFileDsk(*dskPath, *get != "").
 		WithActionAsciiFileDsk(*dskPath, *ascii != "").
 		WithActionRawExportDsk(*dskPath, *rawexport).
@@ -100,13 +138,23 @@ func main() {
 		WithActionGetAllFileDsk(*autoextract, *autoextract != "").
 		WithActionHFEFile(*hfeFilepath, *hfeFilepath != "").
 		WithActionConvertHFEToDSK(*toDsk, *toDsk != "").
-		WithActionConvertDSKToHFE(*toHfe, *toHfe != "")
+		WithActionConvertDSKToHFE(*toHfe, *toHfe != "").
+		WithActionImgFile(*imgFilepath, *imgFilepath != "").
+		WithActionConvertDSKToImg(*toImg, *toImg != "").
+		WithActionFluxFile(*fluxFilepath, *fluxFilepath != "").
+		WithActionIPFFile(*ipfFilepath, *ipfFilepath != "").
+		WithActionFsckDsk(*repair, *fsck || *repair != "").
+		WithActionCompactDsk(*keep, *compact).
+		WithActionDiffDsk(*diff, *diff != "").
+		WithActionMergeDsk(*merge, *merge != "").
+		WithActionConvertDsk(*convert, *convert != "")
 
 	desc := action.NewDskDescriptor().
 		WithSector(*sector).
 		WithTrack(*track).
 		WithHead(*heads).
-		WithType(*dskType)
+		WithType(*dskType).
+		WithFormat(*diskFormat)
 
 	dskAct := action.NewAction(*dskPath, *autoextract).
 		WithOptions(*opts).
@@ -177,9 +225,16 @@ func sampleUsage() {
 	fmt.Fprintf(os.Stderr, "\nHere are some sample usages:\n"+
 		//"  dsk -dsk input.dsk -toHfe output.hfe			# Convert a DSK file to HFE format.\n"+
 		"  dsk -hfe input.hfe -toDsk output.dsk			# Convert an HFE file to DSK format.\n"+
+		"  dsk -hfe input.hfe -analyze                  # Check the sectors and CRCs of every track of an HFE file.\n"+
+		"  dsk -dsk input.dsk -toimg output.img -order sides  # Export the sectors of a DSK file to a raw image.\n"+
+		"  dsk -img input.img -diskformat data -todsk output.dsk  # Convert a raw image to DSK format.\n"+
+		"  dsk -img gotek.img -diskformat data -head 2 -todsk output.dsk  # Convert a double sided raw image to DSK format.\n"+
+		"  dsk -flux input.scp -todsk output.dsk        # Decode a SuperCard Pro or KryoFlux flux image to an extended DSK.\n"+
+		"  dsk -ipf input.ipf -list                     # List the contents of an IPF image.\n"+
 		"  dsk -dsk output.dsk -format                  # Create an empty simple DSK file.\n"+
 		"  dsk -dsk output.dsk -format -sector 8 -track 42  # Create a simple empty DSK file with custom tracks and sectors.\n"+
 		"  dsk -dsk output.dsk -format -sector 8 -track 42 -dsktype 1 -head 2  # Create an empty extended DSK file with custom heads, tracks, and sectors.\n"+
+		"  dsk -dsk output.dsk -format -diskformat parados  # Create an empty DSK file with the Parados 80 tracks format.\n"+
 		"  dsk -sna output.sna                          # Create an empty SNA file.\n"+
 		"  dsk -info hello.bin                          # Display header informations on the file hello.bin"+
 		"  dsk -dsk output.dsk -list                    # List the contents of the DSK file.\n"+
@@ -187,6 +242,17 @@ func sampleUsage() {
 		"  dsk -dsk output.dsk -info hello.bin          # Get information about a file in the DSK.\n"+
 		"  dsk -dsk output.dsk -hex hello.bin           # Display the file content in hexadecimal format from the DSK file.\n"+
 		"  dsk -dsk output.dsk -put hello.bin -exec \"#1000\" -load \"500\"  # Insert a file into the DSK file.\n"+
+		"  dsk -dsk output.dsk -rename hello.bin -newname world.bin  # Rename the file hello.bin to world.bin.\n"+
+		"  dsk -dsk output.dsk -chuser hello.bin -user 0 -newuser 2  # Move the file hello.bin from the user area 0 to 2.\n"+
+		"  dsk -dsk output.dsk -attrib hello.bin -readonly -hide  # Set the read-only and system attributes of hello.bin.\n"+
+		"  dsk -dsk output.dsk -undelete hello.bin -user 0  # Recover the erased file hello.bin in the user area 0.\n"+
+		"  dsk -dsk input.dsk -fsck                     # Check the consistency of the DSK filesystem.\n"+
+		"  dsk -dsk input.dsk -repair fixed.dsk         # Save a repaired copy of the DSK file.\n"+
+		"  dsk -dsk input.dsk -compact -keep loader.bin  # Defragment the DSK file, loader.bin stays in place.\n"+
+		"  dsk -dsk input.dsk -compact -keep 1:0-3:8  # Defragment the DSK file, the sectors raw imported from track 1 sector 0 to track 3 sector 8 stay in place.\n"+
+		"  dsk -dsk compil.dsk -merge a.dsk,b.dsk -policy rename  # Merge the files of a.dsk and b.dsk into compil.dsk.\n"+
+		"  dsk -dsk input.dsk -convert edsk             # Convert the DSK file to an extended DSK.\n"+
+		"  dsk diff reference.dsk rebuilt.dsk           # Compare two DSK files, same as dsk -dsk reference.dsk -diff rebuilt.dsk.\n"+
 		"  dsk -sna output.sna -put hello.bin -exec \"#1000\" -load 500 -screenmode 0 -cpctype 4  # Insert a file into the SNA file (for a CPC Plus system).\n\n")
 	fmt.Printf(("Options:\n"))
 	flag.VisitAll(func(f *flag.Flag) {
@@ -445,6 +511,7 @@ func resetArguments() {
 	*screenMode = 1
 	*vendorFormat = false
 	*dataFormat = true
+	*diskFormat = ""
 	*rawimport = false
 	*rawexport = false
 	*size = 0
@@ -622,7 +689,7 @@ func putFileBinaryDataTest(filePath, dskFilepath string, hide bool) bool {
 		User: uint16(*user),
 		Type: action.AmsdosTypeBinary,
 	}
-	isError, _, _ := action.PutFileDsk(d, dskFilepath, fd, hide, false, *quiet)
+	isError, _, _ := action.PutFileDsk(d, dskFilepath, fd, hide, false, false, *quiet)
 	return isError
 }
 
@@ -0,0 +1,123 @@
+package dsk
+
+import (
+	"errors"
+)
+
+var ErrorBadUser = errors.New("user number out of range (0-31)")
+
+// Attributes are the CP/M attributes of a file, stored in bit 7 of the
+// extension bytes of each of its directory entries
+type Attributes struct {
+	ReadOnly bool
+	System   bool // hidden from the catalogue
+	Archived bool
+}
+
+// entryAttributes returns the attributes of the directory entry
+func entryAttributes(e StDirEntry) Attributes {
+	return Attributes{
+		ReadOnly: e.Ext[0]&0x80 != 0,
+		System:   e.Ext[1]&0x80 != 0,
+		Archived: e.Ext[2]&0x80 != 0,
+	}
+}
+
+// set stores the attributes in the extension bytes of the directory entry
+func (a Attributes) set(e *StDirEntry) {
+	for i, on := range []bool{a.ReadOnly, a.System, a.Archived} {
+		e.Ext[i] &= 0x7F
+		if on {
+			e.Ext[i] |= 0x80
+		}
+	}
+}
+
+// lookupName returns the file of the user matching the name given as NAME.EXT
+func (d *DSK) lookupName(name string, user uint8) (CatalogueFile, error) {
+	entry := d.GetNomDir(GetNomAmsdos(name), false)
+	f, err := d.LookupFile(user, entry.Nom, entry.Ext)
+	if err != nil && !errors.Is(err, ErrorMissingExtent) && !errors.Is(err, ErrorDuplicateExtent) {
+		return CatalogueFile{}, err
+	}
+	return f, nil
+}
+
+// updateEntries applies update to every directory entry of the file
+func (d *DSK) updateEntries(f CatalogueFile, update func(*StDirEntry)) error {
+	for _, i := range f.Indices {
+		e, err := d.GetInfoDirEntry(i)
+		if err != nil {
+			return err
+		}
+		update(&e)
+		if err := d.SetInfoDirEntry(i, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename renames the file of the user in every extent of the directory,
+// the attributes are kept. ErrorFileExists is returned if the new name is
+// already used by another file of the user.
+func (d *DSK) Rename(name string, user uint8, newName string) (CatalogueFile, error) {
+	f, err := d.lookupName(name, user)
+	if err != nil {
+		return CatalogueFile{}, err
+	}
+	target := d.GetNomDir(GetNomAmsdos(newName), false)
+	if target.Nom == f.Nom && target.Ext == f.Ext {
+		return f, nil
+	}
+	if _, err := d.LookupFile(user, target.Nom, target.Ext); !errors.Is(err, ErrorFileNotFound) {
+		return CatalogueFile{}, ErrorFileExists
+	}
+	err = d.updateEntries(f, func(e *StDirEntry) {
+		for i := range e.Nom {
+			e.Nom[i] = e.Nom[i]&0x80 | target.Nom[i]
+		}
+		for i := range e.Ext {
+			e.Ext[i] = e.Ext[i]&0x80 | target.Ext[i]
+		}
+	})
+	if err != nil {
+		return CatalogueFile{}, err
+	}
+	return d.lookupName(newName, user)
+}
+
+// SetAttributes sets the read-only, system and archive attributes of every
+// extent of the file of the user.
+func (d *DSK) SetAttributes(name string, user uint8, attrs Attributes) (CatalogueFile, error) {
+	f, err := d.lookupName(name, user)
+	if err != nil {
+		return CatalogueFile{}, err
+	}
+	if err := d.updateEntries(f, attrs.set); err != nil {
+		return CatalogueFile{}, err
+	}
+	return d.lookupName(name, user)
+}
+
+// ChangeUser moves the file of the user to the user area newUser.
+// ErrorFileExists is returned if newUser already has a file with the same name.
+func (d *DSK) ChangeUser(name string, user, newUser uint8) (CatalogueFile, error) {
+	if newUser >= 0x20 {
+		return CatalogueFile{}, ErrorBadUser
+	}
+	f, err := d.lookupName(name, user)
+	if err != nil {
+		return CatalogueFile{}, err
+	}
+	if newUser == user {
+		return f, nil
+	}
+	if _, err := d.LookupFile(newUser, f.Nom, f.Ext); !errors.Is(err, ErrorFileNotFound) {
+		return CatalogueFile{}, ErrorFileExists
+	}
+	if err := d.updateEntries(f, func(e *StDirEntry) { e.User = newUser }); err != nil {
+		return CatalogueFile{}, err
+	}
+	return d.lookupName(name, newUser)
+}
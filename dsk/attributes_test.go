@@ -0,0 +1,69 @@
+package dsk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRename(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	data := putTestFile(t, d, "BIG.BIN", 40000, 0) // 3 extents
+	putTestFile(t, d, "OTHER.BIN", 1000, 0)
+	_, err := d.SetAttributes("big.bin", 0, Attributes{ReadOnly: true})
+	assert.NoError(t, err)
+
+	_, err = d.Rename("big.bin", 0, "other.bin")
+	assert.ErrorIs(t, err, ErrorFileExists)
+	_, err = d.Rename("none.bin", 0, "new.bin")
+	assert.ErrorIs(t, err, ErrorFileNotFound)
+
+	f, err := d.Rename("big.bin", 0, "renamed.dat")
+	assert.NoError(t, err)
+	assert.Equal(t, "RENAMED.DAT", f.Filename())
+	assert.Len(t, f.Entries, 3)
+	for _, e := range f.Entries {
+		assert.True(t, entryAttributes(e).ReadOnly)
+	}
+	assert.Equal(t, data, d.fileContent(f))
+	_, err = d.lookupName("big.bin", 0)
+	assert.ErrorIs(t, err, ErrorFileNotFound)
+}
+
+func TestSetAttributes(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	putTestFile(t, d, "BIG.BIN", 40000, 0)
+	attrs := Attributes{ReadOnly: true, System: true, Archived: true}
+	f, err := d.SetAttributes("big.bin", 0, attrs)
+	assert.NoError(t, err)
+	for _, i := range f.Indices {
+		e, _ := d.GetInfoDirEntry(i)
+		assert.Equal(t, attrs, entryAttributes(e))
+		assert.Equal(t, "BIN", ToAscii(e.Ext[:]))
+	}
+
+	f, err = d.SetAttributes("big.bin", 0, Attributes{Archived: true})
+	assert.NoError(t, err)
+	for _, e := range f.Entries {
+		assert.Equal(t, Attributes{Archived: true}, entryAttributes(e))
+	}
+}
+
+func TestChangeUser(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	data := putTestFile(t, d, "BIG.BIN", 40000, 0)
+	putTestFile(t, d, "BIG.BIN", 1000, 3)
+
+	_, err := d.ChangeUser("big.bin", 0, 3)
+	assert.ErrorIs(t, err, ErrorFileExists)
+	_, err = d.ChangeUser("big.bin", 0, 0x20)
+	assert.ErrorIs(t, err, ErrorBadUser)
+
+	f, err := d.ChangeUser("big.bin", 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(2), f.User)
+	assert.Len(t, f.Entries, 3)
+	assert.Equal(t, data, d.fileContent(f))
+	_, err = d.lookupName("big.bin", 0)
+	assert.ErrorIs(t, err, ErrorFileNotFound)
+}
@@ -0,0 +1,205 @@
+package dsk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrorInconsistentFs = errors.New("filesystem is not consistent, repair it first")
+	ErrorCompactVerify  = errors.New("file content changed while compacting")
+	ErrorSectorRange    = errors.New("invalid sector range")
+)
+
+// TrackSector is the position of a sector on the disk, the sector being
+// numbered from 0 for the first sector ID of the track as by CopyRawFile
+type TrackSector struct {
+	Cyl, Head, Sect int
+}
+
+// SectorRange is the sectors from First to Last included, following the
+// order in which CopyRawFile writes them
+type SectorRange struct {
+	First, Last TrackSector
+}
+
+// CompactOptions describes how Compact rewrites the dsk.
+// Keep lists the files (NAME.EXT, any user) whose blocs must not move,
+// for loaders reading them directly from their track and sector.
+// KeepSectors lists the areas written outside of the catalogue (raw
+// imports), no file is moved on their blocs.
+type CompactOptions struct {
+	Keep        []string
+	KeepSectors []SectorRange
+}
+
+// rangeBlocs returns the blocs holding the sectors of the range,
+// the sectors of the reserved tracks have no bloc
+func (d *DSK) rangeBlocs(p DiskParams, r SectorRange) ([]int, error) {
+	heads := max(int(d.Entry.NbHeads), 1)
+	spt := p.SectorsPerTrack()
+	sector := func(pos TrackSector) (int, error) {
+		if pos.Head < 0 || pos.Head >= heads || pos.Cyl < 0 || pos.Sect < 0 || pos.Sect >= spt {
+			return 0, fmt.Errorf("%w: track %d head %d sector %d", ErrorSectorRange, pos.Cyl, pos.Head, pos.Sect)
+		}
+		return (pos.Cyl*heads+pos.Head)*spt + pos.Sect, nil
+	}
+	first, err := sector(r.First)
+	if err != nil {
+		return nil, err
+	}
+	last, err := sector(r.Last)
+	if err != nil {
+		return nil, err
+	}
+	if last < first {
+		return nil, fmt.Errorf("%w: track %d head %d sector %d is before track %d head %d sector %d",
+			ErrorSectorRange, r.Last.Cyl, r.Last.Head, r.Last.Sect, r.First.Cyl, r.First.Head, r.First.Sect)
+	}
+	blocs := make([]int, 0)
+	for s := max(first, p.blocSector(0)); s <= last; s++ {
+		b := (s - p.blocSector(0)) / p.SectorsPerBloc()
+		if b > int(p.DSM) {
+			break
+		}
+		if len(blocs) == 0 || blocs[len(blocs)-1] != b {
+			blocs = append(blocs, b)
+		}
+	}
+	return blocs, nil
+}
+
+// Compact rewrites the files in contiguous blocs following the catalogue
+// order and rebuilds the directory without the deleted entries, entries
+// which are not files (labels, ...) are moved to the top of the directory.
+// The blocs of the kept files and sectors are left in place and skipped by
+// the others.
+// The content of every file is verified afterwards, the dsk is left untouched
+// on error. It returns the number of blocs which have moved.
+func (d *DSK) Compact(opts CompactOptions) (int, error) {
+	if r := d.Fsck(); !r.OK() {
+		return 0, fmt.Errorf("%w: %s", ErrorInconsistentFs, r.Issues[0])
+	}
+	files, err := d.Files()
+	if err != nil {
+		return 0, err
+	}
+	p := d.DiskParams()
+
+	kept := make(map[fileKey]bool)
+	for _, name := range opts.Keep {
+		entry := d.GetNomDir(GetNomAmsdos(name), false)
+		var found bool
+		for _, f := range files {
+			if f.Nom == entry.Nom && f.Ext == entry.Ext {
+				kept[fileKey{user: f.User, nom: f.Nom, ext: f.Ext}], found = true, true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("%w: %s", ErrorFileNotFound, name)
+		}
+	}
+
+	// blocs reserved by the directory, the kept files and sectors
+	reserved := make([]bool, int(p.DSM)+1)
+	dirBlocs := uint16(p.AL0)<<8 | uint16(p.AL1)
+	for b := 0; b < 16 && b < len(reserved); b++ {
+		reserved[b] = dirBlocs&(0x8000>>b) != 0
+	}
+	for _, f := range files {
+		if kept[fileKey{user: f.User, nom: f.Nom, ext: f.Ext}] {
+			for _, e := range f.Entries {
+				for _, b := range p.EntryBlocs(e) {
+					reserved[b] = true
+				}
+			}
+		}
+	}
+	for _, r := range opts.KeepSectors {
+		blocs, err := d.rangeBlocs(p, r)
+		if err != nil {
+			return 0, err
+		}
+		for _, b := range blocs {
+			reserved[b] = true
+		}
+	}
+
+	// new directory: other entries (labels, ...) first then the files
+	c := d.Clone()
+	entries := make([]StDirEntry, 0, p.DirEntries())
+	for _, e := range d.Catalogue {
+		if !isFileEntry(e) && e.User != USER_DELETED {
+			entries = append(entries, e)
+		}
+	}
+	var moved int
+	next := 0
+	for _, f := range files {
+		keep := kept[fileKey{user: f.User, nom: f.Nom, ext: f.Ext}]
+		for _, e := range f.Entries {
+			if !keep {
+				blocs := p.EntryBlocs(e)
+				e.Blocks = [16]byte{}
+				for i, b := range blocs {
+					for next < len(reserved) && reserved[next] {
+						next++
+					}
+					if next >= len(reserved) {
+						return 0, ErrorNoBloc
+					}
+					if int(b) != next {
+						moved++
+					}
+					if err := c.WriteBloc(next, d.ReadBloc(int(b)), 0); err != nil {
+						return 0, err
+					}
+					p.SetEntryBloc(&e, i, uint16(next))
+					next++
+				}
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	// the directory is cleaned of the deleted entries
+	for i := 0; i < p.DirEntries(); i++ {
+		e := emptyDirEntry()
+		if i < len(entries) {
+			e = entries[i]
+		}
+		if err := c.SetInfoDirEntry(i, e); err != nil {
+			return 0, err
+		}
+	}
+	c.catalogueLoaded = false
+	if err := c.GetCatalogue(); err != nil {
+		return 0, err
+	}
+	c.FillBitmap()
+
+	for _, f := range files {
+		nf, err := c.LookupFile(f.User, f.Nom, f.Ext)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s user %d %v", ErrorCompactVerify, f.Filename(), f.User, err)
+		}
+		if !bytes.Equal(d.fileContent(f), c.fileContent(nf)) {
+			return 0, fmt.Errorf("%w: %s user %d", ErrorCompactVerify, f.Filename(), f.User)
+		}
+	}
+	*d = *c
+	return moved, nil
+}
+
+// emptyDirEntry returns a directory entry filled as by the format
+func emptyDirEntry() StDirEntry {
+	var e StDirEntry
+	b := make([]byte, 32)
+	for i := range b {
+		b[i] = 0xE5
+	}
+	_ = binary.Read(bytes.NewReader(b), binary.LittleEndian, &e)
+	return e
+}
@@ -0,0 +1,116 @@
+package dsk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fragmentedDsk returns a dsk where BIG.BIN is stored around SMALL.BIN
+func fragmentedDsk(t *testing.T) (*DSK, map[string][]byte) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	contents := make(map[string][]byte)
+	contents["A.BIN"] = putTestFile(t, d, "A.BIN", 3000, 0)
+	putTestFile(t, d, "HOLE.BIN", 5000, 0)
+	contents["SMALL.BIN"] = putTestFile(t, d, "SMALL.BIN", 1000, 1)
+	f, err := d.LookupFile(0, [8]byte{'H', 'O', 'L', 'E', ' ', ' ', ' ', ' '}, [3]byte{'B', 'I', 'N'})
+	assert.NoError(t, err)
+	assert.NoError(t, d.RemoveFile(f.Indices[0]))
+	contents["BIG.BIN"] = putTestFile(t, d, "BIG.BIN", 20000, 0)
+	return d, contents
+}
+
+func TestCompact(t *testing.T) {
+	d, contents := fragmentedDsk(t)
+	p := d.DiskParams()
+	big, _ := d.lookupName("big.bin", 0)
+	blocs := big.Blocs(p)
+	assert.NotEqual(t, blocs[len(blocs)-1]-blocs[0], uint16(len(blocs)-1), "BIG.BIN is fragmented")
+
+	moved, err := d.Compact(CompactOptions{})
+	assert.NoError(t, err)
+	assert.Greater(t, moved, 0)
+
+	files, err := d.Files()
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+	next, entries := uint16(2), 0
+	for _, f := range files {
+		for _, i := range f.Indices {
+			assert.Equal(t, entries, i)
+			entries++
+		}
+		for _, b := range f.Blocs(p) {
+			assert.Equal(t, next, b)
+			next++
+		}
+		assert.Equal(t, contents[f.Filename()], d.fileContent(f))
+	}
+	for i := entries; i < p.DirEntries(); i++ {
+		assert.Equal(t, emptyDirEntry(), d.Catalogue[i])
+	}
+	assert.True(t, d.Fsck().OK())
+}
+
+func TestCompactKeep(t *testing.T) {
+	d, contents := fragmentedDsk(t)
+	p := d.DiskParams()
+	small, _ := d.lookupName("small.bin", 1)
+	blocs := small.Blocs(p)
+
+	_, err := d.Compact(CompactOptions{Keep: []string{"none.bin"}})
+	assert.ErrorIs(t, err, ErrorFileNotFound)
+
+	_, err = d.Compact(CompactOptions{Keep: []string{"small.bin"}})
+	assert.NoError(t, err)
+	small, _ = d.lookupName("small.bin", 1)
+	assert.Equal(t, blocs, small.Blocs(p))
+	files, err := d.Files()
+	assert.NoError(t, err)
+	for _, f := range files {
+		assert.Equal(t, contents[f.Filename()], d.fileContent(f))
+	}
+	assert.True(t, d.Fsck().OK())
+}
+
+func TestCompactKeepSectors(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	putTestFile(t, d, "A.BIN", 3000, 0)
+	putTestFile(t, d, "HOLE.BIN", 5000, 0)
+	b := putTestFile(t, d, "B.BIN", 5000, 0)
+	f, err := d.LookupFile(0, [8]byte{'H', 'O', 'L', 'E', ' ', ' ', ' ', ' '}, [3]byte{'B', 'I', 'N'})
+	assert.NoError(t, err)
+	assert.NoError(t, d.RemoveFile(f.Indices[0]))
+	// raw data in the hole left by HOLE.BIN, bloc 8
+	raw := generateData(1024)
+	_, _, _, err = d.CopyRawFile(raw, uint16(len(raw)), 1, 0, 7)
+	assert.NoError(t, err)
+	area := SectorRange{First: TrackSector{Cyl: 1, Sect: 7}, Last: TrackSector{Cyl: 1, Sect: 8}}
+
+	_, err = d.Compact(CompactOptions{KeepSectors: []SectorRange{{First: area.Last, Last: area.First}}})
+	assert.ErrorIs(t, err, ErrorSectorRange)
+	_, err = d.Compact(CompactOptions{KeepSectors: []SectorRange{{Last: TrackSector{Cyl: 1, Sect: 9}}}})
+	assert.ErrorIs(t, err, ErrorSectorRange)
+
+	_, err = d.Compact(CompactOptions{KeepSectors: []SectorRange{area}})
+	assert.NoError(t, err)
+	p := d.DiskParams()
+	nf, err := d.lookupName("b.bin", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{6, 7, 9, 10, 11, 12}, nf.Blocs(p))
+	assert.Equal(t, b, d.fileContent(nf))
+	_, _, _, content := d.ExtractRawFile(uint16(len(raw)), 1, 0, 7)
+	assert.Equal(t, raw, content[:len(raw)])
+	assert.True(t, d.Fsck().OK())
+}
+
+func TestCompactInconsistent(t *testing.T) {
+	d, _ := fragmentedDsk(t)
+	e, _ := d.GetInfoDirEntry(0)
+	e.Blocks[1] = e.Blocks[0]
+	assert.NoError(t, d.SetInfoDirEntry(0, e))
+	before := d.Clone()
+	_, err := d.Compact(CompactOptions{})
+	assert.ErrorIs(t, err, ErrorInconsistentFs)
+	assert.Equal(t, before.Tracks, d.Tracks)
+}
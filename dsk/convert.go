@@ -0,0 +1,135 @@
+package dsk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrorNotStandardDsk = errors.New("dsk cannot be stored as a standard dsk")
+
+const (
+	standardDskID = "MV - CPCEMU Disk-File\r\nDisk-Info\r\n"
+	extendedDskID = "EXTENDED CPC DSK File\r\nDisk-Info\r\n"
+)
+
+// ConvertToExtended returns a copy of the dsk stored as an extended dsk,
+// every track and sector keeps its layout. The sectors of a standard dsk
+// share the data of their track, the size stored for each is set in its
+// sector information.
+func ConvertToExtended(d *DSK) (*DSK, error) {
+	c := d.Clone()
+	if c.Extended {
+		return c, nil
+	}
+	c.Extended = true
+	c.Entry.Debut = [0x22]byte{}
+	copy(c.Entry.Debut[:], extendedDskID)
+	for i := range c.Tracks {
+		t := &c.Tracks[i]
+		if t.NbSect == 0 {
+			continue
+		}
+		size := len(t.Data) / int(t.NbSect)
+		for s := 0; s < int(t.NbSect) && s < len(t.Sect); s++ {
+			t.Sect[s].SizeByte = uint16(size)
+		}
+	}
+	if err := c.updateTrackSizeTable(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// standardIssues returns why the track does not fit a standard dsk, where all
+// the sectors of the track have the size of the track and store it once,
+// recorded in MFM at double density
+func (t *CPCEMUTrack) standardIssues() []string {
+	if !t.Formatted() {
+		return []string{"unformatted"}
+	}
+	issues := make([]string, 0)
+	switch t.DataRate {
+	case DataRateUnknown, DataRateDD:
+	case DataRateHD:
+		issues = append(issues, "high density data rate")
+	case DataRateED:
+		issues = append(issues, "extended density data rate")
+	default:
+		issues = append(issues, fmt.Sprintf("data rate %d", t.DataRate))
+	}
+	switch t.RecordingMode {
+	case RecordingUnknown, RecordingMFM:
+	case RecordingFM:
+		issues = append(issues, "FM recording")
+	default:
+		issues = append(issues, fmt.Sprintf("recording mode %d", t.RecordingMode))
+	}
+	for s := 0; s < int(t.NbSect) && s < len(t.Sect); s++ {
+		sec := t.Sect[s]
+		switch {
+		case sec.N != t.SectSize:
+			issues = append(issues, fmt.Sprintf("sector R:#%.2X size code %d instead of %d", sec.R, sec.N, t.SectSize))
+		case t.IsWeakSector(s):
+			issues = append(issues, fmt.Sprintf("weak sector R:#%.2X", sec.R))
+		case t.sectorSize(s) != t.SectorSize(s):
+			issues = append(issues, fmt.Sprintf("sector R:#%.2X holds %d bytes of %d", sec.R, t.sectorSize(s), t.SectorSize(s)))
+		}
+	}
+	return issues
+}
+
+// StandardIssues returns why the dsk cannot be stored as a standard dsk,
+// none when it can.
+func (d *DSK) StandardIssues() []string {
+	reasons := make([]string, 0)
+	var size int
+	for i := 0; i < d.Entry.TracksCount() && i < len(d.Tracks); i++ {
+		t := &d.Tracks[i]
+		cyl, head := i/max(int(d.Entry.NbHeads), 1), i%max(int(d.Entry.NbHeads), 1)
+		if issues := t.standardIssues(); len(issues) > 0 {
+			reasons = append(reasons, fmt.Sprintf("track %d head %d: %s", cyl, head, strings.Join(issues, ", ")))
+			continue
+		}
+		trackSize := 0x100 + int(t.NbSect)*(128<<(t.SectSize&7))
+		switch {
+		case size == 0:
+			size = trackSize
+		case trackSize != size:
+			reasons = append(reasons, fmt.Sprintf("track %d head %d: %d bytes instead of %d", cyl, head, trackSize, size))
+		}
+	}
+	if size > 0xFFFF {
+		reasons = append(reasons, fmt.Sprintf("tracks of %d bytes", size))
+	}
+	return reasons
+}
+
+// ConvertToStandard returns a copy of the dsk stored as a standard dsk.
+// Every track must have the same size, made of sectors of the size of the
+// track each stored once, ErrorNotStandardDsk tells which tracks do not.
+// The Offset-Info block has no place in a standard dsk and is dropped.
+func ConvertToStandard(d *DSK) (*DSK, error) {
+	c := d.Clone()
+	if !c.Extended {
+		return c, nil
+	}
+	if reasons := c.StandardIssues(); len(reasons) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrorNotStandardDsk, strings.Join(reasons, "; "))
+	}
+	var size int
+	if len(c.Tracks) > 0 {
+		size = 0x100 + int(c.Tracks[0].NbSect)*(128<<(c.Tracks[0].SectSize&7))
+	}
+	c.Extended = false
+	c.Entry.Debut = [0x22]byte{}
+	copy(c.Entry.Debut[:], standardDskID)
+	c.Entry.DataSize = uint16(size)
+	c.TrackSizeTable = make([]byte, 0xCC)
+	c.OffsetInfo = nil
+	for i := range c.Tracks {
+		t := &c.Tracks[i]
+		t.Data = t.Data[:min(len(t.Data), int(t.NbSect)*(128<<(t.SectSize&7)))]
+	}
+	return c, nil
+}
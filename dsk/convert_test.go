@@ -0,0 +1,81 @@
+package dsk
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertRoundTrip(t *testing.T) {
+	d := FormatDsk(9, 40, 2, DataFormat, DSK_TYPE)
+	data := putTestFile(t, d, "BIG.BIN", 40000, 0)
+	var original bytes.Buffer
+	assert.NoError(t, d.Write(&original))
+
+	e, err := ConvertToExtended(d)
+	assert.NoError(t, err)
+	assert.True(t, e.Extended)
+	assert.False(t, d.Extended)
+	var b bytes.Buffer
+	assert.NoError(t, e.Write(&b))
+	assert.Equal(t, byte(0x13), e.TrackSizeTable[79])
+	r := &DSK{}
+	assert.NoError(t, r.Read(&b))
+	assert.True(t, r.Extended)
+	assert.True(t, Diff(d, r).Equal())
+	f, err := r.lookupName("big.bin", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, data, r.fileContent(f))
+
+	s, err := ConvertToStandard(r)
+	assert.NoError(t, err)
+	var back bytes.Buffer
+	assert.NoError(t, s.Write(&back))
+	assert.Equal(t, original.Bytes(), back.Bytes())
+}
+
+func TestConvertToStandardErrors(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	assert.NoError(t, d.SetSectorCopies(2, 0, 1, [][]byte{generateData(512), generateData(512)}))
+	d.Tracks[5].Sect[3].N = 3
+	d.Tracks[7].NbSect = 8
+	d.Tracks[7].Data = d.Tracks[7].Data[:8*512]
+	d.Tracks[39] = CPCEMUTrack{}
+
+	_, err := ConvertToStandard(d)
+	assert.ErrorIs(t, err, ErrorNotStandardDsk)
+	assert.ErrorContains(t, err, "track 2 head 0: weak sector R:#C6")
+	assert.ErrorContains(t, err, "track 5 head 0: sector R:#C7 size code 3 instead of 2")
+	assert.ErrorContains(t, err, "track 7 head 0: 4352 bytes instead of 4864")
+	assert.ErrorContains(t, err, "track 39 head 0: unformatted")
+	assert.True(t, d.Extended)
+	assert.Len(t, d.StandardIssues(), 4)
+	assert.Contains(t, d.StandardIssues(), "track 39 head 0: unformatted")
+}
+
+func TestConvertToStandardShortSector(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	tr := &d.Tracks[4]
+	tr.Sect[0].SizeByte = 256
+	tr.Data = tr.Data[256:]
+	_, err := ConvertToStandard(d)
+	assert.ErrorIs(t, err, ErrorNotStandardDsk)
+	assert.ErrorContains(t, err, "holds 256 bytes of 512")
+}
+
+func TestConvertToStandardRateAndRecording(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	d.Tracks[0].DataRate = DataRateDD
+	d.Tracks[0].RecordingMode = RecordingMFM
+	d.Tracks[1].DataRate = DataRateHD
+	d.Tracks[2].DataRate = DataRateED
+	d.Tracks[3].RecordingMode = RecordingFM
+	_, err := ConvertToStandard(d)
+	assert.ErrorIs(t, err, ErrorNotStandardDsk)
+	assert.Equal(t, []string{
+		"track 1 head 0: high density data rate",
+		"track 2 head 0: extended density data rate",
+		"track 3 head 0: FM recording",
+	}, d.StandardIssues())
+}
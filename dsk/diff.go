@@ -0,0 +1,226 @@
+package dsk
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/jeromelesaux/dsk/amsdos"
+)
+
+type DiffKind string
+
+var (
+	DiffCatalogue      DiffKind = "catalogue"
+	DiffFileAdded      DiffKind = "file added"
+	DiffFileRemoved    DiffKind = "file removed"
+	DiffFileContent    DiffKind = "file content"
+	DiffFileAttributes DiffKind = "file attributes"
+	DiffFileHeader     DiffKind = "file header"
+	DiffTrackAdded     DiffKind = "track added"
+	DiffTrackRemoved   DiffKind = "track removed"
+	DiffTrackInfo      DiffKind = "track info"
+	DiffSectorID       DiffKind = "sector id"
+	DiffSectorInfo     DiffKind = "sector info"
+	DiffSectorData     DiffKind = "sector data"
+)
+
+// Difference is a difference found by Diff, Track, Head and Sector
+// (position of the sector in the track) are -1 for the catalogue differences.
+// A catalogue which cannot be read is a difference without File.
+type Difference struct {
+	Kind    DiffKind
+	File    string // NAME.EXT for the catalogue differences
+	User    uint8
+	Track   int
+	Head    int
+	Sector  int
+	Message string
+}
+
+func (d Difference) String() string {
+	var s string
+	switch {
+	case d.File != "":
+		s = fmt.Sprintf("%s: %s user %d", d.Kind, d.File, d.User)
+	case d.Track < 0:
+		s = fmt.Sprintf("%s:", d.Kind)
+	case d.Sector >= 0:
+		s = fmt.Sprintf("%s: track %d head %d sector #%d", d.Kind, d.Track, d.Head, d.Sector)
+	default:
+		s = fmt.Sprintf("%s: track %d head %d", d.Kind, d.Track, d.Head)
+	}
+	if d.Message != "" {
+		s += " " + d.Message
+	}
+	return s
+}
+
+// DiffReport lists the differences between two dsks, the catalogue ones first
+type DiffReport struct {
+	Differences []Difference
+}
+
+// Equal is true when no difference has been found
+func (r DiffReport) Equal() bool {
+	return len(r.Differences) == 0
+}
+
+// Count returns the number of differences of the kind
+func (r DiffReport) Count(kind DiffKind) int {
+	var n int
+	for _, d := range r.Differences {
+		if d.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *DiffReport) addFile(kind DiffKind, f CatalogueFile, format string, args ...any) {
+	r.Differences = append(r.Differences, Difference{
+		Kind:    kind,
+		File:    f.Filename(),
+		User:    f.User,
+		Track:   -1,
+		Head:    -1,
+		Sector:  -1,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *DiffReport) addCatalogue(format string, args ...any) {
+	r.Differences = append(r.Differences, Difference{
+		Kind:    DiffCatalogue,
+		Track:   -1,
+		Head:    -1,
+		Sector:  -1,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *DiffReport) addSector(kind DiffKind, track, head, sector int, format string, args ...any) {
+	r.Differences = append(r.Differences, Difference{
+		Kind:    kind,
+		Track:   track,
+		Head:    head,
+		Sector:  sector,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Diff compares the dsk b to the dsk a, file by file in their catalogues
+// then sector by sector. The image headers (creator, format) are not compared,
+// neither are the sector sizes declared in a standard dsk.
+func Diff(a, b *DSK) DiffReport {
+	var r DiffReport
+	diffCatalogue(&r, a, b)
+	diffTracks(&r, a, b)
+	return r
+}
+
+func diffCatalogue(r *DiffReport, a, b *DSK) {
+	// the files of a catalogue with errors are compared all the same
+	filesA, err := a.Files()
+	if err != nil {
+		r.addCatalogue("first dsk: %v", err)
+	}
+	filesB, err := b.Files()
+	if err != nil {
+		r.addCatalogue("second dsk: %v", err)
+	}
+	inB := make(map[fileKey]CatalogueFile)
+	for _, f := range filesB {
+		inB[fileKey{user: f.User, nom: f.Nom, ext: f.Ext}] = f
+	}
+	for _, fa := range filesA {
+		k := fileKey{user: fa.User, nom: fa.Nom, ext: fa.Ext}
+		fb, ok := inB[k]
+		if !ok {
+			r.addFile(DiffFileRemoved, fa, "")
+			continue
+		}
+		delete(inB, k)
+		diffFile(r, a, b, fa, fb)
+	}
+	for _, f := range filesB {
+		if _, ok := inB[fileKey{user: f.User, nom: f.Nom, ext: f.Ext}]; ok {
+			r.addFile(DiffFileAdded, f, "")
+		}
+	}
+}
+
+func diffFile(r *DiffReport, a, b *DSK, fa, fb CatalogueFile) {
+	if attrA, attrB := entryAttributes(fa.Entries[0]), entryAttributes(fb.Entries[0]); attrA != attrB {
+		r.addFile(DiffFileAttributes, fa, "%+v / %+v", attrA, attrB)
+	}
+	contentA, contentB := a.fileContent(fa), b.fileContent(fb)
+	isAmsdosA, headerA := amsdos.CheckAmsdos(contentA)
+	isAmsdosB, headerB := amsdos.CheckAmsdos(contentB)
+	switch {
+	case isAmsdosA != isAmsdosB:
+		r.addFile(DiffFileHeader, fa, "AMSDOS header %t / %t", isAmsdosA, isAmsdosB)
+	case isAmsdosA:
+		if headerA.Type != headerB.Type {
+			r.addFile(DiffFileHeader, fa, "type %d / %d", headerA.Type, headerB.Type)
+		}
+		if headerA.Address != headerB.Address {
+			r.addFile(DiffFileHeader, fa, "load address #%.4X / #%.4X", headerA.Address, headerB.Address)
+		}
+		if headerA.Exec != headerB.Exec {
+			r.addFile(DiffFileHeader, fa, "exec address #%.4X / #%.4X", headerA.Exec, headerB.Exec)
+		}
+		if amsdosLength(headerA) != amsdosLength(headerB) {
+			r.addFile(DiffFileHeader, fa, "length %d / %d", amsdosLength(headerA), amsdosLength(headerB))
+		}
+	}
+	if isAmsdosA && isAmsdosB {
+		contentA, contentB = contentA[HeaderSize:], contentB[HeaderSize:]
+	}
+	if !bytes.Equal(contentA, contentB) {
+		offset := 0
+		for offset < min(len(contentA), len(contentB)) && contentA[offset] == contentB[offset] {
+			offset++
+		}
+		r.addFile(DiffFileContent, fa, "%d / %d bytes, first difference at offset #%.4X", len(contentA), len(contentB), offset)
+	}
+}
+
+func diffTracks(r *DiffReport, a, b *DSK) {
+	cyls := max(int(a.Entry.NbTracks), int(b.Entry.NbTracks))
+	heads := max(int(a.Entry.NbHeads), int(b.Entry.NbHeads), 1)
+	for c := 0; c < cyls; c++ {
+		for h := 0; h < heads; h++ {
+			ta, tb := a.Track(c, h), b.Track(c, h)
+			switch {
+			case ta == nil && tb == nil:
+			case ta == nil:
+				r.addSector(DiffTrackAdded, c, h, -1, "%d sectors", tb.NbSect)
+			case tb == nil:
+				r.addSector(DiffTrackRemoved, c, h, -1, "%d sectors", ta.NbSect)
+			default:
+				diffTrack(r, c, h, ta, tb)
+			}
+		}
+	}
+}
+
+func diffTrack(r *DiffReport, c, h int, ta, tb *CPCEMUTrack) {
+	if ta.NbSect != tb.NbSect || ta.Gap3 != tb.Gap3 || ta.OctRemp != tb.OctRemp {
+		r.addSector(DiffTrackInfo, c, h, -1, "sectors %d / %d, gap3 #%.2X / #%.2X, filler #%.2X / #%.2X",
+			ta.NbSect, tb.NbSect, ta.Gap3, tb.Gap3, ta.OctRemp, tb.OctRemp)
+	}
+	for s := 0; s < int(min(ta.NbSect, tb.NbSect, 29)); s++ {
+		sa, sb := ta.Sect[s], tb.Sect[s]
+		if sa.C != sb.C || sa.H != sb.H || sa.R != sb.R || sa.N != sb.N {
+			r.addSector(DiffSectorID, c, h, s, "C:%d H:%d R:#%.2X N:%d / C:%d H:%d R:#%.2X N:%d",
+				sa.C, sa.H, sa.R, sa.N, sb.C, sb.H, sb.R, sb.N)
+		}
+		if sa.Un1 != sb.Un1 || ta.sectorSize(s) != tb.sectorSize(s) {
+			r.addSector(DiffSectorInfo, c, h, s, "status #%.4X / #%.4X, size %d / %d",
+				sa.Un1, sb.Un1, ta.sectorSize(s), tb.sectorSize(s))
+		}
+		if !bytes.Equal(ta.sectorData(s), tb.sectorData(s)) {
+			r.addSector(DiffSectorData, c, h, s, "R:#%.2X", sa.R)
+		}
+	}
+}
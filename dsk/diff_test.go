@@ -0,0 +1,94 @@
+package dsk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffEqual(t *testing.T) {
+	a := FormatDsk(9, 40, 1, DataFormat, 0)
+	b := FormatDsk(9, 40, 1, DataFormat, 0)
+	copy(b.Entry.Creator[:], "OTHER TOOL")
+	data := generateData(3000)
+	for _, d := range []*DSK{a, b} {
+		_, err := d.AddFile("a.bin", data, FileOptions{Type: MODE_BINAIRE})
+		assert.NoError(t, err)
+	}
+	r := Diff(a, b)
+	assert.True(t, r.Equal(), r.Differences)
+}
+
+func TestDiffCatalogue(t *testing.T) {
+	a := FormatDsk(9, 40, 1, DataFormat, 0)
+	data := generateData(3000)
+	_, err := a.AddFile("prog.bin", data, FileOptions{Type: MODE_BINAIRE, Load: 0x4000, Exec: 0x4000})
+	assert.NoError(t, err)
+	_, err = a.AddFile("gone.txt", []byte("hello"), FileOptions{Type: MODE_ASCII})
+	assert.NoError(t, err)
+
+	b := FormatDsk(9, 40, 1, DataFormat, 0)
+	data = append([]byte(nil), data...)
+	data[1000] ^= 0xFF
+	_, err = b.AddFile("prog.bin", data, FileOptions{Type: MODE_BINAIRE, Load: 0x4000, Exec: 0x8000, ReadOnly: true})
+	assert.NoError(t, err)
+	_, err = b.AddFile("new.txt", []byte("hello"), FileOptions{Type: MODE_ASCII, User: 1})
+	assert.NoError(t, err)
+
+	r := Diff(a, b)
+	assert.Equal(t, 1, r.Count(DiffFileRemoved))
+	assert.Equal(t, 1, r.Count(DiffFileAdded))
+	assert.Equal(t, 1, r.Count(DiffFileAttributes))
+	assert.Equal(t, 1, r.Count(DiffFileHeader))
+	assert.Equal(t, 1, r.Count(DiffFileContent))
+	for _, d := range r.Differences {
+		switch d.Kind {
+		case DiffFileContent:
+			assert.Equal(t, "PROG.BIN", d.File)
+			assert.Contains(t, d.Message, "#03E8")
+		case DiffFileAdded:
+			assert.Equal(t, "NEW.TXT", d.File)
+			assert.Equal(t, uint8(1), d.User)
+		case DiffFileRemoved:
+			assert.Equal(t, "GONE.TXT", d.File)
+		}
+	}
+	assert.Greater(t, r.Count(DiffSectorData), 0)
+}
+
+func TestDiffCatalogueError(t *testing.T) {
+	a := FormatDsk(9, 40, 1, DataFormat, 0)
+	_, err := a.AddFile("big.bin", generateData(40000), FileOptions{Type: MODE_BINAIRE})
+	assert.NoError(t, err)
+	b := a.Clone()
+	// first extent of the file lost
+	e, _ := b.GetInfoDirEntry(0)
+	e.User = USER_DELETED
+	assert.NoError(t, b.SetInfoDirEntry(0, e))
+	b.catalogueLoaded = false
+
+	r := Diff(a, b)
+	assert.False(t, r.Equal())
+	assert.Equal(t, 1, r.Count(DiffCatalogue))
+	assert.Contains(t, r.Differences[0].Message, "second dsk")
+	assert.Contains(t, r.Differences[0].String(), ErrorMissingExtent.Error())
+}
+
+func TestDiffSectors(t *testing.T) {
+	a := FormatDsk(9, 40, 1, DataFormat, 0)
+	b := a.Clone()
+	b.Tracks[3].Sect[2].R = 0x42
+	b.Tracks[4].Sect[0].Un1 = 0x2020
+	b.Tracks[5].Data[512*4+10] = 0
+	b.Tracks = b.Tracks[:39]
+	b.Entry.NbTracks = 39
+
+	r := Diff(a, b)
+	assert.Len(t, r.Differences, 4)
+	assert.Equal(t, Difference{Kind: DiffSectorID, Track: 3, Head: 0, Sector: 2, Message: "C:3 H:0 R:#C2 N:2 / C:3 H:0 R:#42 N:2"}, r.Differences[0])
+	assert.Equal(t, DiffSectorInfo, r.Differences[1].Kind)
+	assert.Equal(t, 4, r.Differences[1].Track)
+	assert.Equal(t, Difference{Kind: DiffSectorData, Track: 5, Head: 0, Sector: 4, Message: "R:#C3"}, r.Differences[2])
+	assert.Equal(t, DiffTrackRemoved, r.Differences[3].Kind)
+	assert.Equal(t, 39, r.Differences[3].Track)
+}
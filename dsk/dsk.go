@@ -11,7 +11,6 @@ import (
 	"strings"
 
 	"github.com/jeromelesaux/dsk/amsdos"
-	"github.com/jeromelesaux/m4client/cpc"
 )
 
 var (
@@ -28,6 +27,8 @@ var (
 	ErrorNoBloc                  = errors.New("error no more block available")
 	ErrorNoDirEntry              = errors.New("error no more dir entry available")
 	ErrorFileSizeExceed          = errors.New("filesize exceed")
+	ErrorTrackSize               = errors.New("track does not fit its size in the track size table")
+	ErrorUnformattedTrack        = errors.New("unformatted track in a standard dsk")
 )
 
 var (
@@ -39,6 +40,12 @@ var (
 	DSK_TYPE                    = 0
 	DataFormat        DskFormat = 0
 	VendorFormat      DskFormat = 1
+	IBMFormat         DskFormat = 2
+	ParadosFormat     DskFormat = 3
+	RomdosD1Format    DskFormat = 4
+	RomdosD2Format    DskFormat = 5
+	RomdosD10Format   DskFormat = 6
+	MS800Format       DskFormat = 7
 )
 
 const HeaderSize = 0x80
@@ -58,6 +65,32 @@ func (e *CPCEMUEnt) ToString() string {
 		e.Debut, e.Creator, e.NbTracks, e.NbHeads, e.DataSize)
 }
 
+// TracksCount returns the number of tracks stored in the image, all sides included
+func (e *CPCEMUEnt) TracksCount() int {
+	return int(e.NbTracks) * max(int(e.NbHeads), 1)
+}
+
+// TrackIndex returns the index in Tracks of the track of the cylinder and
+// head, side 1 of a cylinder follows its side 0. It returns -1 if the dsk
+// has no such track.
+func (d *DSK) TrackIndex(cyl, head int) int {
+	heads := max(int(d.Entry.NbHeads), 1)
+	i := cyl*heads + head
+	if cyl < 0 || head < 0 || head >= heads || i >= len(d.Tracks) {
+		return -1
+	}
+	return i
+}
+
+// Track returns the track of the cylinder and head, nil if the dsk has none
+func (d *DSK) Track(cyl, head int) *CPCEMUTrack {
+	i := d.TrackIndex(cyl, head)
+	if i < 0 {
+		return nil
+	}
+	return &d.Tracks[i]
+}
+
 type CPCEMUSect struct { // length 8
 	C        uint8 // track,
 	H        uint8 // head
@@ -132,19 +165,91 @@ func (c *CPCEMUSect) Read(r io.Reader) error {
 }
 
 type CPCEMUTrack struct { // length 18 bytes
-	ID       [0x10]byte // "Track-Info\r\n"
-	Track    uint8
-	Head     uint8
-	Unused   [2]byte
-	SectSize uint8 // 2
-	NbSect   uint8 // 9
-	Gap3     uint8 // 0x4E
-	OctRemp  uint8 // 0xE5
-	Sect     [29]CPCEMUSect
-	Data     []byte
+	ID            [0x10]byte // "Track-Info\r\n"
+	Track         uint8
+	Head          uint8
+	DataRate      DataRate      // extended dsk revision 5, unused before
+	RecordingMode RecordingMode // extended dsk revision 5, unused before
+	SectSize      uint8         // 2
+	NbSect        uint8         // 9
+	Gap3          uint8         // 0x4E
+	OctRemp       uint8         // 0xE5
+	Sect          [29]CPCEMUSect
+	Data          []byte
+	standard      bool // read from a standard dsk, whose sectors may have no stored size
 }
 
 func (c *CPCEMUTrack) Read(r io.Reader) error {
+	if err := c.readInfo(r); err != nil {
+		return err
+	}
+	c.standard = true
+	var sectorSize uint16
+	var weak bool
+	for i := 0; i < int(c.NbSect) && i < 29; i++ {
+		sectorSize += c.Sect[i].SizeByte
+		// several copies of a weak sector
+		weak = weak || int(c.Sect[i].SizeByte) > c.SectorSize(i) && int(c.Sect[i].SizeByte)%c.SectorSize(i) == 0
+	}
+	// every sector has the size of the track
+	trackSize := int(c.NbSect) * (128 << (c.SectSize & 7))
+	if int(sectorSize) > trackSize {
+		if !weak {
+			fmt.Fprintf(os.Stderr, "Warning : Sector size [%d] differs from the amount of data found [%d], enlarge data part\n",
+				trackSize,
+				sectorSize)
+		}
+		c.Data = make([]byte, sectorSize)
+	} else {
+		c.Data = make([]byte, trackSize)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &c.Data); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while reading CPCEmuSect.Data error :%v\n", err)
+		return err
+	}
+	return nil
+}
+
+// readExtendedTrack reads a track of an extended dsk taking size bytes in the
+// image, as given by the track size table. A size of 0 is an unformatted track.
+// The data holds the stored size of every sector, the padding is skipped.
+func readExtendedTrack(r io.Reader, size int) (CPCEMUTrack, error) {
+	var c CPCEMUTrack
+	if size == 0 {
+		return c, nil
+	}
+	block := make([]byte, size)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return c, err
+	}
+	if size < 0x100 {
+		return c, fmt.Errorf("%w: %d bytes", ErrorTrackSize, size)
+	}
+	if err := c.readInfo(bytes.NewReader(block[:0x100])); err != nil {
+		return c, err
+	}
+	if c.NbSect > 29 {
+		return c, fmt.Errorf("%w: %d sectors", ErrorTrackSize, c.NbSect)
+	}
+	var dataSize int
+	for s := 0; s < int(c.NbSect); s++ {
+		dataSize += c.sectorSize(s)
+	}
+	if 0x100+dataSize > size {
+		return c, fmt.Errorf("%w: %d bytes of sectors in %d bytes", ErrorTrackSize, dataSize, size-0x100)
+	}
+	c.Data = append([]byte(nil), block[0x100:0x100+dataSize]...)
+	return c, nil
+}
+
+// Formatted is false for an unformatted track of an extended dsk,
+// stored with a size of 0 in the track size table
+func (c *CPCEMUTrack) Formatted() bool {
+	return c.ID != [0x10]byte{}
+}
+
+// readInfo reads the track information block, 0x100 bytes
+func (c *CPCEMUTrack) readInfo(r io.Reader) error {
 	if err := binary.Read(r, binary.LittleEndian, &c.ID); err != nil {
 		fmt.Fprintf(os.Stderr, "Error while reading CPCEMUTrack.ID error :%v\n", err)
 		return err
@@ -157,8 +262,12 @@ func (c *CPCEMUTrack) Read(r io.Reader) error {
 		fmt.Fprintf(os.Stderr, "Error while reading CPCEMUTrack.Head error :%v\n", err)
 		return err
 	}
-	if err := binary.Read(r, binary.LittleEndian, &c.Unused); err != nil {
-		fmt.Fprintf(os.Stderr, "Error while reading CPCEMUTrack.Unused error :%v\n", err)
+	if err := binary.Read(r, binary.LittleEndian, &c.DataRate); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while reading CPCEMUTrack.DataRate error :%v\n", err)
+		return err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &c.RecordingMode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while reading CPCEMUTrack.RecordingMode error :%v\n", err)
 		return err
 	}
 	if err := binary.Read(r, binary.LittleEndian, &c.SectSize); err != nil {
@@ -180,7 +289,6 @@ func (c *CPCEMUTrack) Read(r io.Reader) error {
 
 	//	fmt.Fprintf(os.Stdout,"Track:%s\n",c.ToString())
 	var i uint8
-	var sectorSize uint16
 	for i = 0; i < c.NbSect && i < 29; i++ {
 		sect := &CPCEMUSect{}
 		if err := sect.Read(r); err != nil {
@@ -188,7 +296,6 @@ func (c *CPCEMUTrack) Read(r io.Reader) error {
 			return err
 		}
 		c.Sect[i] = *sect
-		sectorSize += c.Sect[i].SizeByte
 	}
 	for i = c.NbSect; i < 29; i++ {
 		sect := &CPCEMUSect{}
@@ -197,18 +304,6 @@ func (c *CPCEMUTrack) Read(r io.Reader) error {
 			fmt.Fprintf(os.Stderr, "error while reading sector (%d), error :%v\n", i, err)
 		}
 	}
-	if int(sectorSize) > int(c.SectSize)*0x100*int(c.NbSect) {
-		fmt.Fprintf(os.Stderr, "Warning : Sector size [%d] differs from the amount of data found [%d], enlarge data part\n",
-			int(c.SectSize)*0x100*int(c.NbSect),
-			sectorSize)
-		c.Data = make([]byte, sectorSize)
-	} else {
-		c.Data = make([]byte, int(c.SectSize)*0x100*int(c.NbSect))
-	}
-	if err := binary.Read(r, binary.LittleEndian, &c.Data); err != nil {
-		fmt.Fprintf(os.Stderr, "Error while reading CPCEmuSect.Data error :%v\n", err)
-		return err
-	}
 	return nil
 }
 
@@ -225,8 +320,12 @@ func (c *CPCEMUTrack) Write(w io.Writer) error {
 		fmt.Fprintf(os.Stderr, "Error while writing CPCEMUTrack.Head error :%v\n", err)
 		return err
 	}
-	if err := binary.Write(w, binary.LittleEndian, &c.Unused); err != nil {
-		fmt.Fprintf(os.Stderr, "Error while writing CPCEMUTrack.Unused error :%v\n", err)
+	if err := binary.Write(w, binary.LittleEndian, &c.DataRate); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while writing CPCEMUTrack.DataRate error :%v\n", err)
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, &c.RecordingMode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while writing CPCEMUTrack.RecordingMode error :%v\n", err)
 		return err
 	}
 	if err := binary.Write(w, binary.LittleEndian, &c.SectSize); err != nil {
@@ -287,18 +386,29 @@ type DSK struct {
 	Entry           CPCEMUEnt
 	TrackSizeTable  []byte // dsk format  [0xCC]byte
 	Tracks          []CPCEMUTrack
-	BitMap          [256]byte
-	Catalogue       [64]StDirEntry
+	BitMap          []byte
+	Catalogue       []StDirEntry
 	catalogueLoaded bool
 	Extended        bool
+	OffsetInfo      []TrackOffsets // extended dsk revision 5 Offset-Info block, nil if absent
+	params          *DiskParams
 }
 
 func (d *DSK) CleanBitmap() {
-	for i := 0; i < 256; i++ {
+	d.allocBitmap()
+	for i := range d.BitMap {
 		d.BitMap[i] = 0
 	}
 }
 
+// allocBitmap sizes the bitmap for every bloc number a directory entry can hold
+func (d *DSK) allocBitmap() {
+	size := max(256, int(d.DiskParams().DSM)+1)
+	if len(d.BitMap) < size {
+		d.BitMap = make([]byte, size)
+	}
+}
+
 func (d *DSK) Read(r io.Reader) error {
 	if err := binary.Read(r, binary.LittleEndian, &d.Entry); err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot read CPCEmuEnt error :%v\n", err)
@@ -313,7 +423,7 @@ func (d *DSK) Read(r io.Reader) error {
 	}
 	if strings.Contains(string(extended), "EXTENDED CPC DSK") {
 		d.Extended = true
-		d.TrackSizeTable = make([]byte, d.Entry.NbHeads*(d.Entry.NbTracks))
+		d.TrackSizeTable = make([]byte, d.Entry.TracksCount())
 	} else {
 		d.TrackSizeTable = make([]byte, 0xCC)
 	}
@@ -323,15 +433,23 @@ func (d *DSK) Read(r io.Reader) error {
 	}
 
 	if d.Extended {
-		offset := make([]byte, (0x100 - (52 + uint(d.Entry.NbHeads*d.Entry.NbTracks))))
+		offset := make([]byte, 0x100-(52+d.Entry.TracksCount()))
 		if err := binary.Read(r, binary.LittleEndian, &offset); err != nil {
 			fmt.Fprintf(os.Stderr, "Cannot read CPCEmuEnt padding 0x100 error :%v\n", err)
 			return err
 		}
 	}
-	d.Tracks = make([]CPCEMUTrack, d.Entry.NbTracks)
-	var i uint8
-	for i = 0; i < d.Entry.NbTracks; i++ {
+	d.Tracks = make([]CPCEMUTrack, d.Entry.TracksCount())
+	for i := range d.Tracks {
+		if d.Extended {
+			track, err := readExtendedTrack(r, int(d.TrackSizeTable[i])*0x100)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error track (%d) error :%v\n", i, err)
+				return fmt.Errorf("track %d: %w", i, err)
+			}
+			d.Tracks[i] = track
+			continue
+		}
 		//	fmt.Fprintf(os.Stdout,"Loading track %d, total: %d\n", i, cpcEntry.NbTracks)
 		track := &CPCEMUTrack{}
 		if err := track.Read(r); err != nil {
@@ -341,84 +459,107 @@ func (d *DSK) Read(r io.Reader) error {
 		d.Tracks[i] = *track
 		// fmt.Fprintf(os.Stdout, "Track %d %s\n", i, d.Tracks[i].ToString())
 	}
+	if d.Extended {
+		if err := d.readOffsetInfo(r); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read Offset-Info error :%v\n", err)
+			return err
+		}
+	}
+	d.allocBitmap()
 	return nil
 }
 
 func FormatDsk(nbSect, nbTrack, nbHead uint8, diskFormat DskFormat, extendedDskType int) *DSK {
+	p, ok := dskFormatParams[diskFormat]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown format track.")
+		p = DataFormatParams
+	}
+	if diskFormat != DataFormat && diskFormat != VendorFormat {
+		// the geometry is part of the format
+		return FormatDskWithParams(p, p.Tracks, p.Heads, extendedDskType)
+	}
+	return formatDsk(p.FirstSector, nbSect, nbTrack, nbHead, extendedDskType == EXTENDED_DSK_TYPE || diskFormat == VendorFormat)
+}
+
+// FormatDskWithParams formats a dsk following the disk parameters geometry.
+func FormatDskWithParams(p DiskParams, nbTrack, nbHead uint8, extendedDskType int) *DSK {
+	dsk := formatDsk(p.FirstSector, uint8(p.SectorsPerTrack()), nbTrack, nbHead, extendedDskType == EXTENDED_DSK_TYPE)
+	dsk.SetDiskParams(p)
+	return dsk
+}
+
+func formatDsk(minSect, nbSect, nbTrack, nbHead uint8, extended bool) *DSK {
 	dsk := &DSK{}
 	entry := CPCEMUEnt{}
-	if extendedDskType == EXTENDED_DSK_TYPE || diskFormat == VendorFormat {
+	if extended {
 		dsk.Extended = true
-		copy(entry.Debut[:], "EXTENDED CPC DSK File\r\nDisk-Info\r\n")
+		copy(entry.Debut[:], extendedDskID)
 	} else {
-		copy(entry.Debut[:], "MV - CPCEMU Disk-File\r\nDisk-Info\r\n")
+		copy(entry.Debut[:], standardDskID)
 	}
 	copy(entry.Creator[:], "Sid DSK"[:])
 	entry.DataSize = 0x100 + (SECTSIZE * uint16(nbSect))
 	entry.NbTracks = nbTrack
 	entry.NbHeads = nbHead
-	if extendedDskType == EXTENDED_DSK_TYPE {
-		dsk.TrackSizeTable = make([]byte, entry.NbHeads*(entry.NbTracks))
+	if extended {
+		dsk.TrackSizeTable = make([]byte, int(entry.NbHeads)*int(entry.NbTracks))
 		for i := 0; i < len(dsk.TrackSizeTable); i++ {
 			dsk.TrackSizeTable[i] = byte(0x100 + (SECTSIZE*uint16(nbSect))/256 + 1)
 		}
 	} else {
-		switch diskFormat {
-		case DataFormat:
-			dsk.TrackSizeTable = make([]byte, 0xCC)
-		case VendorFormat:
-			dsk.TrackSizeTable = make([]byte, entry.NbHeads*(entry.NbTracks))
-			for i := 0; i < len(dsk.TrackSizeTable); i++ {
-				dsk.TrackSizeTable[i] = byte(0x100 + (SECTSIZE*uint16(nbSect))/256 + 1)
-			}
-		default:
-			fmt.Fprintf(os.Stderr, "Unknown format track.")
-		}
+		dsk.TrackSizeTable = make([]byte, 0xCC)
 	}
 	dsk.Entry = entry
-	dsk.Tracks = make([]CPCEMUTrack, nbTrack*nbHead)
-	var i uint8
-	if nbHead == 1 {
-		for i = 0; i < nbTrack; i++ {
-			switch diskFormat {
-			case DataFormat:
-				dsk.FormatTrack(i, i, 0, 0xC1, nbSect)
-			case VendorFormat:
-				dsk.FormatTrack(i, i, 0, 0x41, nbSect)
-			default:
-				fmt.Fprintf(os.Stderr, "Unknown format track.")
-			}
+	// side 1 of a cylinder follows its side 0
+	dsk.Tracks = make([]CPCEMUTrack, 0, int(nbTrack)*int(nbHead))
+	var i, h uint8
+	for i = 0; i < nbTrack; i++ {
+		for h = 0; h < nbHead; h++ {
+			dsk.Tracks = append(dsk.Tracks, newTrack(i, h, minSect, nbSect))
 		}
-	} else {
-		index := 0
-		for i = 0; i < nbTrack; i++ {
-			switch diskFormat {
-			case DataFormat:
-				dsk.FormatTrack(uint8(index), uint8(i), 0, 0xC1, nbSect)
-			case VendorFormat:
-				dsk.FormatTrack(uint8(index), uint8(i), 0, 0x41, nbSect)
-			default:
-				fmt.Fprintf(os.Stderr, "Unknown format track.")
-			}
-			index += 2
+	}
+	dsk.allocBitmap()
+	return dsk
+}
+
+// FormatTrack formats the track of the cylinder and head, the dsk grows
+// with formatted tracks up to the cylinder if needed.
+func (d *DSK) FormatTrack(cyl, head, minSect, nbSect uint8) {
+	d.grow(int(cyl)+1, minSect, nbSect)
+	if i := d.TrackIndex(int(cyl), int(head)); i >= 0 {
+		d.Tracks[i] = newTrack(cyl, head, minSect, nbSect)
+		if d.Extended {
+			d.TrackSizeTable[i] = byte((0x100 + len(d.Tracks[i].Data) + 0xFF) / 0x100)
 		}
-		index = 1
-		for i = 1; i < nbTrack; i++ {
-			switch diskFormat {
-			case DataFormat:
-				dsk.FormatTrack(uint8(index), uint8(i), 1, 0xC1, nbSect)
-			case VendorFormat:
-				dsk.FormatTrack(uint8(index), uint8(i), 1, 0x41, nbSect)
-			default:
-				fmt.Fprintf(os.Stderr, "Unknown format track.")
-			}
-			index += 2
+	}
+}
+
+// grow formats the missing cylinders up to cyls cylinders, every head of a
+// cylinder is added with it. The number of tracks and the track size table
+// of an extended dsk follow the tracks.
+func (d *DSK) grow(cyls int, minSect, nbSect uint8) {
+	heads := max(int(d.Entry.NbHeads), 1)
+	for len(d.Tracks) < cyls*heads || len(d.Tracks)%heads != 0 {
+		n := len(d.Tracks)
+		d.Tracks = append(d.Tracks, newTrack(uint8(n/heads), uint8(n%heads), minSect, nbSect))
+	}
+	d.Entry.NbTracks = uint8(len(d.Tracks) / heads)
+	if !d.Extended {
+		return
+	}
+	d.TrackSizeTable = d.TrackSizeTable[:min(len(d.TrackSizeTable), len(d.Tracks))]
+	for i := len(d.TrackSizeTable); i < len(d.Tracks); i++ {
+		var size byte
+		if d.Tracks[i].Formatted() {
+			size = byte((0x100 + len(d.Tracks[i].Data) + 0xFF) / 0x100)
 		}
+		d.TrackSizeTable = append(d.TrackSizeTable, size)
 	}
-	return dsk
 }
 
-func (d *DSK) FormatTrack(indexTrack, track, head, minSect, nbSect uint8) {
+// newTrack returns a formatted track, sectors filled with #E5
+func newTrack(track, head, minSect, nbSect uint8) CPCEMUTrack {
 	t := CPCEMUTrack{}
 	copy(t.ID[:], "Track-Info\r\n")
 	t.Track = track
@@ -430,6 +571,7 @@ func (d *DSK) FormatTrack(indexTrack, track, head, minSect, nbSect uint8) {
 	//
 	// Gestion "entrelacement" des secteurs
 	//
+	half := (nbSect + 1) / 2
 	var s uint8
 	var ss uint8
 	var sectorSize uint16
@@ -440,31 +582,30 @@ func (d *DSK) FormatTrack(indexTrack, track, head, minSect, nbSect uint8) {
 		t.Sect[s].N = 2
 		t.Sect[s].SizeByte = 0x200
 		sectorSize += t.Sect[s].SizeByte
-		ss++
 		s++
 		if s < nbSect {
 			t.Sect[s].C = track
 			t.Sect[s].H = head
-			t.Sect[s].R = (ss + minSect + 4)
+			t.Sect[s].R = (ss + minSect + half)
 			t.Sect[s].N = 2
 			t.Sect[s].SizeByte = 0x200
 			sectorSize += t.Sect[s].SizeByte
 			s++
 		}
+		ss++
 	}
 	t.Data = make([]byte, sectorSize)
 	for i := 0; i < len(t.Data); i++ {
 		t.Data[i] = 0xE5
 	}
-	if len(d.Tracks) < int(track+1) {
-		d.Tracks = append(d.Tracks, t)
-		d.Entry.NbTracks++
-	} else {
-		d.Tracks[indexTrack] = t
-	}
+	return t
 }
 
 func (d *DSK) Write(w io.Writer) error {
+	if err := d.updateTrackSizeTable(); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write tracks error :%v\n", err)
+		return err
+	}
 	if err := binary.Write(w, binary.LittleEndian, &d.Entry); err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot write CPCEmuEnt error :%v\n", err)
 		return err
@@ -474,21 +615,87 @@ func (d *DSK) Write(w io.Writer) error {
 		return err
 	}
 	if d.Extended {
-		offset := make([]byte, (0x100 - (52 + uint(d.Entry.NbHeads*d.Entry.NbTracks))))
+		offset := make([]byte, 0x100-(52+d.Entry.TracksCount()))
 		if err := binary.Write(w, binary.LittleEndian, &offset); err != nil {
 			fmt.Fprintf(os.Stderr, "Cannot write CPCEmuEnt padding 0x100 error :%v\n", err)
 			return err
 		}
 	}
-	var i uint8
-	for i = 0; i < d.Entry.NbTracks; i++ {
+	for i := 0; i < d.Entry.TracksCount() && i < len(d.Tracks); i++ {
+		if d.Extended && !d.Tracks[i].Formatted() {
+			continue
+		}
 		if err := d.Tracks[i].Write(w); err != nil {
 			fmt.Fprintf(os.Stderr, "Error track (%d) error :%v\n", i, err)
 		}
+		if d.Extended {
+			// padding up to the size of the track in the table
+			padding := int(d.TrackSizeTable[i])*0x100 - 0x100 - len(d.Tracks[i].Data)
+			if _, err := w.Write(make([]byte, padding)); err != nil {
+				return err
+			}
+		}
+	}
+	if d.Extended && d.OffsetInfo != nil {
+		if err := d.writeOffsetInfo(w); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot write Offset-Info error :%v\n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// updateTrackSizeTable sets the size of every track of an extended dsk from
+// its data, 0 for the unformatted tracks. A standard dsk cannot hold
+// unformatted tracks.
+func (d *DSK) updateTrackSizeTable() error {
+	if !d.Extended {
+		for i := 0; i < d.Entry.TracksCount() && i < len(d.Tracks); i++ {
+			if !d.Tracks[i].Formatted() {
+				return fmt.Errorf("%w: track %d", ErrorUnformattedTrack, i)
+			}
+		}
+		return nil
+	}
+	table := make([]byte, d.Entry.TracksCount())
+	for i := 0; i < len(table) && i < len(d.Tracks); i++ {
+		if !d.Tracks[i].Formatted() {
+			continue
+		}
+		size := (0x100 + len(d.Tracks[i].Data) + 0xFF) / 0x100
+		if size > 0xFF {
+			return fmt.Errorf("%w: track %d holds %d bytes", ErrorTrackSize, i, len(d.Tracks[i].Data))
+		}
+		table[i] = byte(size)
 	}
+	d.TrackSizeTable = table
 	return nil
 }
 
+// Clone returns a deep copy of the dsk
+func (d *DSK) Clone() *DSK {
+	c := *d
+	c.TrackSizeTable = append([]byte(nil), d.TrackSizeTable...)
+	c.BitMap = append([]byte(nil), d.BitMap...)
+	c.Catalogue = append([]StDirEntry(nil), d.Catalogue...)
+	if d.OffsetInfo != nil {
+		c.OffsetInfo = make([]TrackOffsets, len(d.OffsetInfo))
+		for i, o := range d.OffsetInfo {
+			c.OffsetInfo[i] = TrackOffsets{Length: o.Length, Sectors: append([]uint16(nil), o.Sectors...)}
+		}
+	}
+	c.Tracks = make([]CPCEMUTrack, len(d.Tracks))
+	for i, t := range d.Tracks {
+		c.Tracks[i] = t
+		c.Tracks[i].Data = append([]byte(nil), t.Data...)
+	}
+	if d.params != nil {
+		p := *d.params
+		c.params = &p
+	}
+	return &c
+}
+
 func WriteDsk(filePath string, d *DSK) error {
 	f, err := os.Create(filePath)
 	if err != nil {
@@ -519,21 +726,20 @@ func ReadDsk(filePath string) (*DSK, error) {
 }
 
 func (d *DSK) CheckDsk() error {
-	// if d.Entry.NbHeads == 1 {
+	if len(d.Tracks) == 0 {
+		return ErrorBadSectorNumber
+	}
 	minSectFirst := d.GetMinSect()
-	if minSectFirst != 0x41 && minSectFirst != 0xc1 && minSectFirst != 0x01 {
+	p := d.DiskParams()
+	if p.FirstSector != minSectFirst {
 		fmt.Fprintf(os.Stderr, "Bad sector %.2x\n", minSectFirst)
 		return ErrorBadSectorNumber
 	}
-	if d.Entry.NbTracks > 80 {
-		d.Entry.NbTracks = 80
-	}
-	var track uint8
-	for track = 0; track < d.Entry.NbTracks; track++ {
-		tr := d.Tracks[track]
+	nbSect := p.SectorsPerTrack()
+	for track, tr := range d.Tracks {
 		if !d.Extended {
-			if tr.NbSect != 9 {
-				fmt.Fprintf(os.Stderr, "Warning : track :%d has %d sectors ! wanted 9\n", track, tr.NbSect)
+			if int(tr.NbSect) != nbSect {
+				fmt.Fprintf(os.Stderr, "Warning : track :%d has %d sectors ! wanted %d\n", track, tr.NbSect, nbSect)
 			}
 		}
 		var minSect, maxSect, s uint8
@@ -552,7 +758,7 @@ func (d *DSK) CheckDsk() error {
 			}
 		}
 		if !d.Extended {
-			if maxSect-minSect != 8 {
+			if int(maxSect)-int(minSect) != nbSect-1 {
 				fmt.Fprintf(os.Stderr, "Warning : strange sector numbering in track %d! (maxSect:%X,minSect:%X)\n", track, maxSect, minSect)
 			}
 		}
@@ -563,8 +769,6 @@ func (d *DSK) CheckDsk() error {
 		}
 	}
 	return nil
-	//}
-	//	return ErrorUnsupportedMultiHeadDsk
 }
 
 // Recherche le plus petit secteur d'une piste
@@ -583,83 +787,48 @@ func (d *DSK) GetMinSect() uint8 {
 }
 
 // Retourne la position d'un secteur dans le fichier DSK, position dans la structure Data
-func (d *DSK) GetPosData(track, sect uint8, SectPhysique bool) uint16 {
-	// Recherche position secteur
-	tr := d.Tracks[track]
-	var SizeByte uint16
+// de la piste du cylindre et de la tete
+func (d *DSK) GetPosData(cyl, head int, sect uint8, SectPhysique bool) uint16 {
+	tr := d.Track(cyl, head)
+	if tr == nil {
+		return 0
+	}
+	return tr.posData(sect, SectPhysique)
+}
+
+// posData returns the position in the track data of the sector with the
+// sector number sect, or at the position sect in the track
+func (tr *CPCEMUTrack) posData(sect uint8, SectPhysique bool) uint16 {
 	var Pos uint16
 	var s uint8
-	// Pos += 256
-
-	// fmt.Fprintf(os.Stdout,"Track:%d,Secteur:%d\n",track,sect)
-
-	// Pos += 256
 	for s = 0; s < tr.NbSect; s++ {
 		if (tr.Sect[s].R == sect && SectPhysique) || (s == sect && !SectPhysique) {
 			break
 		}
-		SizeByte = tr.Sect[s].SizeByte
-		if SizeByte != 0 {
-			Pos += SizeByte
-		} else {
-			Pos += (128 << tr.Sect[s].N)
-		}
-		// fmt.Fprintf(os.Stderr, "sizebyte:%d, t:%d,s:%d,tr.Sect[s].SizeByte:%d, tr->Sect[ s ].N:%d, Pos:%d\n", SizeByte,t,s,tr.Sect[s].SizeByte,tr.Sect[ s ].N,Pos)
+		Pos += uint16(tr.sectorSize(int(s)))
 	}
-
 	return Pos
 }
 
 func (d *DSK) GetFile(path string, indice int) error {
-	i := indice
-	current := make([]byte, 16)
-	nomIndice := make([]byte, 16)
-	lMax := 0x1000000
-	cumul := 0
-	err := d.GetCatalogue()
+	params := d.DiskParams()
+	f, err := d.FileAt(indice)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error while getting the catalogue, error :%v\n", err)
+		fmt.Fprintf(os.Stderr, "error while getting the file at indice %d, error :%v\n", indice, err)
+		return err
 	}
-	copy(nomIndice, d.Catalogue[i].Nom[:])
-	copy(nomIndice, d.Catalogue[i].Ext[:])
 	fw, err := os.Create(path)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot open file (%s), error :%v\n", path, err)
 		return err
 	}
 	defer fw.Close()
-	for {
-		// Longueur du fichier
-		var j uint8
-		l := (d.Catalogue[i].NbPages + 7) >> 3
-		for j = 0; j < l; j++ {
-			tailleBloc := 1024
-			p := d.ReadBloc(int(d.Catalogue[i].Blocks[j]))
-			var nbOctets int
-			if lMax > tailleBloc {
-				nbOctets = tailleBloc
-			} else {
-				nbOctets = lMax
-			}
-			if nbOctets > 0 {
-				if err := binary.Write(fw, binary.LittleEndian, p); err != nil {
-					fmt.Fprintf(os.Stderr, "Cannot write data into file (%s) error %v\n", path, err)
-				}
-				cumul += nbOctets
-			}
-			lMax -= 1024
-		}
-		i++
-		copy(current, d.Catalogue[i].Nom[:])
-		copy(current, d.Catalogue[i].Ext[:])
-		if i > 64 {
-			return ErrorCatalogueExceed
+	for _, bloc := range f.Blocs(params) {
+		p := d.ReadBloc(int(bloc))
+		if err := binary.Write(fw, binary.LittleEndian, p); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot write data into file (%s) error %v\n", path, err)
 		}
-		if string(nomIndice) == string(current) {
-			break
-		}
-	} // while (! strncmp( NomIndice, current , max( strlen( NomIndice ), strlen( current ) )));
-
+	}
 	return nil
 }
 
@@ -696,205 +865,145 @@ func GetNomAmsdos(masque string) string {
 }
 
 func (d *DSK) PutFile(masque string, typeModeImport uint8, loadAddress, exeAddress, userNumber uint16, isSystemFile, readOnly, hidden bool) error {
-	buff := make([]byte, 0x20000)
-	fileSize := 0
-	cFileName := GetNomAmsdos(masque)
-	header := &amsdos.StAmsdos{}
-	var addHeader bool
-	var err error
-
-	err = d.GetCatalogue()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error while getting the catalogue, error :%v\n", err)
-	}
-	fr, err := os.Open(masque)
+	buff, err := os.ReadFile(masque)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot read file (%s) error :%v\n", masque, err)
 		return err
 	}
-	fileLength, err := fr.Read(buff)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Cannot read the content of the file (%s) with error %v\n", masque, err)
-		return err
-	}
-	fmt.Fprintf(os.Stderr, "file (%s) read (%d bytes).\n", masque, fileLength)
-	_, err = fr.Seek(0, io.SeekStart)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error while seeking in file error :%v\n", err)
-	}
-
-	if err = binary.Read(fr, binary.LittleEndian, header); err != nil {
-		fmt.Fprintf(os.Stderr, "No header found for file :%s, error :%v\n", masque, err)
-	}
-
-	if typeModeImport == MODE_BASIC && fileLength%128 != 0 {
-		buff[fileLength] = 0x1A
-	}
-	if typeModeImport == MODE_ASCII && fileLength%128 != 0 {
-		buff[fileLength] = 0x1A
-	}
+	fmt.Fprintf(os.Stderr, "file (%s) read (%d bytes).\n", masque, len(buff))
+	if exeAddress != 0 || loadAddress != 0 {
+		typeModeImport = MODE_BINAIRE
+	}
+	_, err = d.AddFile(masque, buff, FileOptions{
+		Type:     typeModeImport,
+		Load:     loadAddress,
+		Exec:     exeAddress,
+		User:     uint8(userNumber),
+		System:   isSystemFile || hidden,
+		ReadOnly: readOnly,
+	})
+	return err
+}
 
-	if typeModeImport == MODE_PROTECTED && fileLength%128 != 0 {
-		buff[fileLength] = 0x1A
+// Copie un fichier sur le DSK
+//
+// la taille est determine par le nombre de NbPages
+// regarder pourquoi different d'une autre DSK
+func (d *DSK) CopyFile(bufFile []byte, fileName string, fileLength uint32, maxBloc, userNumber uint16, isSystemFile, readOnly, isHide bool) error {
+	var nbPages int
+	p := d.DiskParams()
+	d.FillBitmap()
+	if err := d.checkFreeSpace(fileLength, int(maxBloc)); err != nil {
+		return err
 	}
-
-	var isAmsdos bool
-	//
-	// Regarde si le fichier contient une en-tete ou non
-	//
-	if err == nil && header.Checksum == header.ComputedChecksum16() {
-		fmt.Fprintf(os.Stderr, "Header found...(%s)\n", masque)
-		isAmsdos = true
-	}
-	if !isAmsdos && typeModeImport != MODE_ASCII {
-		// Creer une en-tete amsdos par defaut
-		fileSize = fileLength + 0x80
-		fmt.Fprintf(os.Stderr, "Create header... (%s)\n", masque)
-		header.User = byte(userNumber)
-		header.Size = uint16(fileSize)
-		header.Size2 = uint16(fileSize)
-		header.LogicalSize = uint16(fileSize)
-		copy(header.Filename[:], []byte(cFileName[0:12]))
-		header.Address = loadAddress
-		if loadAddress != 0 {
-			typeModeImport = MODE_BINAIRE
+	dirLoc := d.GetNomDir(fileName, isHide)
+	for posFile := 0; posFile < int(fileLength) || nbPages == 0; { // Pour chaque bloc du fichier, une entree pour un fichier vide
+		posDir, err := d.RechercheDirLibre() // Trouve une entree libre dans le CAT
+		if err != nil {
+			return ErrorNoDirEntry
 		}
-		header.Exec = exeAddress
-		if exeAddress != 0 || loadAddress != 0 {
-			typeModeImport = MODE_BINAIRE
+		dirLoc.User = uint8(userNumber) // Remplit l'entree : User 0
+		if readOnly {
+			dirLoc.Ext[0] |= 0x80
 		}
-		header.Type = typeModeImport
-
-		// Il faut recalculer le checksum en comptant es adresses !
-		header.Checksum = header.ComputedChecksum16()
-
-	} else {
-		fmt.Fprintf(os.Stderr, "No header added...(%s)\n", masque)
-	}
-	//
-	// En fonction du mode d'importation...
-	//
-	switch typeModeImport {
-	case MODE_BASIC:
-		//
-		// Importation en mode ASCII
-		//
-		if isAmsdos {
-			// Supprmier en-tete si elle existe
-			fileSize -= 0x80
-			fmt.Fprintf(os.Stderr, "Removing header...(%s)\n", masque)
-			copy(buff[0:], buff[binary.Size(amsdos.StAmsdos{}):])
+		if isSystemFile {
+			dirLoc.Ext[1] |= 0x80
 		}
-	case MODE_BINAIRE:
-		//
-		// Importation en mode BINAIRE
-		//
-
-		if !isAmsdos {
-			//
-			// Indique qu'il faudra ajouter une en-tete
-			//
-			addHeader = true
+		records := (int(fileLength) - posFile + 127) >> 7 // Taille de l'entree (on arrondit par le haut)
+		if records > p.RecordsPerEntry() {                // Si l'entree est pleine il faut plusieurs entrees
+			records = p.RecordsPerEntry()
 		}
-	}
-	//
-	// Si fichier ok pour etre import
-	//
-	if addHeader {
-		// Ajoute l'en-tete amsdos si necessaire
-
-		var rbuff bytes.Buffer
-		err = binary.Write(&rbuff, binary.LittleEndian, header)
-		if err != nil {
-			fmt.Fprintf(os.Stdout, "error while writing in header %v\n", err)
+		var lastExtent int
+		if records > 0 {
+			lastExtent = (records - 1) >> 7
+		}
+		extent := nbPages*(int(p.EXM)+1) + lastExtent // Numero de l'extent dans le fichier
+		dirLoc.NumPage = uint8(extent & 0x1F)
+		dirLoc.Unused[1] = uint8(extent >> 5) // S2, poids fort du numero d'extent
+		dirLoc.NbPages = uint8(records - lastExtent<<7)
+		nbPages++
+
+		l := (records + p.RecordsPerBloc() - 1) / p.RecordsPerBloc() // Nombre de blocs de l'entree arrondi par le haut
+		for i := range dirLoc.Blocks {
+			dirLoc.Blocks[i] = 0
 		}
-		err = binary.Write(&rbuff, binary.LittleEndian, buff)
+		for j := 0; j < l; j++ { // Pour chaque bloc de la page
+			bloc := d.RechercheBlocLibre(int(maxBloc)) // Met le fichier sur la disquette
+			if bloc == 0 {
+				return ErrorNoBloc
+			}
+			p.SetEntryBloc(&dirLoc, j, bloc)
+			err = d.WriteBloc(int(bloc), bufFile, uint32(posFile))
+			if err != nil {
+				fmt.Fprintf(os.Stdout, "error while writing bloc %v\n", err)
+			}
+			posFile += p.BlocSize() // Passe au bloc suivant
+		}
+		err = d.SetInfoDirEntry(posDir, dirLoc)
 		if err != nil {
-			fmt.Fprintf(os.Stdout, "error while writing in content %v\n", err)
+			fmt.Fprintf(os.Stdout, "error while set info in directory %v\n", err)
 		}
-		buff = rbuff.Bytes()
-		//	memmove( &Buff[ sizeof( StAmsdos ) ], Buff, Lg );
-		//         	memcpy( Buff, e, sizeof( StAmsdos ) );
-		//       	Lg += sizeof( StAmsdos );
 	}
-	if fileLength > 65536 {
-		return ErrorFileSizeExceed
+	return nil
+}
+
+// checkFreeSpace verifies that enough blocs and directory entries are free
+// to store a file of fileLength bytes, the bitmap must be filled.
+func (d *DSK) checkFreeSpace(fileLength uint32, maxBloc int) error {
+	neededBlocs, neededEntries := d.DiskParams().fileNeeds(int(fileLength))
+	freeBlocs, freeEntries := d.freeSpace(maxBloc)
+	if freeBlocs < neededBlocs {
+		return ErrorNoBloc
+	}
+	if freeEntries < neededEntries {
+		return ErrorNoDirEntry
 	}
-	// if (MODE_BINAIRE) ClearAmsdos(Buff); //Remplace les octets inutilises par des 0 dans l'en-tete
-	return d.CopyFile(buff, cFileName, uint16(fileSize), 256, userNumber, isSystemFile, readOnly, hidden)
+	return nil
 }
 
-// Copie un fichier sur le DSK
-//
-// la taille est determine par le nombre de NbPages
-// regarder pourquoi different d'une autre DSK
-func (d *DSK) CopyFile(bufFile []byte, fileName string, fileLength, maxBloc, userNumber uint16, isSystemFile, readOnly, isHide bool) error {
-	var nbPages, taillePage int
-	d.FillBitmap()
-	dirLoc := d.GetNomDir(fileName, isHide)
-	var posFile uint16                       // Construit l'entree pour mettre dans le catalogue
-	for posFile = 0; posFile < fileLength; { // Pour chaque bloc du fichier
-		posDir, err := d.RechercheDirLibre() // Trouve une entree libre dans le CAT
-		if err == nil {
-			dirLoc.User = uint8(userNumber) // Remplit l'entree : User 0
-			if isSystemFile || readOnly {
-				dirLoc.Ext[0] |= 0x80
-			}
-			dirLoc.NumPage = uint8(nbPages) // Numero de l'entree dans le fichier
-			nbPages++
-			taillePage = ((int(fileLength) - int(posFile) + 127) >> 7) // Taille de la page (on arrondit par le haut)
-			if taillePage > 128 {                                      // Si y'a plus de 16k il faut plusieurs pages
-				taillePage = 128
-			}
+// fileNeeds returns the number of blocs and directory entries used by a file of length bytes
+func (p DiskParams) fileNeeds(length int) (blocs, entries int) {
+	records := (length + 127) >> 7
+	blocs = (records + p.RecordsPerBloc() - 1) / p.RecordsPerBloc()
+	entries = max((records+p.RecordsPerEntry()-1)/p.RecordsPerEntry(), 1)
+	return blocs, entries
+}
 
-			dirLoc.NbPages = uint8(taillePage)
-			l := (dirLoc.NbPages + 7) >> 3 // Nombre de blocs=TaillePage/8 arrondi par le haut
-			for i := 0; i < 16; i++ {
-				dirLoc.Blocks[i] = 0
-			}
-			var j uint8
-			for j = 0; j < l; j++ { // Pour chaque bloc de la page
-				bloc := d.RechercheBlocLibre(int(maxBloc)) // Met le fichier sur la disquette
-				//	fmt.Fprintf(os.Stdout,"Bloc:%d, MaxBloc:%d\n",bloc,maxBloc)
-				if bloc != 0 {
-					dirLoc.Blocks[j] = bloc
-					err = d.WriteBloc(int(bloc), bufFile, posFile)
-					if err != nil {
-						fmt.Fprintf(os.Stdout, "error while writing bloc %v\n", err)
-					}
-					posFile += 1024 // Passe au bloc suivant
-
-				} else {
-					return ErrorNoBloc
-				}
-			}
-			// fmt.Fprintf(os.Stdout, "posDir:%d dirloc:%v\n", posDir, dirLoc)
-			err = d.SetInfoDirEntry(posDir, dirLoc)
-			if err != nil {
-				fmt.Fprintf(os.Stdout, "error while set info in directory %v\n", err)
-			}
-		} else {
-			return ErrorNoDirEntry
+// freeSpace returns the number of free blocs below maxBloc and of free
+// directory entries, the bitmap must be filled.
+func (d *DSK) freeSpace(maxBloc int) (blocs, entries int) {
+	p := d.DiskParams()
+	for i := p.DirBlocs(); i < maxBloc && i <= int(p.DSM); i++ {
+		if d.BitMap[i] == 0 {
+			blocs++
 		}
 	}
-	return nil
+	for i := 0; i < p.DirEntries(); i++ {
+		dir, err := d.GetInfoDirEntry(i)
+		if err == nil && dir.User == USER_DELETED {
+			entries++
+		}
+	}
+	return blocs, entries
 }
 
 func (d *DSK) FillBitmap() int {
-	for i := 0; i < len(d.BitMap); i++ {
-		d.BitMap[i] = 0
+	p := d.DiskParams()
+	d.CleanBitmap()
+	dirBlocs := uint16(p.AL0)<<8 | uint16(p.AL1)
+	for i := 0; i < 16; i++ {
+		if dirBlocs&(0x8000>>i) != 0 {
+			d.BitMap[i] = 1
+		}
 	}
-	d.BitMap[0] = 1
-	d.BitMap[1] = 1
 	var nbKo int
-	for i := 0; i < 64; i++ {
-		dir, _ := d.GetInfoDirEntry(uint8(i))
+	for i := 0; i < p.DirEntries(); i++ {
+		dir, _ := d.GetInfoDirEntry(i)
 		if dir.User != USER_DELETED {
-			for j := 0; j < 16; j++ {
-				b := dir.Blocks[j]
-				if b > 1 && d.BitMap[b] != 1 {
+			for _, b := range p.EntryBlocs(dir) {
+				if int(b) < len(d.BitMap) && d.BitMap[b] != 1 {
 					d.BitMap[b] = 1
-					nbKo++
+					nbKo += p.BlocSize() / 1024
 				}
 			}
 		}
@@ -922,207 +1031,195 @@ func (d *DSK) GetNomDir(nomFile string, isHide bool) StDirEntry {
 	return e
 }
 
-func (d *DSK) CopyRawFile(bufFile []byte, fileLength uint16, track, sector int) (int, int, error) {
+// CopyRawFile writes the file from the sector sect of the track of the
+// cylinder and head, the following sectors on the next sides and cylinders.
+// The cylinders are counted from the first track of the disk, the reserved
+// tracks included. It returns the cylinder, head and sector following the file.
+func (d *DSK) CopyRawFile(bufFile []byte, fileLength uint16, cyl, head, sect int) (int, int, int, error) {
 	d.FillBitmap()
 
 	var posFile uint16 // Construit l'entree pour mettre dans le catalogue
 	var err error
 	var written int
 	for posFile = 0; posFile < fileLength; { // Pour chaque bloc du fichier
-		track, sector, written, err = d.WriteAtTrackSector(track, sector, bufFile, posFile)
+		cyl, head, sect, written, err = d.WriteAtTrackSector(cyl, head, sect, bufFile, posFile)
 		if err != nil {
-			return track, sector, err
+			return cyl, head, sect, err
+		}
+		if written == 0 {
+			break // fin du fichier
 		}
 		posFile += uint16(written) // Passe à la position suivante
 	}
-	return track, sector, nil
+	return cyl, head, sect, nil
 }
 
-func (d *DSK) WriteAtTrackSector(track int, sect int, bufBloc []byte, offset uint16) (int, int, int, error) {
+// nextTrack returns the cylinder and head of the track following the track
+// of the cylinder and head, the sides of a cylinder first
+func (d *DSK) nextTrack(cyl, head int) (int, int) {
+	if head+1 < max(int(d.Entry.NbHeads), 1) {
+		return cyl, head + 1
+	}
+	return cyl + 1, 0
+}
+
+// WriteAtTrackSector writes two sectors of bufBloc from offset, from the
+// sector sect of the track of the cylinder and head. It returns the
+// cylinder, head and sector following the data written.
+func (d *DSK) WriteAtTrackSector(cyl, head, sect int, bufBloc []byte, offset uint16) (int, int, int, int, error) {
 	var dataWritten int
 	minSect := d.GetMinSect()
-	//
-	// Ajuste le nombre de pistes si depassement capacite
-	//
-	if track > int(d.Entry.NbTracks-1) {
-		if d.Entry.NbHeads == 1 {
-			d.FormatTrack(0, uint8(track), 0, minSect, 9)
-		} else {
-			currentHead := d.Tracks[track-1].Head
-			if currentHead == 0 {
-				d.FormatTrack(0, uint8(track), 0, minSect, 9)
-			} else {
-				d.FormatTrack(0, uint8(track), 1, minSect, 9)
-			}
-		}
-	}
-	if sect > 8 {
-		track++
-		sect = 0
-	}
-	sectorSize := uint16(d.Tracks[track].Sect[sect].SizeByte)
-	pos := d.GetPosData(uint8(track), uint8(sect)+minSect, true)
-	maxSize := sectorSize
-	if len(bufBloc) < int(offset+maxSize) {
-		maxSize = uint16(len(bufBloc))
-	}
-	dataWritten += copy(d.Tracks[track].Data[pos:], bufBloc[offset:offset+maxSize])
-	if len(bufBloc) < int(offset+(maxSize*2)) {
-		return track, sect, dataWritten, nil
-	}
-	sect++
-	if sect > 8 {
-		track++
-		sect = 0
+	p := d.DiskParams()
+	heads := max(int(d.Entry.NbHeads), 1)
+	if head < 0 || head >= heads {
+		return cyl, head, sect, 0, fmt.Errorf("%w: no track %d head %d", ErrorSectorNotFound, cyl, head)
 	}
-	if track > int(d.Entry.NbTracks-1) {
-		if d.Entry.NbHeads == 1 {
-			d.FormatTrack(0, uint8(track), 0, minSect, 9)
-		} else {
-			currentHead := d.Tracks[track-1].Head
-			if currentHead == 0 {
-				d.FormatTrack(0, uint8(track), 0, minSect, 9)
-			} else {
-				d.FormatTrack(0, uint8(track), 1, minSect, 9)
-			}
+	for range 2 {
+		if int(offset)+dataWritten >= len(bufBloc) {
+			break
 		}
+		//
+		// Ajuste le nombre de pistes si depassement capacite
+		//
+		d.grow(cyl+1, p.FirstSector, uint8(p.SectorsPerTrack()))
+		if sect >= int(d.Track(cyl, head).NbSect) {
+			cyl, head = d.nextTrack(cyl, head)
+			sect = 0
+			d.grow(cyl+1, p.FirstSector, uint8(p.SectorsPerTrack()))
+		}
+		tr := d.Track(cyl, head)
+		pos := int(tr.posData(uint8(sect)+minSect, true))
+		end := min(pos+tr.sectorSize(sect), len(tr.Data))
+		dataWritten += copy(tr.Data[pos:end], bufBloc[int(offset)+dataWritten:])
+		sect++
 	}
-	sectorSize = uint16(d.Tracks[track].Sect[sect].SizeByte)
-	pos = d.GetPosData(uint8(track), uint8(sect)+minSect, true)
-	maxSize = sectorSize*2 + offset
-	if (len(bufBloc) - dataWritten - int(offset)) < int(maxSize) {
-		maxSize = uint16(len(bufBloc))
-	}
-	dataWritten += copy(d.Tracks[track].Data[pos:], bufBloc[offset+sectorSize:maxSize])
-	sect++
-	return track, sect, dataWritten, nil
+	return cyl, head, sect, dataWritten, nil
 }
 
-func (d *DSK) WriteBloc(bloc int, bufBloc []byte, offset uint16) error {
-	track := (bloc << 1) / 9
-	sect := (bloc << 1) % 9
-	minSect := d.GetMinSect()
-	if minSect == 0x41 {
-		track += 2
-	} else {
-		if minSect == 0x01 {
-			track++
-		}
-	}
-	//
-	// Ajuste le nombre de pistes si depassement capacite
-	//
-	if track > int(d.Entry.NbTracks-1) {
-		if d.Entry.NbHeads == 1 {
-			d.FormatTrack(0, uint8(track), 0, minSect, 9)
-		} else {
-			currentHead := d.Tracks[track-1].Head
-			if currentHead == 0 {
-				d.FormatTrack(0, uint8(track), 0, minSect, 9)
-			} else {
-				d.FormatTrack(0, uint8(track), 1, minSect, 9)
-			}
-		}
+// sectorPos returns the track index and the position in the track data of a
+// logical sector, sectors being counted from the first sector of the disk.
+func (d *DSK) sectorPos(p DiskParams, sector int) (int, int) {
+	spt := p.SectorsPerTrack()
+	track := sector / spt
+	if track >= len(d.Tracks) {
+		return track, 0
 	}
-	if sect > 8 {
-		track++
-		sect = 0
+	return track, int(d.Tracks[track].posData(p.FirstSector+uint8(sector%spt), true))
+}
+
+// blocSector returns the first logical sector of a bloc
+func (p DiskParams) blocSector(bloc int) int {
+	return int(p.OFF)*p.SectorsPerTrack() + bloc*p.SectorsPerBloc()
+}
+
+func (d *DSK) WriteBloc(bloc int, bufBloc []byte, offset uint32) error {
+	p := d.DiskParams()
+	if bloc > int(p.DSM) {
+		return ErrorBlocOutOfRange
 	}
-	pos := d.GetPosData(uint8(track), uint8(sect)+minSect, true)
-	copy(d.Tracks[track].Data[pos:], bufBloc[offset:offset+SECTSIZE])
-	sect++
-	if sect > 8 {
-		track++
-		sect = 0
+	if int(offset) >= len(bufBloc) {
+		return nil
 	}
-	if track > int(d.Entry.NbTracks-1) {
-		if d.Entry.NbHeads == 1 {
-			d.FormatTrack(0, uint8(track), 0, minSect, 9)
-		} else {
-			currentHead := d.Tracks[track-1].Head
-			if currentHead == 0 {
-				d.FormatTrack(0, uint8(track), 0, minSect, 9)
-			} else {
-				d.FormatTrack(0, uint8(track), 1, minSect, 9)
-			}
+	// le dernier bloc du fichier est complete par des zeros
+	data := make([]byte, p.BlocSize())
+	copy(data, bufBloc[offset:])
+	sectorSize := p.SectorSize()
+	first := p.blocSector(bloc)
+	for s := 0; s < p.SectorsPerBloc(); s++ {
+		start := s * sectorSize
+		//
+		// Ajuste le nombre de pistes si depassement capacite
+		//
+		track, _ := d.sectorPos(p, first+s)
+		d.grow(track/max(int(d.Entry.NbHeads), 1)+1, p.FirstSector, uint8(p.SectorsPerTrack()))
+		track, pos := d.sectorPos(p, first+s)
+		if pos >= len(d.Tracks[track].Data) {
+			continue
 		}
+		copy(d.Tracks[track].Data[pos:pos+min(sectorSize, len(d.Tracks[track].Data)-pos)], data[start:])
 	}
-	pos = d.GetPosData(uint8(track), uint8(sect)+minSect, true)
-	copy(d.Tracks[track].Data[pos:], bufBloc[offset+SECTSIZE:offset+(SECTSIZE*2)])
 	return nil
 }
 
-func (d *DSK) ExtractRawFile(fileLength uint16, track, sector int) (int, int, []byte) {
+// ExtractRawFile reads the file from the sector sect of the track of the
+// cylinder and head, the following sectors on the next sides and cylinders.
+// The cylinders are counted from the first track after the reserved tracks
+// of the disk parameters. It returns the cylinder, head and sector following
+// the file.
+func (d *DSK) ExtractRawFile(fileLength uint16, cyl, head, sect int) (int, int, int, []byte) {
 	d.FillBitmap()
 	content := make([]byte, 0)
 	var posFile uint16 // Construit l'entree pour mettre dans le catalogue
 	var buf []byte
 
 	for posFile = 0; posFile < fileLength; { // Pour chaque bloc du fichier
-		track, sector, buf = d.ReadAtTrackSector(track, sector)
+		cyl, head, sect, buf = d.ReadAtTrackSector(cyl, head, sect)
+		if len(buf) == 0 {
+			break // end of the dsk
+		}
 		posFile += uint16(len(buf)) // Passe à la position suivante
 		content = append(content, buf...)
 	}
-	return track, sector, content
+	return cyl, head, sect, content
 }
 
-func (d *DSK) ReadAtTrackSector(track, sect int) (int, int, []byte) {
+// ReadAtTrackSector reads two sectors from the sector sect of the track of
+// the cylinder and head, counted from the first track after the reserved
+// tracks. It returns the cylinder, head and sector following the data read.
+func (d *DSK) ReadAtTrackSector(cyl, head, sect int) (int, int, int, []byte) {
 	minSect := d.GetMinSect()
-	if minSect == 0x41 {
-		track += 2
-	} else {
-		if minSect == 0x01 {
-			track++
-		}
+	heads := max(int(d.Entry.NbHeads), 1)
+	// the reserved tracks are counted side by side
+	reserved := int(d.DiskParams().OFF)
+	track := cyl*heads + head + reserved
+	if track < len(d.Tracks) && sect >= int(d.Tracks[track].NbSect) {
+		track++
+		sect = 0
 	}
-	sectorSize := uint16(d.Tracks[track].Sect[sect].SizeByte)
-	pos := d.GetPosData(uint8(track), uint8(sect)+minSect, true)
+	position := func() (int, int, int) {
+		return (track - reserved) / heads, (track - reserved) % heads, sect
+	}
+	if track >= len(d.Tracks) {
+		cyl, head, sect = position()
+		return cyl, head, sect, nil
+	}
+	tr := &d.Tracks[track]
+	sectorSize := uint16(tr.sectorSize(sect))
+	pos := tr.posData(uint8(sect)+minSect, true)
 	bufBloc1 := make([]byte, sectorSize)
-	copy(bufBloc1, d.Tracks[track].Data[pos:pos+sectorSize])
-	// int Pos = GetPosData( track, sect + MinSect, true );
-	// memcpy( BufBloc, &ImgDsk[ Pos ], SECTSIZE );
+	copy(bufBloc1, tr.Data[pos:pos+sectorSize])
 	sect++
-	if sect > 8 {
+	if sect >= int(tr.NbSect) {
 		track++
 		sect = 0
 	}
-	sectorSize = uint16(d.Tracks[track].Sect[sect].SizeByte)
-
+	if track >= len(d.Tracks) {
+		cyl, head, sect = position()
+		return cyl, head, sect, bufBloc1
+	}
+	tr = &d.Tracks[track]
+	sectorSize = uint16(tr.sectorSize(sect))
 	bufBloc2 := make([]byte, sectorSize)
-	pos = d.GetPosData(uint8(track), uint8(sect)+minSect, true)
-	copy(bufBloc2, d.Tracks[track].Data[pos:pos+sectorSize])
+	pos = tr.posData(uint8(sect)+minSect, true)
+	copy(bufBloc2, tr.Data[pos:pos+sectorSize])
 	sect++
-	//   Pos = GetPosData( track, sect + MinSect, true );
-	// memcpy( &BufBloc[ SECTSIZE ], &ImgDsk[ Pos ], SECTSIZE );
 	bufBloc1 = append(bufBloc1, bufBloc2...)
-	return track, sect, bufBloc1
+	cyl, head, sect = position()
+	return cyl, head, sect, bufBloc1
 }
 
 func (d *DSK) ReadBloc(bloc int) []byte {
-	bufBloc := make([]byte, SECTSIZE*2)
-	track := (bloc << 1) / 9
-	sect := (bloc << 1) % 9
-	minSect := d.GetMinSect()
-	if minSect == 0x41 {
-		track += 2
-	} else {
-		if minSect == 0x01 {
-			track++
+	p := d.DiskParams()
+	bufBloc := make([]byte, p.BlocSize())
+	sectorSize := p.SectorSize()
+	first := p.blocSector(bloc)
+	for s := 0; s < p.SectorsPerBloc(); s++ {
+		track, pos := d.sectorPos(p, first+s)
+		if track >= len(d.Tracks) || pos >= len(d.Tracks[track].Data) {
+			continue
 		}
+		copy(bufBloc[s*sectorSize:(s+1)*sectorSize], d.Tracks[track].Data[pos:])
 	}
-	pos := d.GetPosData(uint8(track), uint8(sect)+minSect, true)
-	copy(bufBloc[0:], d.Tracks[track].Data[pos:pos+SECTSIZE])
-	// int Pos = GetPosData( track, sect + MinSect, true );
-	// memcpy( BufBloc, &ImgDsk[ Pos ], SECTSIZE );
-	sect++
-	if sect > 8 {
-		track++
-		sect = 0
-	}
-	pos = d.GetPosData(uint8(track), uint8(sect)+minSect, true)
-	copy(bufBloc[SECTSIZE:], d.Tracks[track].Data[pos:pos+SECTSIZE])
-	//   Pos = GetPosData( track, sect + MinSect, true );
-	// memcpy( &BufBloc[ SECTSIZE ], &ImgDsk[ Pos ], SECTSIZE );
 	return bufBloc
 }
 
@@ -1130,11 +1227,13 @@ func (d *DSK) ReadBloc(bloc int) []byte {
 // Recherche un bloc libre et le remplit
 //
 
-func (d *DSK) RechercheBlocLibre(maxBloc int) uint8 {
-	for i := 2; i < maxBloc; i++ {
+func (d *DSK) RechercheBlocLibre(maxBloc int) uint16 {
+	p := d.DiskParams()
+	d.allocBitmap()
+	for i := p.DirBlocs(); i < maxBloc && i <= int(p.DSM); i++ {
 		if d.BitMap[i] == 0 {
 			d.BitMap[i] = 1
-			return uint8(i)
+			return uint16(i)
 		}
 	}
 	return 0
@@ -1144,11 +1243,11 @@ func (d *DSK) RechercheBlocLibre(maxBloc int) uint8 {
 // Recherche une entree de repertoire libre
 //
 
-func (d *DSK) RechercheDirLibre() (uint8, error) {
-	for i := 0; i < 64; i++ {
-		dir, _ := d.GetInfoDirEntry(uint8(i))
+func (d *DSK) RechercheDirLibre() (int, error) {
+	for i := 0; i < d.DiskParams().DirEntries(); i++ {
+		dir, _ := d.GetInfoDirEntry(i)
 		if dir.User == USER_DELETED {
-			return uint8(i), nil
+			return i, nil
 		}
 	}
 	return 0, ErrorNoDirEntry
@@ -1159,7 +1258,7 @@ func (d *DSK) DisplayCatalogue() {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error while getting the catalogue error :%v\n", err)
 	}
-	for i := 0; i < 64; i++ {
+	for i := range d.Catalogue {
 		entry := d.Catalogue[i]
 		if entry.User != USER_DELETED && entry.NumPage != 0 {
 			fmt.Fprintf(os.Stderr, "%s.%s : %d\n", entry.Nom, entry.Ext, entry.User)
@@ -1173,7 +1272,7 @@ func (d *DSK) GetEntryyNameInCatalogue(num int) string {
 		fmt.Fprintf(os.Stderr, "error while getting the catalogue error :%v\n", err)
 	}
 	var nom string
-	for i := 0; i < 64; i++ {
+	for i := range d.Catalogue {
 		entry := d.Catalogue[i]
 		if entry.User != USER_DELETED && entry.NumPage != 0 && i == num {
 			nom = fmt.Sprintf("%.8s.%.3s", entry.Nom, entry.Ext)
@@ -1189,51 +1288,27 @@ func (d *DSK) GetEntrySizeInCatalogue(num int) string {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error while getting the catalogue error :%v\n", err)
 	}
-	for i := 0; i < 64; i++ {
+	for i := range d.Catalogue {
 		entry := d.Catalogue[i]
 		if entry.User != USER_DELETED && entry.NumPage != 0 && i == num {
-			var p, t int
-			for {
-				if d.Catalogue[p+i].User == entry.User {
-					t += int(d.Catalogue[p+i].NbPages)
-				}
-				p++
-				if d.Catalogue[p+i].NumPage != 0 || p+i >= 64 {
-					break
-				}
-			}
-			return fmt.Sprintf("%d ko", (t+7)>>3)
+			f, _ := d.FileAt(i)
+			return fmt.Sprintf("%d ko", (f.Records(d.DiskParams())+7)>>3)
 		}
 	}
 	return ""
 }
 
 func (d *DSK) GetFilesize(s StDirEntry) int {
-	t := 0
-	for i := 0; i < 64; i++ {
-		if d.Catalogue[i].User != USER_DELETED {
-			if d.Catalogue[i].Nom == s.Nom &&
-				d.Catalogue[i].Ext == s.Ext {
-				t += int(d.Catalogue[i].NbPages)
-			}
-		}
-	}
-	return (t + 7) >> 3
+	f, _ := d.LookupFile(s.User, s.Nom, s.Ext)
+	return (f.Records(d.DiskParams()) + 7) >> 3
 }
 
 func (d *DSK) GetFilesIndices() []int {
 	indices := make([]int, 0)
-	cache := make(map[string]bool)
-	for i := 0; i < 64; i++ {
-		if d.Catalogue[i].User != USER_DELETED {
-			filename := fmt.Sprintf("%s.%s", d.Catalogue[i].Nom, d.Catalogue[i].Ext)
-			if !cache[filename] {
-				cache[filename] = true
-				indices = append(indices, i)
-			}
-		}
+	files, _ := d.Files()
+	for _, f := range files {
+		indices = append(indices, f.Indices[0])
 	}
-
 	return indices
 }
 
@@ -1241,8 +1316,9 @@ func (d *DSK) GetCatalogue() error {
 	if d.catalogueLoaded {
 		return nil
 	}
-	for i := 0; i < 64; i++ {
-		dirEntry, err := d.GetInfoDirEntry(uint8(i))
+	d.Catalogue = make([]StDirEntry, d.DiskParams().DirEntries())
+	for i := range d.Catalogue {
+		dirEntry, err := d.GetInfoDirEntry(i)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error while reading catalogue error :%v\n", err)
 		}
@@ -1252,16 +1328,24 @@ func (d *DSK) GetCatalogue() error {
 	return nil
 }
 
-func (d *DSK) SetInfoDirEntry(numDir uint8, e StDirEntry) error {
-	minSect := d.GetMinSect()
-	s := (numDir >> 4) + minSect
-	var t uint8
-	if minSect == 0x41 {
-		t = 2
+// dirEntryPos returns the track index and the position in the track data of a directory entry
+func (d *DSK) dirEntryPos(p DiskParams, numDir int) (int, int, error) {
+	if numDir < 0 || numDir >= p.DirEntries() {
+		return 0, 0, ErrorCatalogueExceed
+	}
+	offset := numDir * 32
+	track, pos := d.sectorPos(p, p.blocSector(0)+offset/p.SectorSize())
+	pos += offset % p.SectorSize()
+	if track >= len(d.Tracks) || pos+32 > len(d.Tracks[track].Data) {
+		return 0, 0, ErrorCatalogueExceed
 	}
+	return track, pos, nil
+}
 
-	if minSect == 1 {
-		t = 1
+func (d *DSK) SetInfoDirEntry(numDir int, e StDirEntry) error {
+	t, pos, err := d.dirEntryPos(d.DiskParams(), numDir)
+	if err != nil {
+		return err
 	}
 	var data bytes.Buffer
 
@@ -1269,42 +1353,23 @@ func (d *DSK) SetInfoDirEntry(numDir uint8, e StDirEntry) error {
 		fmt.Fprintf(os.Stderr, "Error while writing StDirEntry structure with error :%v\n", err)
 		return err
 	}
-	entry := data.Bytes()
-	for i := 0; i < 16; i++ {
-		pos := d.GetPosData(t, s, true)
-		//	fmt.Fprintf(os.Stdout, "t:%d,s:%d,pos:%d\n", t, s, pos)
-		//	fmt.Fprintf(os.Stdout,"offset:%d\n",((uint16(numDir)&15)<<5) + d.GetPosData(t, s, true))
-		copy(d.Tracks[t].Data[((uint16(numDir)&15)<<5)+pos:((uint16(numDir)&15)<<5)+pos+uint16(binary.Size(entry))], entry[:])
+	copy(d.Tracks[t].Data[pos:pos+32], data.Bytes())
+	if d.catalogueLoaded && numDir < len(d.Catalogue) {
+		d.Catalogue[numDir] = e
 	}
 	return nil
 }
 
-func (d *DSK) GetInfoDirEntry(numDir uint8) (StDirEntry, error) {
+func (d *DSK) GetInfoDirEntry(numDir int) (StDirEntry, error) {
 	dir := StDirEntry{}
-	minSect := d.GetMinSect()
-	s := (numDir >> 4) + minSect
-	var t uint8
-	if minSect == 0x41 {
-		t = 2
-	}
-
-	if minSect == 1 {
-		t = 1
-	}
-
-	pos := d.GetPosData(t, s, true)
-	if int(pos) >= len(d.Tracks[t].Data) {
-		pos = 0
+	t, pos, err := d.dirEntryPos(d.DiskParams(), numDir)
+	if err != nil {
+		return dir, err
 	}
-	data := d.Tracks[t].Data[((uint16(numDir)&15)<<5)+pos : ((uint16(numDir)&15)<<5)+pos+32]
-	buffer := bytes.NewReader(data[:])
+	buffer := bytes.NewReader(d.Tracks[t].Data[pos : pos+32])
 	if err := binary.Read(buffer, binary.LittleEndian, &dir); err != nil {
 		return dir, err
 	}
-	// memcpy( &Dir
-	//		, &ImgDsk[ ( ( NumDir & 15 ) << 5 ) + GetPosData( t, s, true ) ]
-	//		, sizeof( StDirEntry )
-	//		);
 	return dir, nil
 }
 
@@ -1328,8 +1393,8 @@ func (d *DSK) GetType(langue int, ams *amsdos.StAmsdos) string {
 }
 
 func (d *DSK) FileExists(entry StDirEntry) int {
-	for i := 0; i < 64; i++ {
-		dir, err := d.GetInfoDirEntry(uint8(i))
+	for i := 0; i < d.DiskParams().DirEntries(); i++ {
+		dir, err := d.GetInfoDirEntry(i)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error while getting info dir entry (%d) error :%v\n", i, err)
 		} else {
@@ -1349,124 +1414,36 @@ func (d *DSK) FileExists(entry StDirEntry) int {
 }
 
 func (d *DSK) GetFileIn(filename string, indice int) ([]byte, error) {
-	i := indice
-	lMax := 0x1000000
-	b := make([]byte, 0)
-	firstBlock := true
-	/*	tabDir := make([]StDirEntry, 64)
-		for j := 0; j < 64; j++ {
-			tabDir[j], _ = d.GetInfoDirEntry(uint8(j))
-		}*/
-	err := d.GetCatalogue()
+	f, err := d.FileAt(indice)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error while getting the catalogue error :%v\n", err)
-	}
-	entryIndice := d.Catalogue[i]
-	var cumul, tailleFichier int
-	var isAmsdos bool
-	for {
-		l := (d.Catalogue[i].NbPages + 7) >> 3
-		for j := 0; uint8(j) < l; j++ {
-			tailleBloc := 1024
-			bloc := d.ReadBloc(int(d.Catalogue[i].Blocks[j]))
-			if firstBlock {
-				var header *cpc.CpcHead
-				isAmsdos, header = amsdos.CheckAmsdos(bloc)
-				if isAmsdos {
-					tailleFichier = int(header.Size) + 0x80
-				}
-				firstBlock = false
-			}
-			var nbOctets int
-			if lMax > tailleBloc {
-				nbOctets = tailleBloc
-			} else {
-				nbOctets = lMax
-			}
-			if nbOctets > 0 {
-				b = append(b, bloc...)
-				cumul += nbOctets
-			}
-			lMax -= 1024
-		}
-		i++
-		if i >= 64 {
-			return b, errors.New("cannot get the file, Exceed catalogue indice")
-		}
-		if entryIndice.Nom != d.Catalogue[i].Nom || entryIndice.Ext != d.Catalogue[i].Ext {
-			break
-		}
-	}
-	if tailleFichier <= 0 || tailleFichier <= cumul {
-		tailleFichier = cumul
-	}
-
-	/*if !isAmsdos {
-		keepOn := true
-		for i = tailleFichier - 1; i >= 0; i-- {
-			if b[i] == 0 {
-				tailleFichier--
-			} else {
-				keepOn = false
-			}
-			if !keepOn {
-				break
-			}
-		}
-	}*/
-	if tailleFichier > len(b) {
-		return b, nil
+		return []byte{}, err
 	}
-	return b[0:tailleFichier], nil
+	return d.fileContent(f), nil
 }
 
 func (d *DSK) ViewFile(indice int) ([]byte, int, error) {
-	i := indice
-	lMax := 0x1000000
 	b := make([]byte, 0)
-	firstBlock := true
-	err := d.GetCatalogue()
+	p := d.DiskParams()
+	f, err := d.FileAt(indice)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error while getting the catalogue error :%v\n", err)
+		return b, 0, err
 	}
-	entryIndice := d.Catalogue[i]
 	var tailleFichier, cumul int
-	for {
-		l := (d.Catalogue[i].NbPages + 7) >> 3
-		var j uint8
-		for j = 0; j < l; j++ {
-			tailleBloc := 1024
-			bloc := d.ReadBloc(int(d.Catalogue[i].Blocks[j]))
-			if firstBlock {
-				isAmsdos, header := amsdos.CheckAmsdos(bloc)
-				if isAmsdos {
-					t := make([]byte, len(bloc))
-					copy(t, bloc[HeaderSize:])
-					bloc = t
-					tailleBloc -= HeaderSize
-					tailleFichier = int(header.Size)
-				}
-				firstBlock = false
-			}
-			var nbOctets int
-			if lMax > tailleBloc {
-				nbOctets = tailleBloc
-			} else {
-				nbOctets = lMax
-			}
-			if nbOctets > 0 {
-				b = append(b, bloc...)
-				cumul += nbOctets
+	for i, blocNum := range f.Blocs(p) {
+		tailleBloc := p.BlocSize()
+		bloc := d.ReadBloc(int(blocNum))
+		if i == 0 {
+			isAmsdos, header := amsdos.CheckAmsdos(bloc)
+			if isAmsdos {
+				t := make([]byte, len(bloc))
+				copy(t, bloc[HeaderSize:])
+				bloc = t
+				tailleBloc -= HeaderSize
+				tailleFichier = amsdosLength(header)
 			}
-			lMax -= 1024
-		}
-		i++
-		if i >= 64 {
-			return b, cumul, errors.New("cannot get the file, Exceed catalogue indice")
-		}
-		if entryIndice.Nom != d.Catalogue[i].Nom || entryIndice.Ext != d.Catalogue[i].Ext {
-			break
 		}
+		b = append(b, bloc...)
+		cumul += tailleBloc
 	}
 	if tailleFichier == 0 {
 		tailleFichier = cumul
@@ -1481,29 +1458,23 @@ func (d *DSK) ViewFile(indice int) ([]byte, int, error) {
 	return b, tailleFichier, nil
 }
 
-func (d *DSK) RemoveFile(indice uint8) error {
-	err := d.GetCatalogue()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error while getting the catalogue error :%v\n", err)
+// RemoveFile deletes every directory entry of the file owning the catalogue
+// entry at indice, a file with missing or duplicate extents is removed as well.
+func (d *DSK) RemoveFile(indice int) error {
+	f, err := d.FileAt(indice)
+	if err != nil && !errors.Is(err, ErrorMissingExtent) && !errors.Is(err, ErrorDuplicateExtent) {
+		return err
 	}
-	entryIndice := d.Catalogue[indice]
-
-	for {
-
-		entry, err := d.GetInfoDirEntry(indice)
+	for _, i := range f.Indices {
+		entry, err := d.GetInfoDirEntry(i)
 		if err != nil {
 			return ErrorNoDirEntry
 		}
-		if entryIndice.Nom != d.Catalogue[indice].Nom || entryIndice.Ext != d.Catalogue[indice].Ext {
-			break
-		}
-		d.Catalogue[indice].User = USER_DELETED
+		d.Catalogue[i].User = USER_DELETED
 		entry.User = USER_DELETED
-		if err := d.SetInfoDirEntry(indice, entry); err != nil {
+		if err := d.SetInfoDirEntry(i, entry); err != nil {
 			return ErrorNoDirEntry
 		}
-		indice++
-
 	}
 	return nil
 }
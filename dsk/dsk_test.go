@@ -177,7 +177,7 @@ func TestPut(t *testing.T) {
 	data := generateData(40136)
 	name := GetNomAmsdos("FILE.BIN")
 	data = addHeader(data, name)
-	assert.NoError(t, d.CopyFile(data, name, uint16(len(data)), 256, uint16(MODE_BINAIRE), false, false, false))
+	assert.NoError(t, d.CopyFile(data, name, uint32(len(data)), 256, uint16(MODE_BINAIRE), false, false, false))
 	_, err := d.GetFileIn(name, 0)
 	assert.NoError(t, err)
 
@@ -0,0 +1,273 @@
+package dsk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jeromelesaux/dsk/amsdos"
+)
+
+var (
+	ErrorFileNotFound    = errors.New("file not found in catalogue")
+	ErrorMissingExtent   = errors.New("missing file extent")
+	ErrorDuplicateExtent = errors.New("duplicate file extent")
+	ErrorFileExists      = errors.New("file already exists in catalogue")
+)
+
+// FileOptions describes how AddFile stores a file.
+// Type is one of MODE_BASIC, MODE_PROTECTED, MODE_BINAIRE or MODE_ASCII,
+// files other than ASCII get an AMSDOS header unless the data already starts with one.
+type FileOptions struct {
+	Type     uint8
+	Load     uint16
+	Exec     uint16
+	User     uint8
+	System   bool // hidden from the catalogue
+	ReadOnly bool
+}
+
+// CatalogueFile gathers the directory entries of a file sharing the same
+// user, name and extension, ordered by extent number.
+// The name and extension are stored without the attribute bits.
+type CatalogueFile struct {
+	User    uint8
+	Nom     [8]byte
+	Ext     [3]byte
+	Indices []int // catalogue indices of the entries
+	Entries []StDirEntry
+}
+
+type fileKey struct {
+	user uint8
+	nom  [8]byte
+	ext  [3]byte
+}
+
+func entryKey(e StDirEntry) fileKey {
+	k := fileKey{user: e.User}
+	for i := range e.Nom {
+		k.nom[i] = e.Nom[i] & 127
+	}
+	for i := range e.Ext {
+		k.ext[i] = e.Ext[i] & 127
+	}
+	return k
+}
+
+// isFileEntry is true for directory entries of a user file,
+// deleted entries and CP/M 3 labels or timestamps are excluded
+func isFileEntry(e StDirEntry) bool {
+	return e.User < 0x20
+}
+
+// Filename returns the name of the file as NAME.EXT
+func (f CatalogueFile) Filename() string {
+	return fmt.Sprintf("%s.%s", ToAscii(f.Nom[:]), ToAscii(f.Ext[:]))
+}
+
+// Records returns the number of 128 bytes records of the file
+func (f CatalogueFile) Records(p DiskParams) int {
+	var t int
+	for _, e := range f.Entries {
+		t += p.EntryRecords(e)
+	}
+	return t
+}
+
+// Blocs returns the blocs holding the data of the file in file order
+func (f CatalogueFile) Blocs(p DiskParams) []uint16 {
+	blocs := make([]uint16, 0)
+	for _, e := range f.Entries {
+		blocs = append(blocs, p.EntryDataBlocs(e)...)
+	}
+	return blocs
+}
+
+// check verifies that every extent of the file is present once
+func (f CatalogueFile) check(p DiskParams) error {
+	owner := fmt.Sprintf("user %d", f.User)
+	if f.User == USER_DELETED {
+		owner = "deleted"
+	}
+	prev := -1
+	for _, e := range f.Entries {
+		n := p.EntryIndex(e)
+		if n == prev {
+			return fmt.Errorf("%w: %s %s extent %d", ErrorDuplicateExtent, f.Filename(), owner, n)
+		}
+		if n != prev+1 {
+			return fmt.Errorf("%w: %s %s extent %d", ErrorMissingExtent, f.Filename(), owner, prev+1)
+		}
+		prev = n
+	}
+	return nil
+}
+
+// collectFiles groups the catalogue entries by file in catalogue order
+func (d *DSK) collectFiles() []CatalogueFile {
+	return d.groupEntries(isFileEntry)
+}
+
+// groupEntries groups the catalogue entries accepted by filter by user,
+// name and extension, each group ordered by extent number
+func (d *DSK) groupEntries(filter func(StDirEntry) bool) []CatalogueFile {
+	p := d.DiskParams()
+	files := make([]CatalogueFile, 0)
+	index := make(map[fileKey]int)
+	for i, e := range d.Catalogue {
+		if !filter(e) {
+			continue
+		}
+		k := entryKey(e)
+		n, ok := index[k]
+		if !ok {
+			n = len(files)
+			index[k] = n
+			files = append(files, CatalogueFile{User: k.user, Nom: k.nom, Ext: k.ext})
+		}
+		files[n].Indices = append(files[n].Indices, i)
+		files[n].Entries = append(files[n].Entries, e)
+	}
+	for _, f := range files {
+		sort.Stable(byExtent{f, p})
+	}
+	return files
+}
+
+type byExtent struct {
+	f CatalogueFile
+	p DiskParams
+}
+
+func (b byExtent) Len() int { return len(b.f.Entries) }
+func (b byExtent) Less(i, j int) bool {
+	return b.p.EntryIndex(b.f.Entries[i]) < b.p.EntryIndex(b.f.Entries[j])
+}
+
+func (b byExtent) Swap(i, j int) {
+	b.f.Entries[i], b.f.Entries[j] = b.f.Entries[j], b.f.Entries[i]
+	b.f.Indices[i], b.f.Indices[j] = b.f.Indices[j], b.f.Indices[i]
+}
+
+// Files returns the files of the catalogue in order of their first entry.
+// Files with missing or duplicate extents are returned along with the error.
+func (d *DSK) Files() ([]CatalogueFile, error) {
+	if err := d.GetCatalogue(); err != nil {
+		return nil, err
+	}
+	p := d.DiskParams()
+	files := d.collectFiles()
+	errs := make([]error, 0)
+	for _, f := range files {
+		if err := f.check(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return files, errors.Join(errs...)
+}
+
+// LookupFile returns the file of the user with the name and extension,
+// attribute bits are ignored.
+func (d *DSK) LookupFile(user uint8, nom [8]byte, ext [3]byte) (CatalogueFile, error) {
+	if err := d.GetCatalogue(); err != nil {
+		return CatalogueFile{}, err
+	}
+	k := entryKey(StDirEntry{User: user, Nom: nom, Ext: ext})
+	for _, f := range d.collectFiles() {
+		if f.User == k.user && f.Nom == k.nom && f.Ext == k.ext {
+			return f, f.check(d.DiskParams())
+		}
+	}
+	return CatalogueFile{}, ErrorFileNotFound
+}
+
+// FileAt returns the file owning the catalogue entry at indice.
+func (d *DSK) FileAt(indice int) (CatalogueFile, error) {
+	if err := d.GetCatalogue(); err != nil {
+		return CatalogueFile{}, err
+	}
+	if indice < 0 || indice >= len(d.Catalogue) {
+		return CatalogueFile{}, ErrorCatalogueExceed
+	}
+	e := d.Catalogue[indice]
+	if !isFileEntry(e) {
+		return CatalogueFile{}, ErrorFileNotFound
+	}
+	return d.LookupFile(e.User, e.Nom, e.Ext)
+}
+
+// AddFile stores data under the AMSDOS name built from name and returns the
+// catalogue file created. Nothing is written if the file does not fit.
+func (d *DSK) AddFile(name string, data []byte, opts FileOptions) (CatalogueFile, error) {
+	cFileName := GetNomAmsdos(name)
+	entry := d.GetNomDir(cFileName, false)
+	if _, err := d.LookupFile(opts.User, entry.Nom, entry.Ext); !errors.Is(err, ErrorFileNotFound) {
+		return CatalogueFile{}, ErrorFileExists
+	}
+	content := data
+	if opts.Type == MODE_ASCII {
+		if len(content)%128 != 0 {
+			// marque de fin de fichier CP/M
+			content = append(content[:len(content):len(content)], 0x1A)
+		}
+	} else if isAmsdos, _ := amsdos.CheckAmsdos(data); !isAmsdos {
+		header, err := newAmsdosHeader(entry, len(data), opts)
+		if err != nil {
+			return CatalogueFile{}, err
+		}
+		content = append(header, data...)
+	}
+	err := d.CopyFile(content, cFileName, uint32(len(content)), d.DiskParams().DSM+1, uint16(opts.User), opts.System, opts.ReadOnly, false)
+	if err != nil {
+		return CatalogueFile{}, err
+	}
+	return d.LookupFile(opts.User, entry.Nom, entry.Ext)
+}
+
+// newAmsdosHeader returns the AMSDOS header of a file of length bytes
+func newAmsdosHeader(entry StDirEntry, length int, opts FileOptions) ([]byte, error) {
+	header := &amsdos.StAmsdos{}
+	header.User = opts.User
+	copy(header.Filename[0:8], entry.Nom[:])
+	copy(header.Filename[8:11], entry.Ext[:])
+	header.Type = opts.Type
+	header.Size = uint16(length)
+	header.LogicalSize = uint16(length)
+	header.Size2 = uint16(length)
+	header.BigLength = uint8(length >> 16)
+	header.Address = opts.Load
+	header.Exec = opts.Exec
+	header.Checksum = header.ComputedChecksum16()
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, header); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// amsdosLength returns the length of the file described by the AMSDOS header
+func amsdosLength(header *amsdos.StAmsdos) int {
+	length := int(header.Size2) | int(header.BigLength)<<16
+	if length == 0 {
+		length = int(header.LogicalSize)
+	}
+	return length
+}
+
+// fileContent returns the data of the file cut to the length of its AMSDOS
+// header, or to its number of records for files without header
+func (d *DSK) fileContent(f CatalogueFile) []byte {
+	p := d.DiskParams()
+	b := make([]byte, 0)
+	for _, bloc := range f.Blocs(p) {
+		b = append(b, d.ReadBloc(int(bloc))...)
+	}
+	size := f.Records(p) * 128
+	if isAmsdos, header := amsdos.CheckAmsdos(b); isAmsdos && amsdosLength(header)+HeaderSize <= size {
+		size = amsdosLength(header) + HeaderSize
+	}
+	return b[:min(size, len(b))]
+}
@@ -0,0 +1,192 @@
+package dsk
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/jeromelesaux/dsk/amsdos"
+	"github.com/stretchr/testify/assert"
+)
+
+func putTestFile(t *testing.T, d *DSK, filename string, size int, user uint16) []byte {
+	p := d.DiskParams()
+	name := GetNomAmsdos(filename)
+	data := addHeader(generateData(size), name)
+	assert.NoError(t, d.CopyFile(data, name, uint32(len(data)), p.DSM+1, user, false, false, false))
+	return data
+}
+
+func TestFilesExtentsOutOfOrder(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	data := putTestFile(t, d, "BIG.BIN", 40000, 0)
+	putTestFile(t, d, "SMALL.BIN", 1000, 0)
+
+	// move the extents of BIG.BIN around SMALL.BIN: 2, SMALL, 0, 1
+	e0, _ := d.GetInfoDirEntry(0)
+	e1, _ := d.GetInfoDirEntry(1)
+	e2, _ := d.GetInfoDirEntry(2)
+	small, _ := d.GetInfoDirEntry(3)
+	assert.NoError(t, d.SetInfoDirEntry(0, e2))
+	assert.NoError(t, d.SetInfoDirEntry(1, small))
+	assert.NoError(t, d.SetInfoDirEntry(2, e0))
+	assert.NoError(t, d.SetInfoDirEntry(3, e1))
+
+	files, err := d.Files()
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, "BIG.BIN", files[0].Filename())
+	assert.Equal(t, []int{2, 3, 0}, files[0].Indices)
+	assert.Equal(t, []int{2, 1}, d.GetFilesIndices())
+	assert.Equal(t, 40, d.GetFilesize(e1))
+
+	content, err := d.GetFileIn("BIG.BIN", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, data, content[:len(data)])
+}
+
+func TestFilesSameNameOtherUser(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	data0 := putTestFile(t, d, "FILE.BIN", 20000, 0)
+	data1 := putTestFile(t, d, "FILE.BIN", 3000, 1)
+
+	files, err := d.Files()
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, []int{0, 1}, files[0].Indices)
+	assert.Equal(t, []int{2}, files[1].Indices)
+
+	content, err := d.GetFileIn("FILE.BIN", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, data1, content[:len(data1)])
+
+	assert.NoError(t, d.RemoveFile(2))
+	f, err := d.LookupFile(0, files[0].Nom, files[0].Ext)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, f.Indices)
+	content, err = d.GetFileIn("FILE.BIN", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, data0, content[:len(data0)])
+	_, err = d.LookupFile(1, files[0].Nom, files[0].Ext)
+	assert.ErrorIs(t, err, ErrorFileNotFound)
+}
+
+func TestFilesMissingAndDuplicateExtent(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	putTestFile(t, d, "BIG.BIN", 40000, 0)
+
+	e1, _ := d.GetInfoDirEntry(1)
+	e1.User = USER_DELETED
+	assert.NoError(t, d.SetInfoDirEntry(1, e1))
+	_, err := d.GetFileIn("BIG.BIN", 0)
+	assert.ErrorIs(t, err, ErrorMissingExtent)
+	_, err = d.Files()
+	assert.ErrorIs(t, err, ErrorMissingExtent)
+
+	e1.User = 0
+	assert.NoError(t, d.SetInfoDirEntry(1, e1))
+	assert.NoError(t, d.SetInfoDirEntry(3, e1))
+	_, _, err = d.ViewFile(0)
+	assert.ErrorIs(t, err, ErrorDuplicateExtent)
+
+	assert.NoError(t, d.RemoveFile(0))
+	files, err := d.Files()
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestCopyFileBeyond64K(t *testing.T) {
+	d := FormatDsk(9, 80, 2, RomdosD1Format, 0)
+	data := putTestFile(t, d, "HUGE.BIN", 600000, 0)
+
+	files, err := d.Files()
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Len(t, files[0].Entries, 37)
+	last := files[0].Entries[36]
+	assert.Equal(t, uint8(1), last.Unused[1])
+	assert.Equal(t, 36, d.DiskParams().EntryIndex(last))
+	assert.Equal(t, (len(data)+127)>>7, files[0].Records(d.DiskParams()))
+
+	content, err := d.GetFileIn("HUGE.BIN", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, data, content[:len(data)])
+}
+
+func TestCopyFileNoSpaceLeavesDskUntouched(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	putTestFile(t, d, "FILE.BIN", 100000, 0)
+	var before bytes.Buffer
+	assert.NoError(t, d.Write(&before))
+
+	name := GetNomAmsdos("OTHER.BIN")
+	data := addHeader(generateData(100000), name)
+	err := d.CopyFile(data, name, uint32(len(data)), d.DiskParams().DSM+1, 0, false, false, false)
+	assert.ErrorIs(t, err, ErrorNoBloc)
+
+	var after bytes.Buffer
+	assert.NoError(t, d.Write(&after))
+	assert.Equal(t, before.Bytes(), after.Bytes())
+}
+
+func TestPutFileBeyond64K(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	data := generateData(100000)
+	f, err := os.CreateTemp("", "big-*.bin")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	assert.NoError(t, err)
+	f.Close()
+
+	assert.NoError(t, d.PutFile(f.Name(), MODE_BINAIRE, 0x4000, 0x4000, 0, false, false, false))
+	indice := d.FileExists(GetNomDir(f.Name()))
+	assert.NotEqual(t, NOT_FOUND, indice)
+	content, err := d.GetFileIn(f.Name(), indice)
+	assert.NoError(t, err)
+	assert.Equal(t, data, content[HeaderSize:HeaderSize+len(data)])
+}
+
+func TestAddFileBinary(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	data := generateData(70000)
+	f, err := d.AddFile("prog.bin", data, FileOptions{Type: MODE_BINAIRE, Load: 0x4000, Exec: 0x4010, User: 3, ReadOnly: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "PROG.BIN", f.Filename())
+	assert.Equal(t, uint8(3), f.User)
+	assert.Equal(t, (len(data)+HeaderSize+127)>>7, f.Records(d.DiskParams()))
+	assert.Equal(t, byte(0x80), f.Entries[0].Ext[0]&0x80)
+	assert.Equal(t, byte(0), f.Entries[0].Ext[1]&0x80)
+
+	content, err := d.GetFileIn("PROG.BIN", f.Indices[0])
+	assert.NoError(t, err)
+	assert.Len(t, content, len(data)+HeaderSize)
+	isAmsdos, header := amsdos.CheckAmsdos(content)
+	assert.True(t, isAmsdos)
+	assert.Equal(t, MODE_BINAIRE, header.Type)
+	assert.Equal(t, uint16(0x4000), header.Address)
+	assert.Equal(t, uint16(0x4010), header.Exec)
+	assert.Equal(t, uint8(3), header.User)
+	assert.Equal(t, "PROG    BIN", string(header.Filename[:11]))
+	assert.Equal(t, len(data), amsdosLength(header))
+	assert.Equal(t, data, content[HeaderSize:])
+
+	_, err = d.AddFile("PROG.BIN", data, FileOptions{Type: MODE_BINAIRE, User: 3})
+	assert.ErrorIs(t, err, ErrorFileExists)
+}
+
+func TestAddFileAscii(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	f, err := d.AddFile("readme.txt", []byte("hello"), FileOptions{Type: MODE_ASCII, System: true})
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x80), f.Entries[0].Ext[1]&0x80)
+	assert.Equal(t, 1, f.Records(d.DiskParams()))
+	content, err := d.GetFileIn("README.TXT", f.Indices[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello\x1A"), content[:6])
+
+	f, err = d.AddFile("empty.txt", nil, FileOptions{Type: MODE_ASCII})
+	assert.NoError(t, err)
+	assert.Len(t, f.Entries, 1)
+	assert.Equal(t, 0, f.Records(d.DiskParams()))
+}
@@ -0,0 +1,250 @@
+package dsk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeromelesaux/dsk/amsdos"
+)
+
+// DskFS exposes the catalogue of a dsk as a read only fs.FS,
+// each user area is a directory named after the user number ("0", "1", ...)
+// holding its files. File contents are read as stored on the dsk,
+// including the AMSDOS header when there is one.
+type DskFS struct {
+	d *DSK
+}
+
+var (
+	_ fs.ReadDirFS  = (*DskFS)(nil)
+	_ fs.StatFS     = (*DskFS)(nil)
+	_ fs.ReadFileFS = (*DskFS)(nil)
+)
+
+// FileAttributes is returned by Sys() for the files of a DskFS
+type FileAttributes struct {
+	Attributes
+	Header *amsdos.StAmsdos // nil if the file has no AMSDOS header
+	User   uint8
+	File   CatalogueFile
+}
+
+// FS returns the dsk catalogue as a fs.FS
+func (d *DSK) FS() *DskFS {
+	return &DskFS{d: d}
+}
+
+type fileInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+	sys  any
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() fs.FileMode  { return i.mode }
+func (i fileInfo) ModTime() time.Time { return time.Time{} }
+func (i fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i fileInfo) Sys() any           { return i.sys }
+
+// fsName returns the name of the file in the DskFS, NAME.EXT or NAME without extension
+func fsName(f CatalogueFile) string {
+	ext := ToAscii(f.Ext[:])
+	if ext == "" {
+		return ToAscii(f.Nom[:])
+	}
+	return ToAscii(f.Nom[:]) + "." + ext
+}
+
+func dirInfo(name string) fileInfo {
+	return fileInfo{name: name, mode: fs.ModeDir | 0o555}
+}
+
+// files returns the files of the catalogue grouped by user
+func (f *DskFS) files() map[uint8][]CatalogueFile {
+	if err := f.d.GetCatalogue(); err != nil {
+		return nil
+	}
+	users := make(map[uint8][]CatalogueFile)
+	for _, file := range f.d.collectFiles() {
+		users[file.User] = append(users[file.User], file)
+	}
+	return users
+}
+
+func (f *DskFS) fileInfo(file CatalogueFile) (fileInfo, []byte, error) {
+	if err := file.check(f.d.DiskParams()); err != nil {
+		return fileInfo{}, nil, err
+	}
+	content := f.d.fileContent(file)
+	attr := FileAttributes{
+		Attributes: entryAttributes(file.Entries[0]),
+		User:       file.User,
+		File:       file,
+	}
+	if isAmsdos, header := amsdos.CheckAmsdos(content); isAmsdos {
+		attr.Header = header
+	}
+	return fileInfo{name: fsName(file), size: int64(len(content)), mode: 0o444, sys: attr}, content, nil
+}
+
+// lookup resolves the name to a directory (user is -1 for the root) or a file
+func (f *DskFS) lookup(op, name string) (user int, file *CatalogueFile, err error) {
+	if !fs.ValidPath(name) {
+		return 0, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return -1, nil, nil
+	}
+	dir, base, hasFile := strings.Cut(name, "/")
+	u, err := strconv.Atoi(dir)
+	users := f.files()
+	if err != nil || u < 0 || u > 255 || len(users[uint8(u)]) == 0 || strings.Contains(base, "/") {
+		return 0, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if !hasFile {
+		return u, nil, nil
+	}
+	for _, file := range users[uint8(u)] {
+		if fsName(file) == base {
+			return u, &file, nil
+		}
+	}
+	return 0, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// Open opens the named file or user directory
+func (f *DskFS) Open(name string) (fs.File, error) {
+	user, file, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		entries, err := f.readDir(user)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		info := dirInfo(".")
+		if user >= 0 {
+			info = dirInfo(strconv.Itoa(user))
+		}
+		return &openDir{info: info, entries: entries}, nil
+	}
+	info, content, err := f.fileInfo(*file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &openFile{info: info, Reader: bytes.NewReader(content)}, nil
+}
+
+// ReadDir returns the user directories of the root or the files of a user directory
+func (f *DskFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	user, file, err := f.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if file != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries, err := f.readDir(user)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+func (f *DskFS) readDir(user int) ([]fs.DirEntry, error) {
+	users := f.files()
+	entries := make([]fs.DirEntry, 0)
+	if user < 0 {
+		for u := range users {
+			entries = append(entries, fs.FileInfoToDirEntry(dirInfo(strconv.Itoa(int(u)))))
+		}
+	} else {
+		for _, file := range users[uint8(user)] {
+			info, _, err := f.fileInfo(file)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, fs.FileInfoToDirEntry(info))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// Stat returns the fs.FileInfo of the named file or user directory
+func (f *DskFS) Stat(name string) (fs.FileInfo, error) {
+	user, file, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		if user < 0 {
+			return dirInfo("."), nil
+		}
+		return dirInfo(strconv.Itoa(user)), nil
+	}
+	info, _, err := f.fileInfo(*file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// ReadFile returns the content of the named file
+func (f *DskFS) ReadFile(name string) ([]byte, error) {
+	_, file, err := f.lookup("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errors.New("is a directory")}
+	}
+	_, content, err := f.fileInfo(*file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return content, nil
+}
+
+type openFile struct {
+	*bytes.Reader
+	info fileInfo
+}
+
+func (o *openFile) Stat() (fs.FileInfo, error) { return o.info, nil }
+func (o *openFile) Close() error               { return nil }
+
+type openDir struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (o *openDir) Stat() (fs.FileInfo, error) { return o.info, nil }
+func (o *openDir) Close() error               { return nil }
+
+func (o *openDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: o.info.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir follows the fs.ReadDirFile semantic
+func (o *openDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries := o.entries[o.offset:]
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		entries = entries[:min(n, len(entries))]
+	}
+	o.offset += len(entries)
+	return entries, nil
+}
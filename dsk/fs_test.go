@@ -0,0 +1,55 @@
+package dsk
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDskFS(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	data := generateData(20000)
+	_, err := d.AddFile("prog.bin", data, FileOptions{Type: MODE_BINAIRE, Load: 0x4000, Exec: 0x4000, ReadOnly: true})
+	assert.NoError(t, err)
+	_, err = d.AddFile("readme.txt", []byte("hello"), FileOptions{Type: MODE_ASCII, User: 1, System: true})
+	assert.NoError(t, err)
+	_, err = d.AddFile("noext", generateData(300), FileOptions{Type: MODE_ASCII, User: 1})
+	assert.NoError(t, err)
+
+	fsys := d.FS()
+	assert.NoError(t, fstest.TestFS(fsys, "0/PROG.BIN", "1/README.TXT", "1/NOEXT"))
+
+	content, err := fs.ReadFile(fsys, "0/PROG.BIN")
+	assert.NoError(t, err)
+	assert.Equal(t, data, content[HeaderSize:])
+
+	info, err := fs.Stat(fsys, "0/PROG.BIN")
+	assert.NoError(t, err)
+	attr, ok := info.Sys().(FileAttributes)
+	assert.True(t, ok)
+	assert.True(t, attr.ReadOnly)
+	assert.False(t, attr.System)
+	assert.NotNil(t, attr.Header)
+	assert.Equal(t, uint16(0x4000), attr.Header.Exec)
+
+	info, err = fs.Stat(fsys, "1/README.TXT")
+	assert.NoError(t, err)
+	attr = info.Sys().(FileAttributes)
+	assert.True(t, attr.System)
+	assert.Nil(t, attr.Header)
+	assert.Equal(t, uint8(1), attr.User)
+
+	var paths []string
+	assert.NoError(t, fs.WalkDir(fsys, ".", func(path string, _ fs.DirEntry, err error) error {
+		paths = append(paths, path)
+		return err
+	}))
+	assert.Equal(t, []string{".", "0", "0/PROG.BIN", "1", "1/NOEXT", "1/README.TXT"}, paths)
+
+	_, err = fsys.Open("2/PROG.BIN")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = fsys.Open("../PROG.BIN")
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+}
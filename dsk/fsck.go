@@ -0,0 +1,241 @@
+package dsk
+
+import (
+	"fmt"
+	"strings"
+)
+
+type FsckKind string
+
+var (
+	FsckCrossLinkedBloc FsckKind = "cross-linked bloc"
+	FsckBlocOutOfRange  FsckKind = "bloc out of range"
+	FsckRecordCount     FsckKind = "wrong record count"
+	FsckOrphanExtent    FsckKind = "orphan extent"
+	FsckDuplicateName   FsckKind = "duplicate name"
+	FsckInvalidName     FsckKind = "invalid filename"
+	FsckDirectoryBitmap FsckKind = "directory bloc not reserved"
+)
+
+// FsckIssue is a consistency problem found by Fsck
+type FsckIssue struct {
+	Kind    FsckKind
+	Indices []int    // catalogue entries concerned
+	Blocs   []uint16 // blocs concerned
+	Message string
+	Fixed   bool
+}
+
+func (i FsckIssue) String() string {
+	s := fmt.Sprintf("%s: %s", i.Kind, i.Message)
+	if i.Fixed {
+		s += " (fixed)"
+	}
+	return s
+}
+
+// FsckReport lists the issues found by Fsck or Repair
+type FsckReport struct {
+	Issues []FsckIssue
+}
+
+// OK is true when no issue has been found
+func (r FsckReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// Count returns the number of issues of the kind
+func (r FsckReport) Count(kind FsckKind) int {
+	var n int
+	for _, i := range r.Issues {
+		if i.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *FsckReport) add(kind FsckKind, indices []int, blocs []uint16, fixed bool, format string, args ...any) {
+	r.Issues = append(r.Issues, FsckIssue{
+		Kind:    kind,
+		Indices: indices,
+		Blocs:   blocs,
+		Message: fmt.Sprintf(format, args...),
+		Fixed:   fixed,
+	})
+}
+
+// Fsck checks the consistency of the filesystem of the dsk, the dsk is left untouched.
+func (d *DSK) Fsck() FsckReport {
+	return d.Clone().fsck(false)
+}
+
+// Repair returns a copy of the dsk where the issues which can be fixed
+// without losing data are fixed, with the report of all the issues found.
+// Duplicate identical entries are removed, record counts are truncated to
+// the allocated blocs and invalid characters of the names are replaced by '_'.
+// Cross-linked blocs, blocs out of range, orphan extents and directory
+// blocs missing from the disk parameters are only reported.
+func (d *DSK) Repair() (*DSK, FsckReport) {
+	c := d.Clone()
+	r := c.fsck(true)
+	return c, r
+}
+
+// invalidNameChar is true for characters CP/M does not accept in a filename
+func invalidNameChar(c byte) bool {
+	c &= 127
+	return c < 0x20 || c == 0x7F || strings.IndexByte("<>.,;:=?*[]", c) >= 0
+}
+
+func (d *DSK) fsck(repair bool) FsckReport {
+	var r FsckReport
+	p := d.DiskParams()
+	d.catalogueLoaded = false
+	if err := d.GetCatalogue(); err != nil {
+		return r
+	}
+	dirBlocs := uint16(p.AL0)<<8 | uint16(p.AL1)
+	isDirBloc := func(b uint16) bool {
+		return b < 16 && dirBlocs&(0x8000>>b) != 0
+	}
+
+	// blocs and record counts of each entry
+	owners := make(map[uint16][]int)
+	for i, e := range d.Catalogue {
+		if !isFileEntry(e) {
+			continue
+		}
+		name := fmt.Sprintf("%s.%s", ToAscii(e.Nom[:]), ToAscii(e.Ext[:]))
+		blocs := p.EntryBlocs(e)
+		for _, b := range blocs {
+			switch {
+			case b > p.DSM:
+				r.add(FsckBlocOutOfRange, []int{i}, []uint16{b}, false, "entry %d (%s) bloc %d past the end of the disk (%d)", i, name, b, p.DSM)
+			case isDirBloc(b):
+				r.add(FsckBlocOutOfRange, []int{i}, []uint16{b}, false, "entry %d (%s) bloc %d in the directory", i, name, b)
+			default:
+				owners[b] = append(owners[b], i)
+			}
+		}
+		needed := (p.EntryRecords(e) + p.RecordsPerBloc() - 1) / p.RecordsPerBloc()
+		if e.NbPages > 0x80 || needed > len(blocs) {
+			records := min(p.EntryRecords(e), len(blocs)*p.RecordsPerBloc())
+			if e.NbPages > 0x80 {
+				records = min(records, int(e.NumPage&p.EXM)*128+0x80)
+			}
+			r.add(FsckRecordCount, []int{i}, blocs, repair, "entry %d (%s) has %d records (RC #%.2X) for %d blocs", i, name, p.EntryRecords(e), e.NbPages, len(blocs))
+			if repair {
+				p.setEntryRecords(&e, records)
+				d.setCatalogueEntry(i, e)
+			}
+		}
+	}
+
+	// cross-linked blocs, grouped by owners
+	crossLinks := make(map[string]int)
+	for b := uint16(0); b <= p.DSM; b++ {
+		if len(owners[b]) < 2 {
+			continue
+		}
+		key := fmt.Sprint(owners[b])
+		if n, ok := crossLinks[key]; ok {
+			r.Issues[n].Blocs = append(r.Issues[n].Blocs, b)
+			continue
+		}
+		crossLinks[key] = len(r.Issues)
+		r.add(FsckCrossLinkedBloc, owners[b], []uint16{b}, false, "entries %v share blocs", owners[b])
+	}
+
+	// extents of each file
+	files := d.collectFiles()
+	names := make(map[fileKey]bool)
+	for _, f := range files {
+		names[fileKey{user: f.User, nom: f.Nom, ext: f.Ext}] = true
+	}
+	for _, f := range files {
+		prev := -1
+		for j, e := range f.Entries {
+			n := p.EntryIndex(e)
+			switch {
+			case n == prev:
+				dup := f.Entries[j-1] == e
+				if repair && dup {
+					e.User = USER_DELETED
+					d.setCatalogueEntry(f.Indices[j], e)
+				}
+				r.add(FsckDuplicateName, []int{f.Indices[j-1], f.Indices[j]}, nil, repair && dup, "%s user %d extent %d in entries %d and %d", f.Filename(), f.User, n, f.Indices[j-1], f.Indices[j])
+			case n != prev+1:
+				r.add(FsckOrphanExtent, f.Indices[j:], nil, false, "%s user %d extents %d to %d missing", f.Filename(), f.User, prev+1, n-1)
+			}
+			if j < len(f.Entries)-1 && n != p.EntryIndex(f.Entries[j+1]) && p.EntryRecords(e) != p.RecordsPerEntry() {
+				r.add(FsckRecordCount, []int{f.Indices[j]}, nil, false, "%s user %d extent %d is not full (%d records) but is not the last one", f.Filename(), f.User, n, p.EntryRecords(e))
+			}
+			prev = n
+		}
+
+		var invalid bool
+		k := fileKey{user: f.User, nom: f.Nom, ext: f.Ext}
+		for q := range k.nom {
+			if invalidNameChar(k.nom[q]) {
+				k.nom[q], invalid = '_', true
+			}
+		}
+		for q := range k.ext {
+			if invalidNameChar(k.ext[q]) {
+				k.ext[q], invalid = '_', true
+			}
+		}
+		if invalid {
+			fixed := repair && !names[k]
+			if fixed {
+				names[k] = true
+				for _, i := range f.Indices {
+					e := d.Catalogue[i]
+					for q := range e.Nom {
+						e.Nom[q] = e.Nom[q]&0x80 | k.nom[q]
+					}
+					for q := range e.Ext {
+						e.Ext[q] = e.Ext[q]&0x80 | k.ext[q]
+					}
+					d.setCatalogueEntry(i, e)
+				}
+			}
+			r.add(FsckInvalidName, f.Indices, nil, fixed, "user %d name %q", f.User, string(f.Nom[:])+"."+string(f.Ext[:]))
+		}
+	}
+
+	// blocs holding the directory entries must be reserved
+	nbDirBlocs := (p.DirEntries()*32 + p.BlocSize() - 1) / p.BlocSize()
+	missing := make([]uint16, 0)
+	for b := uint16(0); int(b) < nbDirBlocs && b < 16; b++ {
+		if !isDirBloc(b) {
+			missing = append(missing, b)
+		}
+	}
+	if len(missing) > 0 {
+		// the parameters are not stored in the dsk, only a new format fixes them
+		r.add(FsckDirectoryBitmap, nil, missing, false, "%d directory entries need %d blocs, blocs %v are not reserved", p.DirEntries(), nbDirBlocs, missing)
+	}
+	if repair {
+		d.FillBitmap()
+	}
+	return r
+}
+
+// setEntryRecords sets the extent and record count bytes of the entry for
+// records in its last logical extent
+func (p DiskParams) setEntryRecords(e *StDirEntry, records int) {
+	var last int
+	if records > 0 {
+		last = (records - 1) >> 7
+	}
+	e.NumPage = e.NumPage&^p.EXM | uint8(last)
+	e.NbPages = uint8(records - last<<7)
+}
+
+func (d *DSK) setCatalogueEntry(i int, e StDirEntry) {
+	if err := d.SetInfoDirEntry(i, e); err == nil {
+		d.Catalogue[i] = e
+	}
+}
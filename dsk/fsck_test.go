@@ -0,0 +1,96 @@
+package dsk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFsckCleanDsk(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	putTestFile(t, d, "BIG.BIN", 40000, 0)
+	putTestFile(t, d, "SMALL.BIN", 1000, 1)
+	r := d.Fsck()
+	assert.True(t, r.OK(), r.Issues)
+}
+
+func TestFsckIssues(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	putTestFile(t, d, "BIG.BIN", 40000, 0) // entries 0, 1, 2
+	putTestFile(t, d, "SMALL.BIN", 1000, 0)
+	putTestFile(t, d, "BAD.BIN", 1000, 0) // entry 4
+
+	// cross-linked bloc and bloc past the end of the disk
+	small, _ := d.GetInfoDirEntry(3)
+	small.Blocks[0] = 2
+	small.Blocks[1] = 250
+	assert.NoError(t, d.SetInfoDirEntry(3, small))
+	// orphan extent
+	e1, _ := d.GetInfoDirEntry(1)
+	e1.User = USER_DELETED
+	assert.NoError(t, d.SetInfoDirEntry(1, e1))
+	// duplicate extent
+	e2, _ := d.GetInfoDirEntry(2)
+	assert.NoError(t, d.SetInfoDirEntry(10, e2))
+	// invalid name and wrong record count
+	bad, _ := d.GetInfoDirEntry(4)
+	bad.Nom[1] = '*'
+	bad.NbPages = 0x90
+	assert.NoError(t, d.SetInfoDirEntry(4, bad))
+
+	r := d.Fsck()
+	assert.False(t, r.OK())
+	// the duplicate entries share their blocs as well
+	assert.Equal(t, 2, r.Count(FsckCrossLinkedBloc))
+	assert.Equal(t, 1, r.Count(FsckBlocOutOfRange))
+	assert.Equal(t, 1, r.Count(FsckRecordCount))
+	assert.Equal(t, 1, r.Count(FsckOrphanExtent))
+	assert.Equal(t, 1, r.Count(FsckDuplicateName))
+	assert.Equal(t, 1, r.Count(FsckInvalidName))
+	for _, i := range r.Issues {
+		assert.False(t, i.Fixed)
+		if i.Kind == FsckCrossLinkedBloc && i.Indices[0] == 0 {
+			assert.Equal(t, []int{0, 3}, i.Indices)
+			assert.Equal(t, []uint16{2}, i.Blocs)
+		}
+	}
+	// the dsk is left untouched
+	e, _ := d.GetInfoDirEntry(4)
+	assert.Equal(t, bad, e)
+
+	repaired, r := d.Repair()
+	for _, i := range r.Issues {
+		switch i.Kind {
+		case FsckRecordCount, FsckDuplicateName, FsckInvalidName:
+			assert.True(t, i.Fixed, i.String())
+		default:
+			assert.False(t, i.Fixed, i.String())
+		}
+	}
+	e, _ = repaired.GetInfoDirEntry(4)
+	assert.Equal(t, "B_D     ", string(e.Nom[:]))
+	assert.Equal(t, uint8(16), e.NbPages)
+	e, _ = repaired.GetInfoDirEntry(10)
+	assert.Equal(t, USER_DELETED, e.User)
+
+	r = repaired.Fsck()
+	assert.Equal(t, 0, r.Count(FsckRecordCount))
+	assert.Equal(t, 0, r.Count(FsckDuplicateName))
+	assert.Equal(t, 0, r.Count(FsckInvalidName))
+}
+
+func TestFsckDirectoryBitmap(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	p := DataFormatParams
+	p.DRM = 127
+	d.SetDiskParams(p)
+	r := d.Fsck()
+	assert.Equal(t, 1, r.Count(FsckDirectoryBitmap))
+	assert.Equal(t, []uint16{2, 3}, r.Issues[0].Blocs)
+
+	// the disk parameters are not written in the dsk
+	repaired, r := d.Repair()
+	assert.False(t, r.Issues[0].Fixed)
+	assert.Equal(t, DataFormatParams.AL0, repaired.DiskParams().AL0)
+	assert.Equal(t, 1, repaired.Fsck().Count(FsckDirectoryBitmap))
+}
@@ -0,0 +1,183 @@
+package dsk
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrorUnknownMergePolicy = errors.New("unknown merge policy")
+	ErrorMergeConflict      = errors.New("cannot resolve the name conflict")
+)
+
+// MergePolicy tells Merge what to do with a file whose name is already used
+// in the same user area of the output dsk
+type MergePolicy string
+
+var (
+	MergeSkip      MergePolicy = "skip"       // the file already there is kept
+	MergeOverwrite MergePolicy = "overwrite"  // the file is replaced
+	MergeRename    MergePolicy = "rename"     // the file is renamed NAME1, NAME2, ...
+	MergeUserShift MergePolicy = "user-shift" // the file goes to the next user area where the name is free
+)
+
+// MergedFile describes where a file of a source dsk went in the merged dsk
+type MergedFile struct {
+	Source  int           // index of the source dsk
+	File    CatalogueFile // file in the source dsk
+	User    uint8         // user area in the merged dsk
+	Nom     [8]byte       // name in the merged dsk
+	Ext     [3]byte
+	Skipped bool // not written because of the conflict policy
+}
+
+// Filename returns the name of the file in the merged dsk as NAME.EXT
+func (m MergedFile) Filename() string {
+	return CatalogueFile{Nom: m.Nom, Ext: m.Ext}.Filename()
+}
+
+func (m MergedFile) key() fileKey {
+	return fileKey{user: m.User, nom: m.Nom, ext: m.Ext}
+}
+
+// Merge copies the files of the sources into the dsk, name conflicts with the
+// files already there or with the files of a previous source are solved
+// following the policy. The attributes of the files are kept.
+// Nothing is written if the files do not fit.
+func (d *DSK) Merge(policy MergePolicy, sources ...*DSK) ([]MergedFile, error) {
+	switch policy {
+	case MergeSkip, MergeOverwrite, MergeRename, MergeUserShift:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrorUnknownMergePolicy, policy)
+	}
+	c := d.Clone()
+	existing, err := c.Files()
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[fileKey]int) // planned file index, -1 for a file of the dsk
+	for _, f := range existing {
+		taken[fileKey{user: f.User, nom: f.Nom, ext: f.Ext}] = -1
+	}
+
+	plan := make([]MergedFile, 0)
+	for i, src := range sources {
+		files, err := src.Files()
+		if err != nil {
+			return nil, fmt.Errorf("source %d: %w", i, err)
+		}
+		for _, f := range files {
+			m := MergedFile{Source: i, File: f, User: f.User, Nom: f.Nom, Ext: f.Ext}
+			if n, ok := taken[m.key()]; ok {
+				switch policy {
+				case MergeSkip:
+					m.Skipped = true
+				case MergeOverwrite:
+					if n >= 0 {
+						plan[n].Skipped = true
+					} else if err := c.removeFile(m.User, m.Nom, m.Ext); err != nil {
+						return nil, err
+					}
+				case MergeRename:
+					if m.Nom, err = freeName(taken, m.User, m.Nom, m.Ext); err != nil {
+						return nil, err
+					}
+				case MergeUserShift:
+					if m.User, err = freeUser(taken, m.User, m.Nom, m.Ext); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if !m.Skipped {
+				taken[m.key()] = len(plan)
+			}
+			plan = append(plan, m)
+		}
+	}
+
+	// every file must fit before anything is written
+	p := c.DiskParams()
+	var neededBlocs, neededEntries int
+	for _, m := range plan {
+		if !m.Skipped {
+			blocs, entries := p.fileNeeds(m.File.Records(sources[m.Source].DiskParams()) * 128)
+			neededBlocs += blocs
+			neededEntries += entries
+		}
+	}
+	c.FillBitmap()
+	freeBlocs, freeEntries := c.freeSpace(int(p.DSM) + 1)
+	if neededBlocs > freeBlocs {
+		return nil, fmt.Errorf("%w: %d blocs needed, %d free", ErrorNoBloc, neededBlocs, freeBlocs)
+	}
+	if neededEntries > freeEntries {
+		return nil, fmt.Errorf("%w: %d entries needed, %d free", ErrorNoDirEntry, neededEntries, freeEntries)
+	}
+
+	for _, m := range plan {
+		if m.Skipped {
+			continue
+		}
+		src := sources[m.Source]
+		sp := src.DiskParams()
+		content := make([]byte, 0)
+		for _, b := range m.File.Blocs(sp) {
+			content = append(content, src.ReadBloc(int(b))...)
+		}
+		content = content[:min(len(content), m.File.Records(sp)*128)]
+		name := string(m.Nom[:]) + "." + string(m.Ext[:])
+		if err := c.CopyFile(content, name, uint32(len(content)), p.DSM+1, uint16(m.User), false, false, false); err != nil {
+			return nil, err
+		}
+		f, err := c.LookupFile(m.User, m.Nom, m.Ext)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.updateEntries(f, entryAttributes(m.File.Entries[0]).set); err != nil {
+			return nil, err
+		}
+	}
+	*d = *c
+	return plan, nil
+}
+
+// removeFile removes the file of the user with the name and extension
+func (d *DSK) removeFile(user uint8, nom [8]byte, ext [3]byte) error {
+	f, err := d.LookupFile(user, nom, ext)
+	if err != nil && !errors.Is(err, ErrorMissingExtent) && !errors.Is(err, ErrorDuplicateExtent) {
+		return err
+	}
+	return d.RemoveFile(f.Indices[0])
+}
+
+// freeName returns the name followed by the smallest number which is not
+// used in the user area, the name is cut to keep 8 characters
+func freeName(taken map[fileKey]int, user uint8, nom [8]byte, ext [3]byte) ([8]byte, error) {
+	base := strings.TrimRight(string(nom[:]), " ")
+	for n := 1; n < 1000; n++ {
+		suffix := strconv.Itoa(n)
+		name := base
+		if len(name)+len(suffix) > 8 {
+			name = name[:8-len(suffix)]
+		}
+		var candidate [8]byte
+		copy(candidate[:], fmt.Sprintf("%-8s", name+suffix))
+		if _, ok := taken[fileKey{user: user, nom: candidate, ext: ext}]; !ok {
+			return candidate, nil
+		}
+	}
+	return nom, fmt.Errorf("%w: %s", ErrorMergeConflict, CatalogueFile{Nom: nom, Ext: ext}.Filename())
+}
+
+// freeUser returns the next user area (0 to 15) where the name is free
+func freeUser(taken map[fileKey]int, user uint8, nom [8]byte, ext [3]byte) (uint8, error) {
+	for n := uint8(1); n < 16; n++ {
+		u := (user + n) % 16
+		if _, ok := taken[fileKey{user: u, nom: nom, ext: ext}]; !ok {
+			return u, nil
+		}
+	}
+	return user, fmt.Errorf("%w: %s is used in every user area", ErrorMergeConflict, CatalogueFile{Nom: nom, Ext: ext}.Filename())
+}
@@ -0,0 +1,76 @@
+package dsk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mergeSources(t *testing.T) (*DSK, *DSK, map[string][]byte) {
+	contents := make(map[string][]byte)
+	a := FormatDsk(9, 40, 1, DataFormat, 0)
+	contents["A/PROG.BIN"] = putTestFile(t, a, "PROG.BIN", 20000, 0)
+	contents["A/ONLYA.BIN"] = putTestFile(t, a, "ONLYA.BIN", 1000, 0)
+	_, err := a.SetAttributes("onlya.bin", 0, Attributes{ReadOnly: true, Archived: true})
+	assert.NoError(t, err)
+	b := FormatDsk(9, 40, 1, DataFormat, 0)
+	contents["B/PROG.BIN"] = putTestFile(t, b, "PROG.BIN", 3000, 0)
+	return a, b, contents
+}
+
+func TestMergePolicies(t *testing.T) {
+	a, b, contents := mergeSources(t)
+	cases := []struct {
+		policy MergePolicy
+		files  map[string]string // user/name in the merged dsk to content
+	}{
+		{MergeSkip, map[string]string{"0/PROG.BIN": "A/PROG.BIN", "0/ONLYA.BIN": "A/ONLYA.BIN"}},
+		{MergeOverwrite, map[string]string{"0/PROG.BIN": "B/PROG.BIN", "0/ONLYA.BIN": "A/ONLYA.BIN"}},
+		{MergeRename, map[string]string{"0/PROG.BIN": "A/PROG.BIN", "0/ONLYA.BIN": "A/ONLYA.BIN", "0/PROG1.BIN": "B/PROG.BIN"}},
+		{MergeUserShift, map[string]string{"0/PROG.BIN": "A/PROG.BIN", "0/ONLYA.BIN": "A/ONLYA.BIN", "1/PROG.BIN": "B/PROG.BIN"}},
+	}
+	for _, c := range cases {
+		d := FormatDsk(9, 40, 1, DataFormat, 0)
+		plan, err := d.Merge(c.policy, a, b)
+		assert.NoError(t, err, c.policy)
+		assert.Len(t, plan, 3)
+		files, err := d.Files()
+		assert.NoError(t, err)
+		assert.Len(t, files, len(c.files), c.policy)
+		for _, f := range files {
+			key := string(rune('0'+f.User)) + "/" + f.Filename()
+			assert.Equal(t, contents[c.files[key]], d.fileContent(f), c.policy, key)
+		}
+		assert.True(t, d.Fsck().OK(), c.policy)
+		f, err := d.lookupName("onlya.bin", 0)
+		assert.NoError(t, err)
+		assert.Equal(t, Attributes{ReadOnly: true, Archived: true}, entryAttributes(f.Entries[0]))
+	}
+}
+
+func TestMergeExistingFiles(t *testing.T) {
+	a, _, contents := mergeSources(t)
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	putTestFile(t, d, "PROG.BIN", 500, 0)
+	plan, err := d.Merge(MergeOverwrite, a)
+	assert.NoError(t, err)
+	assert.False(t, plan[0].Skipped)
+	f, err := d.lookupName("prog.bin", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, contents["A/PROG.BIN"], d.fileContent(f))
+	assert.True(t, d.Fsck().OK())
+}
+
+func TestMergeNoSpace(t *testing.T) {
+	a, b, _ := mergeSources(t)
+	big := FormatDsk(9, 40, 1, DataFormat, 0)
+	putTestFile(t, big, "BIG.BIN", 160000, 0)
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	before := d.Clone()
+	_, err := d.Merge(MergeRename, a, b, big)
+	assert.ErrorIs(t, err, ErrorNoBloc)
+	assert.Equal(t, before.Tracks, d.Tracks)
+
+	_, err = d.Merge("unknown", a)
+	assert.ErrorIs(t, err, ErrorUnknownMergePolicy)
+}
@@ -0,0 +1,94 @@
+package dsk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// DataRate is the data rate of an extended dsk revision 5 track
+type DataRate uint8
+
+var (
+	DataRateUnknown DataRate = 0
+	DataRateDD      DataRate = 1 // single or double density, 250 or 300 kbps
+	DataRateHD      DataRate = 2 // high density, 500 kbps
+	DataRateED      DataRate = 3 // extended density, 1 Mbps
+)
+
+// RecordingMode is the encoding of an extended dsk revision 5 track
+type RecordingMode uint8
+
+var (
+	RecordingUnknown RecordingMode = 0
+	RecordingFM      RecordingMode = 1
+	RecordingMFM     RecordingMode = 2
+)
+
+const offsetInfoID = "Offset-Info\r\n"
+
+var ErrorOffsetInfo = errors.New("Offset-Info block does not match the tracks")
+
+// TrackOffsets gives the position of the sectors of a track from the index
+// hole, as stored in the Offset-Info block. The length of the track and the
+// offsets of the sector ID address marks are counted in bytes of the track.
+type TrackOffsets struct {
+	Length  uint16
+	Sectors []uint16 // one offset per sector of the track
+}
+
+// SectorOffsets returns the Offset-Info of the track, false if the dsk has none
+func (d *DSK) SectorOffsets(track int) (TrackOffsets, bool) {
+	if track < 0 || track >= len(d.OffsetInfo) || len(d.OffsetInfo[track].Sectors) != int(d.Tracks[track].NbSect) {
+		return TrackOffsets{}, false
+	}
+	return d.OffsetInfo[track], true
+}
+
+// readOffsetInfo reads the Offset-Info block following the last track,
+// every track has its length then the offset of each of its sectors
+func (d *DSK) readOffsetInfo(r io.Reader) error {
+	trailer, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	start := bytes.Index(trailer, []byte(offsetInfoID))
+	if start < 0 {
+		return nil
+	}
+	b := bytes.NewReader(trailer[start+len(offsetInfoID):])
+	info := make([]TrackOffsets, len(d.Tracks))
+	for i, t := range d.Tracks {
+		info[i].Sectors = make([]uint16, t.NbSect)
+		if err := binary.Read(b, binary.LittleEndian, &info[i].Length); err != nil {
+			return ErrorOffsetInfo
+		}
+		if err := binary.Read(b, binary.LittleEndian, info[i].Sectors); err != nil {
+			return ErrorOffsetInfo
+		}
+	}
+	d.OffsetInfo = info
+	return nil
+}
+
+func (d *DSK) writeOffsetInfo(w io.Writer) error {
+	if len(d.OffsetInfo) != len(d.Tracks) {
+		return ErrorOffsetInfo
+	}
+	if _, err := io.WriteString(w, offsetInfoID); err != nil {
+		return err
+	}
+	for i, o := range d.OffsetInfo {
+		if len(o.Sectors) != int(d.Tracks[i].NbSect) {
+			return ErrorOffsetInfo
+		}
+		if err := binary.Write(w, binary.LittleEndian, o.Length); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, o.Sectors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
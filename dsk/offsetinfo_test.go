@@ -0,0 +1,55 @@
+package dsk
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOffsetInfoRoundTrip(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	d.OffsetInfo = make([]TrackOffsets, len(d.Tracks))
+	for i := range d.Tracks {
+		d.Tracks[i].DataRate = DataRateDD
+		d.Tracks[i].RecordingMode = RecordingMFM
+		d.OffsetInfo[i].Length = 6250
+		for s := 0; s < int(d.Tracks[i].NbSect); s++ {
+			d.OffsetInfo[i].Sectors = append(d.OffsetInfo[i].Sectors, uint16(200+s*650+i))
+		}
+	}
+	d.Tracks[3].DataRate = DataRateHD
+
+	var b bytes.Buffer
+	assert.NoError(t, d.Write(&b))
+	r := &DSK{}
+	assert.NoError(t, r.Read(&b))
+	assert.Equal(t, d.OffsetInfo, r.OffsetInfo)
+	assert.Equal(t, DataRateHD, r.Tracks[3].DataRate)
+	assert.Equal(t, DataRateDD, r.Tracks[4].DataRate)
+	assert.Equal(t, RecordingMFM, r.Tracks[3].RecordingMode)
+
+	o, ok := r.SectorOffsets(5)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(200+2*650+5), o.Sectors[2])
+	_, ok = r.SectorOffsets(40)
+	assert.False(t, ok)
+}
+
+func TestOffsetInfoAbsent(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	var b bytes.Buffer
+	assert.NoError(t, d.Write(&b))
+	r := &DSK{}
+	assert.NoError(t, r.Read(&b))
+	assert.Nil(t, r.OffsetInfo)
+	_, ok := r.SectorOffsets(0)
+	assert.False(t, ok)
+}
+
+func TestOffsetInfoMismatch(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	d.OffsetInfo = make([]TrackOffsets, len(d.Tracks))
+	var b bytes.Buffer
+	assert.ErrorIs(t, d.Write(&b), ErrorOffsetInfo)
+}
@@ -0,0 +1,361 @@
+package dsk
+
+import (
+	"errors"
+	"math/bits"
+)
+
+var (
+	ErrorUnknownDiskParams = errors.New("unknown disk parameters")
+	ErrorBlocOutOfRange    = errors.New("bloc out of disk range")
+)
+
+// DiskParams describes a CP/M disk parameter block (DPB) and the physical
+// sector numbering used by the filesystem layer of a DSK image.
+type DiskParams struct {
+	Name        string
+	SPT         uint16 // 128 bytes records per track
+	BSH         uint8  // block shift factor, bloc size = 128 << BSH
+	BLM         uint8  // block mask, (bloc size / 128) - 1
+	EXM         uint8  // extent mask
+	DSM         uint16 // highest bloc number
+	DRM         uint16 // highest directory entry number
+	AL0         uint8  // directory blocs bitmap (high byte)
+	AL1         uint8  // directory blocs bitmap (low byte)
+	OFF         uint16 // number of reserved tracks
+	PSH         uint8  // physical sector shift, sector size = 128 << PSH
+	FirstSector uint8  // sector id of the first sector of a track
+	Tracks      uint8  // number of tracks per side when formatting
+	Heads       uint8  // number of sides
+}
+
+var (
+	// DataFormatParams is the AMSDOS data format, 9 sectors #C1-#C9, no reserved track
+	DataFormatParams = DiskParams{
+		Name:        "data",
+		SPT:         36,
+		BSH:         3,
+		BLM:         7,
+		EXM:         0,
+		DSM:         179,
+		DRM:         63,
+		AL0:         0xC0,
+		AL1:         0x00,
+		OFF:         0,
+		PSH:         2,
+		FirstSector: 0xC1,
+		Tracks:      40,
+		Heads:       1,
+	}
+	// VendorFormatParams is the AMSDOS system (vendor) format, 9 sectors #41-#49, 2 reserved tracks
+	VendorFormatParams = DiskParams{
+		Name:        "vendor",
+		SPT:         36,
+		BSH:         3,
+		BLM:         7,
+		EXM:         0,
+		DSM:         170,
+		DRM:         63,
+		AL0:         0xC0,
+		AL1:         0x00,
+		OFF:         2,
+		PSH:         2,
+		FirstSector: 0x41,
+		Tracks:      40,
+		Heads:       1,
+	}
+	// IBMFormatParams is the CP/M IBM format, 8 sectors #01-#08, 1 reserved track
+	IBMFormatParams = DiskParams{
+		Name:        "ibm",
+		SPT:         32,
+		BSH:         3,
+		BLM:         7,
+		EXM:         0,
+		DSM:         155,
+		DRM:         63,
+		AL0:         0xC0,
+		AL1:         0x00,
+		OFF:         1,
+		PSH:         2,
+		FirstSector: 0x01,
+		Tracks:      40,
+		Heads:       1,
+	}
+	// ParadosFormatParams is the Parados 80 tracks format, 10 sectors #91-#9A, 2K blocs
+	ParadosFormatParams = DiskParams{
+		Name:        "parados",
+		SPT:         40,
+		BSH:         4,
+		BLM:         15,
+		EXM:         1,
+		DSM:         199,
+		DRM:         127,
+		AL0:         0xC0,
+		AL1:         0x00,
+		OFF:         0,
+		PSH:         2,
+		FirstSector: 0x91,
+		Tracks:      80,
+		Heads:       1,
+	}
+	// RomdosD1FormatParams is the ROMDOS D1 format, double sided 80 tracks, 9 sectors #01-#09, 2K blocs
+	RomdosD1FormatParams = DiskParams{
+		Name:        "romdos-d1",
+		SPT:         36,
+		BSH:         4,
+		BLM:         15,
+		EXM:         0,
+		DSM:         359,
+		DRM:         127,
+		AL0:         0xC0,
+		AL1:         0x00,
+		OFF:         0,
+		PSH:         2,
+		FirstSector: 0x01,
+		Tracks:      80,
+		Heads:       2,
+	}
+	// RomdosD2FormatParams is the ROMDOS D2 format, double sided 80 tracks, 9 sectors #21-#29, 4K blocs
+	RomdosD2FormatParams = DiskParams{
+		Name:        "romdos-d2",
+		SPT:         36,
+		BSH:         5,
+		BLM:         31,
+		EXM:         3,
+		DSM:         179,
+		DRM:         255,
+		AL0:         0xC0,
+		AL1:         0x00,
+		OFF:         0,
+		PSH:         2,
+		FirstSector: 0x21,
+		Tracks:      80,
+		Heads:       2,
+	}
+	// RomdosD10FormatParams is the ROMDOS D10 format, double sided 80 tracks, 10 sectors #11-#1A, 2K blocs
+	RomdosD10FormatParams = DiskParams{
+		Name:        "romdos-d10",
+		SPT:         40,
+		BSH:         4,
+		BLM:         15,
+		EXM:         0,
+		DSM:         399,
+		DRM:         127,
+		AL0:         0xC0,
+		AL1:         0x00,
+		OFF:         0,
+		PSH:         2,
+		FirstSector: 0x11,
+		Tracks:      80,
+		Heads:       2,
+	}
+	// MS800FormatParams is the MS800 format, double sided 80 tracks, 10 sectors #01-#0A, 2K blocs
+	MS800FormatParams = DiskParams{
+		Name:        "ms800",
+		SPT:         40,
+		BSH:         4,
+		BLM:         15,
+		EXM:         0,
+		DSM:         399,
+		DRM:         127,
+		AL0:         0xC0,
+		AL1:         0x00,
+		OFF:         0,
+		PSH:         2,
+		FirstSector: 0x01,
+		Tracks:      80,
+		Heads:       2,
+	}
+)
+
+var diskParamsRegistry = []DiskParams{
+	DataFormatParams,
+	VendorFormatParams,
+	IBMFormatParams,
+	ParadosFormatParams,
+	RomdosD1FormatParams,
+	RomdosD2FormatParams,
+	RomdosD10FormatParams,
+	MS800FormatParams,
+}
+
+var dskFormatParams = map[DskFormat]DiskParams{
+	DataFormat:      DataFormatParams,
+	VendorFormat:    VendorFormatParams,
+	IBMFormat:       IBMFormatParams,
+	ParadosFormat:   ParadosFormatParams,
+	RomdosD1Format:  RomdosD1FormatParams,
+	RomdosD2Format:  RomdosD2FormatParams,
+	RomdosD10Format: RomdosD10FormatParams,
+	MS800Format:     MS800FormatParams,
+}
+
+// RegisterDiskParams adds custom disk parameters to the known presets.
+// Presets registered later take precedence during detection.
+func RegisterDiskParams(p DiskParams) {
+	diskParamsRegistry = append(diskParamsRegistry, p)
+}
+
+// LookupDiskParams returns the preset registered with the name.
+func LookupDiskParams(name string) (DiskParams, error) {
+	for i := len(diskParamsRegistry) - 1; i >= 0; i-- {
+		if diskParamsRegistry[i].Name == name {
+			return diskParamsRegistry[i], nil
+		}
+	}
+	return DiskParams{}, ErrorUnknownDiskParams
+}
+
+// BlocSize returns the size of an allocation bloc in bytes
+func (p DiskParams) BlocSize() int {
+	return 128 << p.BSH
+}
+
+// RecordsPerBloc returns the number of 128 bytes records in a bloc
+func (p DiskParams) RecordsPerBloc() int {
+	return int(p.BLM) + 1
+}
+
+// SectorSize returns the size of a physical sector in bytes
+func (p DiskParams) SectorSize() int {
+	return 128 << p.PSH
+}
+
+// SectorsPerTrack returns the number of physical sectors in a track
+func (p DiskParams) SectorsPerTrack() int {
+	return int(p.SPT) >> p.PSH
+}
+
+// SectorsPerBloc returns the number of physical sectors in a bloc
+func (p DiskParams) SectorsPerBloc() int {
+	return p.BlocSize() / p.SectorSize()
+}
+
+// DirEntries returns the number of entries in the directory
+func (p DiskParams) DirEntries() int {
+	return int(p.DRM) + 1
+}
+
+// DirBlocs returns the number of blocs reserved for the directory
+func (p DiskParams) DirBlocs() int {
+	return bits.OnesCount8(p.AL0) + bits.OnesCount8(p.AL1)
+}
+
+// WideBlocPointers is true when the directory stores 16 bits bloc numbers
+func (p DiskParams) WideBlocPointers() bool {
+	return p.DSM > 255
+}
+
+// BlocsPerEntry returns the number of bloc pointers in a directory entry
+func (p DiskParams) BlocsPerEntry() int {
+	if p.WideBlocPointers() {
+		return 8
+	}
+	return 16
+}
+
+// RecordsPerEntry returns the number of records addressed by a full directory entry
+func (p DiskParams) RecordsPerEntry() int {
+	return p.BlocsPerEntry() * p.RecordsPerBloc()
+}
+
+// EntryRecords returns the number of records used by the directory entry
+func (p DiskParams) EntryRecords(e StDirEntry) int {
+	return int(e.NumPage&p.EXM)*128 + int(e.NbPages)
+}
+
+// EntryIndex returns the position of the directory entry in its file,
+// computed from the extent number (EX and S2 bytes) and the extent mask
+func (p DiskParams) EntryIndex(e StDirEntry) int {
+	extent := int(e.Unused[1])<<5 | int(e.NumPage&0x1F)
+	return extent / (int(p.EXM) + 1)
+}
+
+// EntryBlocs returns the bloc numbers used by the directory entry
+func (p DiskParams) EntryBlocs(e StDirEntry) []uint16 {
+	blocs := make([]uint16, 0, p.BlocsPerEntry())
+	for i := 0; i < p.BlocsPerEntry(); i++ {
+		var b uint16
+		if p.WideBlocPointers() {
+			b = uint16(e.Blocks[i*2]) | uint16(e.Blocks[i*2+1])<<8
+		} else {
+			b = uint16(e.Blocks[i])
+		}
+		if b != 0 {
+			blocs = append(blocs, b)
+		}
+	}
+	return blocs
+}
+
+// EntryDataBlocs returns the blocs really holding the records of the directory entry
+func (p DiskParams) EntryDataBlocs(e StDirEntry) []uint16 {
+	blocs := p.EntryBlocs(e)
+	n := (p.EntryRecords(e) + p.RecordsPerBloc() - 1) / p.RecordsPerBloc()
+	if n < len(blocs) {
+		blocs = blocs[:n]
+	}
+	return blocs
+}
+
+// SetEntryBloc stores the bloc number at the index of the directory entry bloc list
+func (p DiskParams) SetEntryBloc(e *StDirEntry, index int, bloc uint16) {
+	if p.WideBlocPointers() {
+		e.Blocks[index*2] = byte(bloc)
+		e.Blocks[index*2+1] = byte(bloc >> 8)
+		return
+	}
+	e.Blocks[index] = byte(bloc)
+}
+
+// fitTo adapts the parameters to the number of sectors really found in a track.
+func (p DiskParams) fitTo(nbSect, nbTracks int) DiskParams {
+	if nbSect == 0 || nbSect == p.SectorsPerTrack() {
+		return p
+	}
+	p.SPT = uint16(nbSect << p.PSH)
+	usable := (nbTracks - int(p.OFF)) * nbSect * p.SectorSize() / p.BlocSize()
+	if usable > 0 {
+		p.DSM = uint16(usable - 1)
+	}
+	return p
+}
+
+// SetDiskParams forces the disk parameters used by the filesystem layer.
+func (d *DSK) SetDiskParams(p DiskParams) {
+	d.params = &p
+	d.catalogueLoaded = false
+}
+
+// DiskParams returns the disk parameters of the dsk, set with SetDiskParams
+// or detected from the sector numbering of the first track.
+// A preset with the same number of sectors and sides is preferred to one
+// only sharing the first sector id.
+func (d *DSK) DiskParams() DiskParams {
+	if d.params != nil {
+		return *d.params
+	}
+	p := DataFormatParams
+	if len(d.Tracks) == 0 {
+		return p
+	}
+	minSect := d.GetMinSect()
+	nbSect := int(d.Tracks[0].NbSect)
+	var score int
+	for i := len(diskParamsRegistry) - 1; i >= 0; i-- {
+		r := diskParamsRegistry[i]
+		if r.FirstSector != minSect {
+			continue
+		}
+		sameSect := r.SectorsPerTrack() == nbSect
+		if sameSect && r.Heads == d.Entry.NbHeads {
+			return r
+		}
+		if sameSect && score < 2 {
+			p, score = r, 2
+		} else if score < 1 {
+			p, score = r, 1
+		}
+	}
+	return p.fitTo(nbSect, len(d.Tracks))
+}
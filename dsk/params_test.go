@@ -0,0 +1,175 @@
+package dsk
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskParamsDetection(t *testing.T) {
+	tests := []struct {
+		format   DskFormat
+		expected DiskParams
+	}{
+		{DataFormat, DataFormatParams},
+		{VendorFormat, VendorFormatParams},
+	}
+	for _, tt := range tests {
+		d := FormatDsk(9, 40, 1, tt.format, 0)
+		assert.Equal(t, tt.expected, d.DiskParams())
+	}
+}
+
+func TestDiskParamsGeometry(t *testing.T) {
+	p := DataFormatParams
+	assert.Equal(t, 1024, p.BlocSize())
+	assert.Equal(t, 512, p.SectorSize())
+	assert.Equal(t, 9, p.SectorsPerTrack())
+	assert.Equal(t, 2, p.SectorsPerBloc())
+	assert.Equal(t, 64, p.DirEntries())
+	assert.Equal(t, 2, p.DirBlocs())
+	assert.False(t, p.WideBlocPointers())
+	assert.Equal(t, 128, p.RecordsPerEntry())
+}
+
+func TestDiskParamsWideBlocPointers(t *testing.T) {
+	p := DataFormatParams
+	p.DSM = 359
+	e := StDirEntry{}
+	p.SetEntryBloc(&e, 0, 0x0102)
+	p.SetEntryBloc(&e, 1, 300)
+	assert.Equal(t, byte(0x02), e.Blocks[0])
+	assert.Equal(t, byte(0x01), e.Blocks[1])
+	assert.Equal(t, []uint16{0x0102, 300}, p.EntryBlocs(e))
+}
+
+func TestRegisterDiskParams(t *testing.T) {
+	custom := IBMFormatParams
+	custom.Name = "custom-test"
+	custom.DRM = 127
+	custom.AL0 = 0xF0
+	RegisterDiskParams(custom)
+	p, err := LookupDiskParams("custom-test")
+	assert.NoError(t, err)
+	assert.Equal(t, 128, p.DirEntries())
+	assert.Equal(t, 4, p.DirBlocs())
+
+	_, err = LookupDiskParams("does-not-exist")
+	assert.ErrorIs(t, err, ErrorUnknownDiskParams)
+}
+
+func TestPutGetVendorFormat(t *testing.T) {
+	d := FormatDsk(9, 40, 1, VendorFormat, 0)
+	data := generateData(3000)
+	name := GetNomAmsdos("FILE.BIN")
+	data = addHeader(data, name)
+	assert.NoError(t, d.CopyFile(data, name, uint32(len(data)), 256, uint16(MODE_BINAIRE), false, false, false))
+	// the directory lives on the first track after the 2 reserved ones
+	dir, err := d.GetInfoDirEntry(0)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(2), dir.Blocks[0])
+	content, err := d.GetFileIn(name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, data, content[:len(data)])
+}
+
+func TestSetDiskParamsCustomGeometry(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	p := DataFormatParams
+	p.BSH = 4
+	p.BLM = 15
+	p.DSM = 89
+	p.DRM = 127
+	p.AL0 = 0xC0
+	d.SetDiskParams(p)
+	data := generateData(5000)
+	name := GetNomAmsdos("BIG.BIN")
+	data = addHeader(data, name)
+	assert.NoError(t, d.CopyFile(data, name, uint32(len(data)), p.DSM+1, uint16(MODE_BINAIRE), false, false, false))
+	assert.NoError(t, d.GetCatalogue())
+	assert.Len(t, d.Catalogue, 128)
+	assert.Equal(t, []uint16{2, 3, 4}, p.EntryDataBlocs(d.Catalogue[0]))
+	content, err := d.GetFileIn(name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, data, content[:len(data)])
+}
+
+func TestLargeDirectory(t *testing.T) {
+	// 512 directory entries in 8 blocs of 2K
+	p := RomdosD1FormatParams
+	p.Name = "romdos-d1-512"
+	p.DRM = 511
+	p.AL0 = 0xFF
+	d := FormatDskWithParams(p, p.Tracks, p.Heads, 0)
+	for i := range 300 {
+		_, err := d.AddFile(fmt.Sprintf("F%d.TXT", i), []byte(fmt.Sprintf("file %d", i)), FileOptions{Type: MODE_ASCII})
+		assert.NoError(t, err, i)
+	}
+	files, err := d.Files()
+	assert.NoError(t, err)
+	assert.Len(t, files, 300)
+	for i, f := range files {
+		assert.Equal(t, fmt.Sprintf("F%d.TXT", i), f.Filename())
+		assert.Equal(t, []int{i}, f.Indices)
+		assert.True(t, strings.HasPrefix(string(d.fileContent(f)), fmt.Sprintf("file %d\x1a", i)))
+	}
+	e, err := d.GetInfoDirEntry(299)
+	assert.NoError(t, err)
+	assert.Equal(t, "F299    ", string(e.Nom[:]))
+	_, err = d.GetInfoDirEntry(512)
+	assert.ErrorIs(t, err, ErrorCatalogueExceed)
+}
+
+func TestFormatDskWithParamsDetection(t *testing.T) {
+	for _, p := range []DiskParams{
+		ParadosFormatParams,
+		RomdosD1FormatParams,
+		RomdosD2FormatParams,
+		RomdosD10FormatParams,
+		MS800FormatParams,
+	} {
+		d := FormatDskWithParams(p, p.Tracks, p.Heads, 0)
+		assert.Equal(t, int(p.Tracks)*int(p.Heads), len(d.Tracks), p.Name)
+		assert.Equal(t, p.FirstSector, d.GetMinSect(), p.Name)
+		var buf bytes.Buffer
+		assert.NoError(t, d.Write(&buf), p.Name)
+		readDsk := &DSK{}
+		assert.NoError(t, readDsk.Read(bytes.NewReader(buf.Bytes())), p.Name)
+		assert.Equal(t, len(d.Tracks), len(readDsk.Tracks), p.Name)
+		assert.Equal(t, p, readDsk.DiskParams(), p.Name)
+		assert.NoError(t, readDsk.CheckDsk(), p.Name)
+	}
+}
+
+func TestFormatDskDoubleSidedTrackOrder(t *testing.T) {
+	d := FormatDsk(9, 80, 2, RomdosD1Format, EXTENDED_DSK_TYPE)
+	assert.Len(t, d.Tracks, 160)
+	assert.Len(t, d.TrackSizeTable, 160)
+	assert.Equal(t, uint8(0), d.Tracks[1].Track)
+	assert.Equal(t, uint8(1), d.Tracks[1].Head)
+	assert.Equal(t, uint8(1), d.Tracks[2].Track)
+	assert.Equal(t, uint8(0), d.Tracks[2].Head)
+}
+
+func TestPutGetRomdosD1Format(t *testing.T) {
+	d := FormatDsk(9, 80, 2, RomdosD1Format, 0)
+	p := d.DiskParams()
+	assert.True(t, p.WideBlocPointers())
+	data := generateData(40000)
+	name := GetNomAmsdos("FILE.BIN")
+	data = addHeader(data, name)
+	assert.NoError(t, d.CopyFile(data, name, uint32(len(data)), p.DSM+1, uint16(MODE_BINAIRE), false, false, false))
+
+	var buf bytes.Buffer
+	assert.NoError(t, d.Write(&buf))
+	readDsk := &DSK{}
+	assert.NoError(t, readDsk.Read(bytes.NewReader(buf.Bytes())))
+	assert.NoError(t, readDsk.GetCatalogue())
+	assert.Equal(t, []uint16{2, 3, 4, 5, 6, 7, 8, 9}, p.EntryDataBlocs(readDsk.Catalogue[0]))
+	content, err := readDsk.GetFileIn(name, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, data, content[:len(data)])
+}
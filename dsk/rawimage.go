@@ -0,0 +1,140 @@
+package dsk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+var (
+	ErrorUnknownSectorOrder = errors.New("unknown sector order")
+	ErrorRawGeometry        = errors.New("dsk geometry cannot be stored in a raw image")
+	ErrorRawSize            = errors.New("raw image size does not match the geometry")
+)
+
+// SectorOrder is the order of the tracks in a raw sector image (.img, .raw),
+// named as in cpmtools. The sectors of a track follow their sector IDs.
+type SectorOrder string
+
+var (
+	OrderSides   SectorOrder = "sides"   // cylinder by cylinder, side 1 follows side 0
+	OrderOutOut  SectorOrder = "outout"  // side 0 from cylinder 0 out, then side 1 the same way
+	OrderOutBack SectorOrder = "outback" // side 0 from cylinder 0 out, then side 1 from the last cylinder back
+)
+
+// rawTracks returns the cylinder and head of the tracks in the order
+func rawTracks(order SectorOrder, cyls, heads int) ([][2]int, error) {
+	tracks := make([][2]int, 0, cyls*heads)
+	switch order {
+	case OrderSides:
+		for c := 0; c < cyls; c++ {
+			for h := 0; h < heads; h++ {
+				tracks = append(tracks, [2]int{c, h})
+			}
+		}
+	case OrderOutOut, OrderOutBack:
+		for h := 0; h < heads; h++ {
+			for c := 0; c < cyls; c++ {
+				if h == 1 && order == OrderOutBack {
+					tracks = append(tracks, [2]int{cyls - 1 - c, h})
+				} else {
+					tracks = append(tracks, [2]int{c, h})
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrorUnknownSectorOrder, order)
+	}
+	return tracks, nil
+}
+
+// sectorsByID returns the positions of the sectors of the track sorted by sector ID
+func (t *CPCEMUTrack) sectorsByID() []int {
+	positions := make([]int, int(t.NbSect))
+	for s := range positions {
+		positions[s] = s
+	}
+	sort.SliceStable(positions, func(i, j int) bool {
+		return t.Sect[positions[i]].R < t.Sect[positions[j]].R
+	})
+	return positions
+}
+
+// ExportRaw writes the sectors of the dsk as a flat image, the tracks in the
+// order and the sectors of each track by sector ID. Every track must have
+// the sectors of the first track: same number and size.
+// The first copy of a weak sector is written, a short sector is completed
+// with the filler byte of its track.
+func (d *DSK) ExportRaw(w io.Writer, order SectorOrder) error {
+	heads := max(int(d.Entry.NbHeads), 1)
+	tracks, err := rawTracks(order, int(d.Entry.NbTracks), heads)
+	if err != nil {
+		return err
+	}
+	if len(d.Tracks) < len(tracks) || len(d.Tracks) == 0 {
+		return fmt.Errorf("%w: %d tracks of %d", ErrorRawGeometry, len(d.Tracks), len(tracks))
+	}
+	first := &d.Tracks[0]
+	for _, ch := range tracks {
+		t := d.Track(ch[0], ch[1])
+		if t.NbSect != first.NbSect {
+			return fmt.Errorf("%w: track %d head %d has %d sectors instead of %d", ErrorRawGeometry, ch[0], ch[1], t.NbSect, first.NbSect)
+		}
+		for _, s := range t.sectorsByID() {
+			if t.SectorSize(s) != first.SectorSize(0) {
+				return fmt.Errorf("%w: track %d head %d sector R:#%.2X of %d bytes instead of %d",
+					ErrorRawGeometry, ch[0], ch[1], t.Sect[s].R, t.SectorSize(s), first.SectorSize(0))
+			}
+			data := make([]byte, t.SectorSize(s))
+			n := copy(data, t.SectorCopies(s)[0])
+			for i := n; i < len(data); i++ {
+				data[i] = t.OctRemp
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// maxRawCylinders is the number of cylinders of the largest drive, an image
+// holding more has more heads than its geometry
+const maxRawCylinders = 86
+
+// ImportRaw returns a dsk formatted with the disk parameters and filled with
+// the flat image read from r, the tracks in the order and the sectors of each
+// track by sector ID. The number of heads comes from the disk parameters,
+// the number of cylinders follows the size of the image.
+func ImportRaw(r io.Reader, p DiskParams, order SectorOrder, extendedDskType int) (*DSK, error) {
+	if _, err := rawTracks(order, 0, 0); err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if p.SectorSize() != int(SECTSIZE) {
+		return nil, fmt.Errorf("%w: sectors of %d bytes", ErrorRawGeometry, p.SectorSize())
+	}
+	heads := max(int(p.Heads), 1)
+	cylSize := heads * p.SectorsPerTrack() * p.SectorSize()
+	if len(content) == 0 || len(content)%cylSize != 0 {
+		return nil, fmt.Errorf("%w: %d bytes for cylinders of %d bytes", ErrorRawSize, len(content), cylSize)
+	}
+	cyls := len(content) / cylSize
+	if cyls > maxRawCylinders {
+		return nil, fmt.Errorf("%w: %d cylinders of %d heads", ErrorRawSize, cyls, heads)
+	}
+	d := FormatDskWithParams(p, uint8(cyls), uint8(heads), extendedDskType)
+	tracks, _ := rawTracks(order, cyls, heads)
+	var pos int
+	for _, ch := range tracks {
+		t := d.Track(ch[0], ch[1])
+		for _, s := range t.sectorsByID() {
+			pos += copy(t.sectorData(s), content[pos:pos+t.SectorSize(s)])
+		}
+	}
+	return d, nil
+}
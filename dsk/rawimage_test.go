@@ -0,0 +1,76 @@
+package dsk
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawImageRoundTrip(t *testing.T) {
+	for _, order := range []SectorOrder{OrderSides, OrderOutOut, OrderOutBack} {
+		d := FormatDskWithParams(DataFormatParams, 40, 2, EXTENDED_DSK_TYPE)
+		data := putTestFile(t, d, "BIG.BIN", 60000, 0)
+		var b bytes.Buffer
+		assert.NoError(t, d.ExportRaw(&b, order), order)
+		assert.Equal(t, 40*2*9*512, b.Len(), order)
+
+		p := DataFormatParams
+		p.Heads = 2
+		r, err := ImportRaw(&b, p, order, EXTENDED_DSK_TYPE)
+		assert.NoError(t, err, order)
+		assert.True(t, Diff(d, r).Equal(), order)
+		f, err := r.lookupName("big.bin", 0)
+		assert.NoError(t, err, order)
+		assert.Equal(t, data, r.fileContent(f), order)
+		assert.Equal(t, CPCEMUSect{C: 12, H: 1, R: 0xC6, N: 2, SizeByte: 512}, r.Track(12, 1).Sect[1])
+	}
+}
+
+func TestExportRawOrder(t *testing.T) {
+	d := FormatDsk(9, 2, 2, DataFormat, DSK_TYPE)
+	for i := range d.Tracks {
+		tr := &d.Tracks[i]
+		for s := 0; s < int(tr.NbSect); s++ {
+			data := tr.sectorData(s)
+			for j := range data {
+				data[j] = byte(i<<4 | int(tr.Sect[s].R&0x0F))
+			}
+		}
+	}
+	expected := map[SectorOrder][]byte{
+		OrderSides:   {0x01, 0x11, 0x21, 0x31},
+		OrderOutOut:  {0x01, 0x21, 0x11, 0x31},
+		OrderOutBack: {0x01, 0x21, 0x31, 0x11},
+	}
+	for order, firsts := range expected {
+		var b bytes.Buffer
+		assert.NoError(t, d.ExportRaw(&b, order))
+		raw := b.Bytes()
+		for i, first := range firsts {
+			track := raw[i*9*512:]
+			assert.Equal(t, first, track[0], order)
+			// sectors follow their IDs, not their position in the track
+			assert.Equal(t, first+1, track[512], order)
+			assert.Equal(t, first+8, track[8*512], order)
+		}
+	}
+}
+
+func TestRawImageErrors(t *testing.T) {
+	_, err := ImportRaw(bytes.NewReader(make([]byte, 1000)), DataFormatParams, OrderSides, DSK_TYPE)
+	assert.ErrorIs(t, err, ErrorRawSize)
+	// a double sided 720K image read as single sided
+	_, err = ImportRaw(bytes.NewReader(make([]byte, 80*2*9*512)), DataFormatParams, OrderSides, DSK_TYPE)
+	assert.ErrorIs(t, err, ErrorRawSize)
+	_, err = ImportRaw(bytes.NewReader(make([]byte, 9*512)), DataFormatParams, "zigzag", DSK_TYPE)
+	assert.ErrorIs(t, err, ErrorUnknownSectorOrder)
+
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	d.Tracks[3].NbSect = 8
+	var b bytes.Buffer
+	assert.ErrorIs(t, d.ExportRaw(&b, OrderSides), ErrorRawGeometry)
+	d = FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	d.Tracks[3].Sect[2].N = 3
+	assert.ErrorIs(t, d.ExportRaw(&b, OrderSides), ErrorRawGeometry)
+}
@@ -0,0 +1,199 @@
+package dsk
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrorSectorNotFound = errors.New("sector not found in track")
+	ErrorNotExtendedDsk = errors.New("only an extended dsk can store this track")
+	ErrorSectorCopies   = errors.New("sector copies must have the sector size")
+	ErrorShortSector    = errors.New("sector holds less data than its size")
+)
+
+// SectorSize returns the size of the sector at position s in the track,
+// as given by its size code N
+func (t *CPCEMUTrack) SectorSize(s int) int {
+	return 128 << (t.Sect[s].N & 7)
+}
+
+// sectorSize returns the size of the data stored for the sector at
+// position s in the track, all copies included for a weak sector.
+// A size of 0 is a sector without data in an extended dsk, a standard dsk
+// may leave it unset for sectors of the size given by N.
+func (t *CPCEMUTrack) sectorSize(s int) int {
+	if t.Sect[s].SizeByte == 0 && t.standard {
+		return t.SectorSize(s)
+	}
+	return int(t.Sect[s].SizeByte)
+}
+
+// sectorPos returns the offset of the sector at position s in the track data
+func (t *CPCEMUTrack) sectorPos(s int) int {
+	var pos int
+	for i := 0; i < s; i++ {
+		pos += t.sectorSize(i)
+	}
+	return min(pos, len(t.Data))
+}
+
+// sectorData returns the data stored for the sector at position s of the track
+func (t *CPCEMUTrack) sectorData(s int) []byte {
+	pos := t.sectorPos(s)
+	return t.Data[pos:min(pos+t.sectorSize(s), len(t.Data))]
+}
+
+// SectorCopies returns the copies of the data of the sector at position s
+// in the track. An extended dsk stores several copies of a weak sector,
+// each read of the sector on the real disk returns different data.
+func (t *CPCEMUTrack) SectorCopies(s int) [][]byte {
+	if s < 0 || s >= int(t.NbSect) || s >= len(t.Sect) {
+		return nil
+	}
+	data := t.sectorData(s)
+	size := t.SectorSize(s)
+	if len(data) <= size || len(data)%size != 0 {
+		return [][]byte{data}
+	}
+	copies := make([][]byte, 0, len(data)/size)
+	for i := 0; i < len(data); i += size {
+		copies = append(copies, data[i:i+size])
+	}
+	return copies
+}
+
+// IsWeakSector is true if several copies of the sector at position s are stored
+func (t *CPCEMUTrack) IsWeakSector(s int) bool {
+	return len(t.SectorCopies(s)) > 1
+}
+
+// WeakBytes returns for each byte of the sector at position s whether it
+// differs between the copies of the sector
+func (t *CPCEMUTrack) WeakBytes(s int) []bool {
+	copies := t.SectorCopies(s)
+	if len(copies) == 0 {
+		return nil
+	}
+	weak := make([]bool, len(copies[0]))
+	for _, c := range copies[1:] {
+		for i := range weak {
+			weak[i] = weak[i] || c[i] != copies[0][i]
+		}
+	}
+	return weak
+}
+
+// SetSectorCopies stores the copies of the data of the sector at position s
+// in the track of the cylinder and head, one copy for a normal sector.
+// Every copy has the sector size.
+func (d *DSK) SetSectorCopies(cyl, head, s int, copies [][]byte) error {
+	track := d.TrackIndex(cyl, head)
+	if track < 0 {
+		return fmt.Errorf("%w: no track %d head %d", ErrorSectorNotFound, cyl, head)
+	}
+	t := &d.Tracks[track]
+	if s < 0 || s >= int(t.NbSect) {
+		return fmt.Errorf("%w: track %d head %d sector #%d", ErrorSectorNotFound, cyl, head, s)
+	}
+	if len(copies) == 0 {
+		return ErrorSectorCopies
+	}
+	size := t.SectorSize(s)
+	data := make([]byte, 0, size*len(copies))
+	for _, c := range copies {
+		if len(c) != size {
+			return fmt.Errorf("%w: %d bytes instead of %d", ErrorSectorCopies, len(c), size)
+		}
+		data = append(data, c...)
+	}
+	if len(copies) > 1 && !d.Extended {
+		return ErrorNotExtendedDsk
+	}
+	pos := t.sectorPos(s)
+	end := min(pos+t.sectorSize(s), len(t.Data))
+	t.Data = append(append(append([]byte(nil), t.Data[:pos]...), data...), t.Data[end:]...)
+	if d.Extended {
+		t.Sect[s].SizeByte = uint16(len(data))
+		if track < len(d.TrackSizeTable) {
+			d.TrackSizeTable[track] = byte((0x100 + len(t.Data) + 0xFF) / 0x100)
+		}
+	}
+	return nil
+}
+
+// FindSector returns the positions in the track of the cylinder and head of
+// the sectors whose ID field has the sector number id, in the order of the
+// track. Protected disks may have several sectors with the same ID.
+func (d *DSK) FindSector(cyl, head int, id uint8) ([]int, error) {
+	t := d.Track(cyl, head)
+	if t == nil {
+		return nil, fmt.Errorf("%w: no track %d head %d", ErrorSectorNotFound, cyl, head)
+	}
+	positions := make([]int, 0)
+	for s := 0; s < int(t.NbSect) && s < len(t.Sect); s++ {
+		if t.Sect[s].R == id {
+			positions = append(positions, s)
+		}
+	}
+	if len(positions) == 0 {
+		return nil, fmt.Errorf("%w: track %d head %d R:#%.2X", ErrorSectorNotFound, cyl, head, id)
+	}
+	return positions, nil
+}
+
+// ReadSector returns the data of the first sector with the sector number id
+// in the track of the cylinder and head, as the FDC finds it from the index.
+// A weak sector returns its first copy. When less data than the sector size
+// is stored, the stored data is returned with ErrorShortSector.
+func (d *DSK) ReadSector(cyl, head int, id uint8) ([]byte, error) {
+	positions, err := d.FindSector(cyl, head, id)
+	if err != nil {
+		return nil, err
+	}
+	return d.ReadSectorAt(cyl, head, positions[0])
+}
+
+// ReadSectorAt returns the data of the sector at position s in the track of
+// the cylinder and head, the duplicates of an ID are reached with FindSector.
+func (d *DSK) ReadSectorAt(cyl, head, s int) ([]byte, error) {
+	t := d.Track(cyl, head)
+	if t == nil || s < 0 || s >= int(t.NbSect) || s >= len(t.Sect) {
+		return nil, fmt.Errorf("%w: track %d head %d sector #%d", ErrorSectorNotFound, cyl, head, s)
+	}
+	data := append([]byte(nil), t.SectorCopies(s)[0]...)
+	if len(data) < t.SectorSize(s) {
+		return data, fmt.Errorf("%w: track %d head %d R:#%.2X %d bytes of %d",
+			ErrorShortSector, cyl, head, t.Sect[s].R, len(data), t.SectorSize(s))
+	}
+	return data, nil
+}
+
+// WriteSector writes data from the start of the first sector with the sector
+// number id in the track of the cylinder and head.
+// ErrorShortSector is returned if the sector holds less data than given.
+func (d *DSK) WriteSector(cyl, head int, id uint8, data []byte) error {
+	positions, err := d.FindSector(cyl, head, id)
+	if err != nil {
+		return err
+	}
+	return d.WriteSectorAt(cyl, head, positions[0], data)
+}
+
+// WriteSectorAt writes data from the start of the sector at position s in the
+// track of the cylinder and head, every copy of a weak sector is written.
+func (d *DSK) WriteSectorAt(cyl, head, s int, data []byte) error {
+	t := d.Track(cyl, head)
+	if t == nil || s < 0 || s >= int(t.NbSect) || s >= len(t.Sect) {
+		return fmt.Errorf("%w: track %d head %d sector #%d", ErrorSectorNotFound, cyl, head, s)
+	}
+	copies := t.SectorCopies(s)
+	if len(data) > len(copies[0]) {
+		return fmt.Errorf("%w: track %d head %d R:#%.2X %d bytes to write in %d",
+			ErrorShortSector, cyl, head, t.Sect[s].R, len(data), len(copies[0]))
+	}
+	for _, c := range copies {
+		copy(c, data)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package dsk
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeakSectorRoundTrip(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	tr := &d.Tracks[2]
+	next := append([]byte(nil), tr.sectorData(3)...)
+	for i := range next {
+		next[i] = byte(i)
+	}
+	copy(tr.Data[tr.sectorPos(3):], next)
+	// bytes 10 to 19 differ from one copy to the other
+	copies := make([][]byte, 3)
+	for i := range copies {
+		copies[i] = make([]byte, 512)
+		for j := range copies[i] {
+			copies[i][j] = byte(j)
+		}
+		for j := 10; j < 20; j++ {
+			copies[i][j] = byte(i)
+		}
+	}
+	assert.NoError(t, d.SetSectorCopies(2, 0, 2, copies))
+	assert.Equal(t, uint16(1536), tr.Sect[2].SizeByte)
+	assert.Equal(t, byte((0x100+8*512+1536)/0x100), d.TrackSizeTable[2])
+
+	var b bytes.Buffer
+	assert.NoError(t, d.Write(&b))
+	r := &DSK{}
+	assert.NoError(t, r.Read(&b))
+	tr = &r.Tracks[2]
+	assert.True(t, tr.IsWeakSector(2))
+	assert.False(t, tr.IsWeakSector(1))
+	assert.Equal(t, copies, tr.SectorCopies(2))
+	assert.Equal(t, [][]byte{next}, tr.SectorCopies(3))
+	for i, weak := range tr.WeakBytes(2) {
+		assert.Equal(t, i >= 10 && i < 20, weak, i)
+	}
+
+	// the sector goes back to a single copy
+	assert.NoError(t, r.SetSectorCopies(2, 0, 2, copies[:1]))
+	assert.False(t, r.Tracks[2].IsWeakSector(2))
+	assert.Equal(t, [][]byte{next}, r.Tracks[2].SectorCopies(3))
+}
+
+func TestSetSectorCopiesErrors(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	assert.ErrorIs(t, d.SetSectorCopies(2, 0, 2, [][]byte{generateData(512), generateData(512)}), ErrorNotExtendedDsk)
+	assert.ErrorIs(t, d.SetSectorCopies(2, 0, 2, [][]byte{generateData(256)}), ErrorSectorCopies)
+	assert.ErrorIs(t, d.SetSectorCopies(2, 0, 9, [][]byte{generateData(512)}), ErrorSectorNotFound)
+	assert.ErrorIs(t, d.SetSectorCopies(40, 0, 0, [][]byte{generateData(512)}), ErrorSectorNotFound)
+}
+
+func TestReadWriteSector(t *testing.T) {
+	d := FormatDsk(9, 40, 2, DataFormat, EXTENDED_DSK_TYPE)
+	data := generateData(512)
+	assert.NoError(t, d.WriteSector(3, 1, 0xC5, data))
+	read, err := d.ReadSector(3, 1, 0xC5)
+	assert.NoError(t, err)
+	assert.Equal(t, data, read)
+	// the sector is on the second side of cylinder 3
+	tr := d.Track(3, 1)
+	positions, err := d.FindSector(3, 1, 0xC5)
+	assert.NoError(t, err)
+	assert.Equal(t, data, tr.sectorData(positions[0]))
+	read, _ = d.ReadSector(3, 0, 0xC5)
+	assert.NotEqual(t, data, read)
+
+	_, err = d.ReadSector(3, 1, 0x41)
+	assert.ErrorIs(t, err, ErrorSectorNotFound)
+	_, err = d.ReadSector(40, 0, 0xC1)
+	assert.ErrorIs(t, err, ErrorSectorNotFound)
+	_, err = d.ReadSector(3, 2, 0xC1)
+	assert.ErrorIs(t, err, ErrorSectorNotFound)
+	assert.ErrorIs(t, d.WriteSector(3, 1, 0xC5, generateData(513)), ErrorShortSector)
+}
+
+func TestReadSectorDuplicateIDs(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	tr := &d.Tracks[5]
+	tr.Sect[4].R = tr.Sect[1].R
+	first, second := generateData(512), generateData(512)
+	assert.NoError(t, d.WriteSectorAt(5, 0, 1, first))
+	assert.NoError(t, d.WriteSectorAt(5, 0, 4, second))
+
+	positions, err := d.FindSector(5, 0, tr.Sect[1].R)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 4}, positions)
+	read, err := d.ReadSector(5, 0, tr.Sect[1].R)
+	assert.NoError(t, err)
+	assert.Equal(t, first, read)
+	read, err = d.ReadSectorAt(5, 0, positions[1])
+	assert.NoError(t, err)
+	assert.Equal(t, second, read)
+}
+
+func TestReadSectorSizes(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	tr := &d.Tracks[1]
+	tr.NbSect = 2
+	tr.Sect[0] = CPCEMUSect{C: 1, R: 0x01, N: 3, SizeByte: 1024}
+	// 8 Kb sector of which 6144 bytes are stored
+	tr.Sect[1] = CPCEMUSect{C: 1, R: 0x02, N: 6, SizeByte: 0x1800}
+	tr.Data = generateData(1024 + 0x1800)
+
+	read, err := d.ReadSector(1, 0, 0x01)
+	assert.NoError(t, err)
+	assert.Equal(t, tr.Data[:1024], read)
+	read, err = d.ReadSector(1, 0, 0x02)
+	assert.ErrorIs(t, err, ErrorShortSector)
+	assert.Equal(t, tr.Data[1024:], read)
+}
+
+func TestWriteWeakSector(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	copies := [][]byte{generateData(512), generateData(512)}
+	assert.NoError(t, d.SetSectorCopies(2, 0, 0, copies))
+	data := generateData(512)
+	assert.NoError(t, d.WriteSector(2, 0, d.Tracks[2].Sect[0].R, data))
+	assert.Equal(t, [][]byte{data, data}, d.Tracks[2].SectorCopies(0))
+	assert.NotContains(t, d.Tracks[2].WeakBytes(0), true)
+}
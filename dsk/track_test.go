@@ -0,0 +1,225 @@
+package dsk
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnformattedTrackRoundTrip(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	d.Tracks[39] = CPCEMUTrack{}
+	var b bytes.Buffer
+	assert.NoError(t, d.Write(&b))
+	assert.Equal(t, byte(0), d.TrackSizeTable[39])
+	assert.Equal(t, byte(0x13), d.TrackSizeTable[38])
+	assert.Equal(t, 0x100+39*0x1300, b.Len())
+
+	r := &DSK{}
+	assert.NoError(t, r.Read(&b))
+	assert.Len(t, r.Tracks, 40)
+	assert.False(t, r.Tracks[39].Formatted())
+	assert.True(t, r.Tracks[38].Formatted())
+	assert.Equal(t, d.Tracks[38].Data, r.Tracks[38].Data)
+}
+
+func TestVariableTrackSizes(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	// 2 sectors of 1024 bytes
+	tr := &d.Tracks[1]
+	tr.NbSect = 2
+	for s := 0; s < 2; s++ {
+		tr.Sect[s] = CPCEMUSect{C: 1, R: byte(0xC1 + s), N: 3, SizeByte: 1024}
+	}
+	tr.Data = generateData(2048)
+	// a single sector of 8 Kb holding 6144 bytes
+	tr = &d.Tracks[2]
+	tr.NbSect = 1
+	tr.Sect[0] = CPCEMUSect{C: 2, R: 0xC1, N: 6, SizeByte: 0x1800}
+	tr.Data = generateData(0x1800)
+	// a sector of 128 bytes
+	tr = &d.Tracks[3]
+	tr.NbSect = 1
+	tr.Sect[0] = CPCEMUSect{C: 3, R: 0xC1, N: 0, SizeByte: 128}
+	tr.Data = generateData(128)
+
+	var b bytes.Buffer
+	assert.NoError(t, d.Write(&b))
+	assert.Equal(t, []byte{0x13, 0x09, 0x19, 0x02, 0x13}, d.TrackSizeTable[:5])
+
+	r := &DSK{}
+	assert.NoError(t, r.Read(&b))
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, d.Tracks[i].NbSect, r.Tracks[i].NbSect, i)
+		assert.Equal(t, d.Tracks[i].Sect[:d.Tracks[i].NbSect], r.Tracks[i].Sect[:r.Tracks[i].NbSect], i)
+		assert.Equal(t, d.Tracks[i].Data, r.Tracks[i].Data, i)
+	}
+}
+
+func TestZeroSizeSectorRoundTrip(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	// a sector of 512 bytes without data, then one of 4 Kb
+	tr := &d.Tracks[1]
+	tr.NbSect = 2
+	tr.Sect[0] = CPCEMUSect{C: 1, R: 0xC1, N: 2, SizeByte: 0, Un1: 0x0101}
+	tr.Sect[1] = CPCEMUSect{C: 1, R: 0xC2, N: 5, SizeByte: 0x1000}
+	tr.Data = generateData(0x1000)
+
+	var b bytes.Buffer
+	assert.NoError(t, d.Write(&b))
+	assert.Equal(t, byte(0x11), d.TrackSizeTable[1])
+
+	r := &DSK{}
+	assert.NoError(t, r.Read(&b))
+	assert.Equal(t, d.Tracks[1].Sect[:2], r.Tracks[1].Sect[:2])
+	assert.Equal(t, d.Tracks[1].Data, r.Tracks[1].Data)
+	assert.Equal(t, uint16(0), r.GetPosData(1, 0, 0xC2, true))
+	data, err := r.ReadSector(1, 0, 0xC1)
+	assert.ErrorIs(t, err, ErrorShortSector)
+	assert.Empty(t, data)
+	data, err = r.ReadSector(1, 0, 0xC2)
+	assert.NoError(t, err)
+	assert.Equal(t, d.Tracks[1].Data, data)
+}
+
+func TestReadExtendedTrackPadding(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	var b bytes.Buffer
+	assert.NoError(t, d.Tracks[0].Write(&b))
+	b.Write(make([]byte, 0x300))
+	assert.NoError(t, d.Tracks[1].Write(&b))
+
+	tr, err := readExtendedTrack(&b, 0x1600)
+	assert.NoError(t, err)
+	assert.Equal(t, d.Tracks[0].Data, tr.Data)
+	tr, err = readExtendedTrack(&b, 0x1300)
+	assert.NoError(t, err)
+	assert.Equal(t, d.Tracks[1].Data, tr.Data)
+
+	// the size in the table is too small for the sectors
+	b.Reset()
+	assert.NoError(t, d.Tracks[0].Write(&b))
+	_, err = readExtendedTrack(&b, 0x1000)
+	assert.ErrorIs(t, err, ErrorTrackSize)
+}
+
+func TestWriteUnformattedTrackStandardDsk(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, DSK_TYPE)
+	d.Tracks[10] = CPCEMUTrack{}
+	var b bytes.Buffer
+	assert.ErrorIs(t, d.Write(&b), ErrorUnformattedTrack)
+}
+
+func TestReadTruncatedExtendedDsk(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, EXTENDED_DSK_TYPE)
+	var b bytes.Buffer
+	assert.NoError(t, d.Write(&b))
+	r := &DSK{}
+	assert.Error(t, r.Read(bytes.NewReader(b.Bytes()[:b.Len()-0x200])))
+}
+
+func TestTrackAddressing(t *testing.T) {
+	d := FormatDsk(9, 40, 2, DataFormat, EXTENDED_DSK_TYPE)
+	assert.Len(t, d.Tracks, 80)
+	for c := 0; c < 40; c++ {
+		for h := 0; h < 2; h++ {
+			tr := d.Track(c, h)
+			assert.Equal(t, uint8(c), tr.Track)
+			assert.Equal(t, uint8(h), tr.Head)
+			assert.Equal(t, uint8(h), tr.Sect[0].H)
+		}
+	}
+	assert.Nil(t, d.Track(40, 0))
+	assert.Nil(t, d.Track(0, 2))
+	assert.Equal(t, -1, d.TrackIndex(-1, 0))
+	assert.Equal(t, 7, d.TrackIndex(3, 1))
+}
+
+func TestDoubleSidedRoundTrip(t *testing.T) {
+	for _, dskType := range []int{DSK_TYPE, EXTENDED_DSK_TYPE} {
+		d := FormatDsk(9, 40, 2, DataFormat, dskType)
+		data := putTestFile(t, d, "BIG.BIN", 60000, 0) // on both sides of the first cylinders
+		copy(d.Track(39, 1).Data, []byte("last track"))
+
+		var b bytes.Buffer
+		assert.NoError(t, d.Write(&b))
+		r := &DSK{}
+		assert.NoError(t, r.Read(&b))
+		assert.Len(t, r.Tracks, 80)
+		assert.Equal(t, d.Track(39, 1).Data, r.Track(39, 1).Data)
+		assert.Equal(t, uint8(1), r.Track(39, 1).Head)
+		f, err := r.LookupFile(0, [8]byte{'B', 'I', 'G', ' ', ' ', ' ', ' ', ' '}, [3]byte{'B', 'I', 'N'})
+		assert.NoError(t, err)
+		assert.Equal(t, data, r.fileContent(f))
+	}
+}
+
+func TestFormatTrack(t *testing.T) {
+	d := FormatDsk(9, 40, 2, DataFormat, DSK_TYPE)
+	d.FormatTrack(41, 1, 0xC1, 9)
+	assert.Equal(t, uint8(42), d.Entry.NbTracks)
+	assert.Len(t, d.Tracks, 84)
+	assert.Equal(t, uint8(41), d.Track(41, 1).Track)
+	assert.Equal(t, uint8(1), d.Track(41, 1).Head)
+	assert.Equal(t, uint8(1), d.Track(40, 1).Head)
+}
+
+func TestRawFileDoubleSided(t *testing.T) {
+	d := FormatDsk(9, 40, 2, DataFormat, EXTENDED_DSK_TYPE)
+	data := generateData(10000)
+	cyl, head, sect, err := d.CopyRawFile(data, uint16(len(data)), 3, 1, 0)
+	assert.NoError(t, err)
+	// 9 sectors on each side of cylinder 3 side 1 and cylinder 4 side 0
+	assert.Equal(t, []int{4, 1, 2}, []int{cyl, head, sect})
+	assert.Equal(t, data[:512], d.Track(3, 1).Data[:512])
+	assert.Equal(t, data[9*512:10*512], d.Track(4, 0).Data[:512])
+	assert.Equal(t, uint16(1024), d.GetPosData(3, 1, 0xC2, true)) // interleaved sectors
+
+	cyl, head, sect, content := d.ExtractRawFile(uint16(len(data)), 3, 1, 0)
+	assert.Equal(t, []int{4, 1, 2}, []int{cyl, head, sect})
+	assert.Equal(t, data, content[:len(data)])
+}
+
+func TestRawFileGrowsCylinders(t *testing.T) {
+	for _, dskType := range []int{DSK_TYPE, EXTENDED_DSK_TYPE} {
+		d := FormatDsk(9, 40, 2, DataFormat, dskType)
+		data := generateData(512)
+		_, _, _, err := d.CopyRawFile(data, uint16(len(data)), 40, 0, 0)
+		assert.NoError(t, err)
+		// both sides of the new cylinder are added
+		assert.Equal(t, uint8(41), d.Entry.NbTracks)
+		assert.Len(t, d.Tracks, 82)
+		assert.Equal(t, uint8(1), d.Track(40, 1).Head)
+
+		var b bytes.Buffer
+		assert.NoError(t, d.Write(&b))
+		r := &DSK{}
+		assert.NoError(t, r.Read(&b))
+		assert.Len(t, r.Tracks, 82)
+		assert.Equal(t, data, r.Track(40, 0).Data[:512])
+		assert.Equal(t, d.Track(40, 1).Data, r.Track(40, 1).Data)
+	}
+}
+
+func TestFormatTrackExtendedDsk(t *testing.T) {
+	d := FormatDsk(9, 40, 2, DataFormat, EXTENDED_DSK_TYPE)
+	d.FormatTrack(41, 1, 0xC1, 9)
+	assert.Len(t, d.TrackSizeTable, 84)
+	assert.Equal(t, byte(0x13), d.TrackSizeTable[83])
+
+	var b bytes.Buffer
+	assert.NoError(t, d.Write(&b))
+	r := &DSK{}
+	assert.NoError(t, r.Read(&b))
+	assert.Len(t, r.Tracks, 84)
+	assert.Equal(t, uint8(9), r.Track(41, 1).NbSect)
+}
+
+func TestRawFileReservedTracks(t *testing.T) {
+	d := FormatDsk(9, 40, 2, VendorFormat, EXTENDED_DSK_TYPE)
+	copy(d.Track(1, 0).Data, "first data track")
+	// the 2 reserved tracks are both sides of cylinder 0
+	_, _, _, content := d.ExtractRawFile(512, 0, 0, 0)
+	assert.Equal(t, "first data track", string(content[:16]))
+}
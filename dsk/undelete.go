@@ -0,0 +1,154 @@
+package dsk
+
+import (
+	"errors"
+	"slices"
+)
+
+var ErrorBlocsReused = errors.New("deleted file blocs are used by another file")
+
+// DeletedFile describes a file found in the deleted entries of the catalogue.
+// File.User is USER_DELETED, the user of the file is lost on deletion.
+type DeletedFile struct {
+	File        CatalogueFile
+	Blocs       []uint16 // data blocs of the file
+	ReusedBlocs []uint16 // blocs allocated to a live file or out of the disk
+	Err         error    // missing or duplicate extents
+}
+
+// IntactBlocs returns the number of data blocs not reused since the deletion
+func (f DeletedFile) IntactBlocs() int {
+	return len(f.Blocs) - len(f.ReusedBlocs)
+}
+
+// Recoverable is true if the file can be undeleted without loss
+func (f DeletedFile) Recoverable() bool {
+	return f.Err == nil && len(f.ReusedBlocs) == 0
+}
+
+// isDeletedEntry is true for an erased entry, entries never used since the
+// format are filled with #E5 and are skipped
+func isDeletedEntry(e StDirEntry) bool {
+	if e.User != USER_DELETED {
+		return false
+	}
+	for _, c := range e.Nom {
+		if c != USER_DELETED {
+			return true
+		}
+	}
+	for _, c := range e.Ext {
+		if c != USER_DELETED {
+			return true
+		}
+	}
+	return false
+}
+
+// generations splits the deleted entries of a name, ordered by extent,
+// into the files erased at different times: each extent of a file follows
+// the previous one and the blocs of its extents are disjoint.
+func generations(f CatalogueFile, p DiskParams) []CatalogueFile {
+	files := make([]CatalogueFile, 0)
+	next := make([]int, 0)              // next extent of each file
+	blocs := make([]map[uint16]bool, 0) // blocs of each file
+	for j, e := range f.Entries {
+		n := p.EntryIndex(e)
+		entryBlocs := p.EntryDataBlocs(e)
+		g := -1
+		for k := range files {
+			if next[k] == n && !slices.ContainsFunc(entryBlocs, func(b uint16) bool { return blocs[k][b] }) {
+				g = k
+				break
+			}
+		}
+		if g < 0 {
+			g = len(files)
+			files = append(files, CatalogueFile{User: f.User, Nom: f.Nom, Ext: f.Ext})
+			next = append(next, n)
+			blocs = append(blocs, make(map[uint16]bool))
+		}
+		files[g].Indices = append(files[g].Indices, f.Indices[j])
+		files[g].Entries = append(files[g].Entries, e)
+		next[g] = n + 1
+		for _, b := range entryBlocs {
+			blocs[g][b] = true
+		}
+	}
+	return files
+}
+
+// ListDeleted returns the deleted files of the catalogue with the blocs
+// reused by the live files, the bitmap is rebuilt with FillBitmap.
+// A name erased several times gives a file for each erased copy.
+func (d *DSK) ListDeleted() ([]DeletedFile, error) {
+	if err := d.GetCatalogue(); err != nil {
+		return nil, err
+	}
+	p := d.DiskParams()
+	d.FillBitmap()
+	deleted := make([]CatalogueFile, 0)
+	for _, f := range d.groupEntries(isDeletedEntry) {
+		deleted = append(deleted, generations(f, p)...)
+	}
+	files := make([]DeletedFile, 0)
+	for _, f := range deleted {
+		df := DeletedFile{
+			File:        f,
+			Blocs:       f.Blocs(p),
+			ReusedBlocs: make([]uint16, 0),
+			Err:         f.check(p),
+		}
+		for _, b := range df.Blocs {
+			if b > p.DSM || d.BitMap[b] != 0 {
+				df.ReusedBlocs = append(df.ReusedBlocs, b)
+			}
+		}
+		files = append(files, df)
+	}
+	return files, nil
+}
+
+// Undelete restores the deleted file in the user area and returns its state.
+// The file is not restored if an extent is missing or if one of its blocs is
+// used by another file. When the name was erased several times, the first
+// copy that can be restored without loss is restored.
+func (d *DSK) Undelete(name string, user uint8) (DeletedFile, error) {
+	entry := d.GetNomDir(GetNomAmsdos(name), false)
+	deleted, err := d.ListDeleted()
+	if err != nil {
+		return DeletedFile{}, err
+	}
+	k := entryKey(entry)
+	candidates := make([]DeletedFile, 0)
+	for _, df := range deleted {
+		if df.File.Nom == k.nom && df.File.Ext == k.ext {
+			candidates = append(candidates, df)
+		}
+	}
+	if len(candidates) == 0 {
+		return DeletedFile{}, ErrorFileNotFound
+	}
+	df := candidates[0]
+	if i := slices.IndexFunc(candidates, DeletedFile.Recoverable); i >= 0 {
+		df = candidates[i]
+	}
+	if df.Err != nil {
+		return df, df.Err
+	}
+	if len(df.ReusedBlocs) != 0 {
+		return df, ErrorBlocsReused
+	}
+	if _, err := d.LookupFile(user, k.nom, k.ext); !errors.Is(err, ErrorFileNotFound) {
+		return df, ErrorFileExists
+	}
+	for j, i := range df.File.Indices {
+		df.File.Entries[j].User = user
+		if err := d.SetInfoDirEntry(i, df.File.Entries[j]); err != nil {
+			return df, err
+		}
+	}
+	d.FillBitmap()
+	df.File.User = user
+	return df, nil
+}
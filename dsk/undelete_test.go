@@ -0,0 +1,109 @@
+package dsk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUndelete(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	data := putTestFile(t, d, "LOST.BIN", 20000, 2)
+	putTestFile(t, d, "KEEP.BIN", 1000, 0)
+	assert.NoError(t, d.RemoveFile(0))
+
+	deleted, err := d.ListDeleted()
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 1)
+	assert.Equal(t, "LOST.BIN", deleted[0].File.Filename())
+	assert.Equal(t, 20, deleted[0].IntactBlocs())
+	assert.True(t, deleted[0].Recoverable())
+
+	df, err := d.Undelete("lost.bin", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(2), df.File.User)
+	f, err := d.LookupFile(2, df.File.Nom, df.File.Ext)
+	assert.NoError(t, err)
+	content, err := d.GetFileIn("LOST.BIN", f.Indices[0])
+	assert.NoError(t, err)
+	assert.Equal(t, data, content[:len(data)])
+	assert.Equal(t, byte(1), d.BitMap[2])
+
+	deleted, err = d.ListDeleted()
+	assert.NoError(t, err)
+	assert.Empty(t, deleted)
+	_, err = d.Undelete("lost.bin", 2)
+	assert.ErrorIs(t, err, ErrorFileNotFound)
+}
+
+func TestUndeleteBlocsReused(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	putTestFile(t, d, "LOST.BIN", 5000, 0)
+	assert.NoError(t, d.RemoveFile(0))
+	// a live file now owns the first 3 blocs of the deleted one
+	e, _ := d.GetInfoDirEntry(0)
+	e.User = 0
+	copy(e.Nom[:], "NEW     ")
+	e.NbPages = 24
+	for i := 3; i < len(e.Blocks); i++ {
+		e.Blocks[i] = 0
+	}
+	assert.NoError(t, d.SetInfoDirEntry(5, e))
+
+	deleted, err := d.ListDeleted()
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 1)
+	assert.Equal(t, []uint16{2, 3, 4}, deleted[0].ReusedBlocs)
+	assert.Equal(t, 3, deleted[0].IntactBlocs())
+	assert.False(t, deleted[0].Recoverable())
+
+	_, err = d.Undelete("LOST.BIN", 0)
+	assert.ErrorIs(t, err, ErrorBlocsReused)
+	e, _ = d.GetInfoDirEntry(0)
+	assert.Equal(t, USER_DELETED, e.User)
+}
+
+func TestUndeleteErasedTwice(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	putTestFile(t, d, "A.BIN", 3000, 0)
+	old := putTestFile(t, d, "FILE.BAK", 2000, 0)
+	assert.NoError(t, d.RemoveFile(1))
+	assert.NoError(t, d.RemoveFile(0))
+	// the new copy takes the entry and the blocs of A.BIN
+	recent := putTestFile(t, d, "FILE.BAK", 2500, 0)
+	assert.NoError(t, d.RemoveFile(0))
+
+	deleted, err := d.ListDeleted()
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 2)
+	for _, df := range deleted {
+		assert.Equal(t, "FILE.BAK", df.File.Filename())
+		assert.True(t, df.Recoverable())
+	}
+	assert.Equal(t, []int{0}, deleted[0].File.Indices)
+	assert.Equal(t, []int{1}, deleted[1].File.Indices)
+
+	for user, data := range [][]byte{recent, old} {
+		df, err := d.Undelete("FILE.BAK", uint8(user))
+		assert.NoError(t, err)
+		content, err := d.GetFileIn("FILE.BAK", df.File.Indices[0])
+		assert.NoError(t, err)
+		assert.Equal(t, data, content[:len(data)])
+	}
+	_, err = d.Undelete("FILE.BAK", 2)
+	assert.ErrorIs(t, err, ErrorFileNotFound)
+}
+
+func TestListDeletedMissingExtent(t *testing.T) {
+	d := FormatDsk(9, 40, 1, DataFormat, 0)
+	putTestFile(t, d, "BIG.BIN", 20000, 0)
+	assert.NoError(t, d.RemoveFile(0))
+	// the first extent is overwritten by a new file
+	putTestFile(t, d, "NEW.BIN", 100, 0)
+
+	deleted, err := d.ListDeleted()
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 1)
+	assert.ErrorIs(t, deleted[0].Err, ErrorMissingExtent)
+	assert.Contains(t, deleted[0].Err.Error(), "BIG.BIN deleted extent 0")
+}
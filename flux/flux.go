@@ -0,0 +1,201 @@
+// Package flux reads the flux transitions of floppy disk dumps (SuperCard Pro
+// and KryoFlux) and decodes their MFM tracks into an extended dsk.
+package flux
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeromelesaux/dsk/dsk"
+	"github.com/jeromelesaux/dsk/internal/fdc"
+)
+
+var (
+	ErrorNoTrack  = errors.New("no track in the flux image")
+	ErrorNoSector = errors.New("no MFM sector found in the flux image")
+)
+
+// cell lengths of the MFM data rates in nanoseconds
+const (
+	cellDD = 2000.0 // 250 kbps
+	cellHD = 1000.0 // 500 kbps
+)
+
+// Track is a track of a flux image, each revolution of the disk holds the
+// intervals between two flux transitions in nanoseconds from the index
+type Track struct {
+	Cylinder    int
+	Head        int
+	Revolutions [][]uint32
+}
+
+// Image is a flux image of a disk
+type Image struct {
+	Tracks []Track
+}
+
+// Open reads the flux image of the path: a SuperCard Pro file, a directory
+// of KryoFlux streams or one of its stream files
+func Open(path string) (*Image, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case info.IsDir():
+		return OpenKryoFlux(path)
+	case strings.EqualFold(filepath.Ext(path), ".raw"):
+		return OpenKryoFlux(filepath.Dir(path))
+	default:
+		return OpenSCP(path)
+	}
+}
+
+// decodeCells turns the flux intervals into MFM cells (1 for a transition)
+// with a software PLL following the speed variations of the disk around the
+// nominal cell length
+func decodeCells(intervals []uint32, cell float64) []byte {
+	period := cell
+	cells := make([]byte, 0, len(intervals)*3)
+	for _, iv := range intervals {
+		n := max(int(math.Round(float64(iv)/period)), 1)
+		for i := 1; i < n; i++ {
+			cells = append(cells, 0)
+		}
+		cells = append(cells, 1)
+		// only the intervals of MFM data (2 to 4 cells) adjust the clock
+		if n >= 2 && n <= 4 {
+			period += (float64(iv)/float64(n) - period) * 0.05
+			period = min(max(period, cell*0.9), cell*1.1)
+		}
+	}
+	return cells
+}
+
+// decodeTrack returns the sectors of every revolution of the track at the
+// cell length, and the length of the revolution with the most sectors in bytes
+func decodeTrack(t Track, cell float64) ([][]fdc.Sector, int, int) {
+	revs := make([][]fdc.Sector, 0, len(t.Revolutions))
+	best, length := 0, 0
+	for i, r := range t.Revolutions {
+		revs = append(revs, fdc.MFMSectors(decodeCells(r, cell)))
+		if len(revs[i]) > len(revs[best]) || i == 0 {
+			var duration float64
+			for _, iv := range r {
+				duration += float64(iv)
+			}
+			best, length = i, int(math.Round(duration/(cell*16)))
+		}
+	}
+	return revs, best, length
+}
+
+// mergeRevolutions returns the sectors of the reference revolution with the
+// data read on each revolution. A sector whose data differs from one
+// revolution to the other without a good CRC is weak, its copies are kept.
+func mergeRevolutions(revs [][]fdc.Sector, ref int) []fdc.Sector {
+	sectors := make([]fdc.Sector, 0, len(revs[ref]))
+	seen := make(map[[4]byte]int)
+	for _, s := range revs[ref] {
+		occurrence := seen[s.ID]
+		seen[s.ID]++
+		m := s
+		reads := make([]fdc.Sector, 0, len(revs))
+		for _, rev := range revs {
+			var n int
+			for _, o := range rev {
+				if o.ID != s.ID {
+					continue
+				}
+				if n == occurrence {
+					reads = append(reads, o)
+					break
+				}
+				n++
+			}
+		}
+		for _, r := range reads {
+			m.IDCRC = m.IDCRC || r.IDCRC
+			if !r.HasData {
+				continue
+			}
+			if !m.HasData || r.DataCRC && !m.DataCRC {
+				m.HasData, m.Deleted, m.Data, m.DataCRC = true, r.Deleted, r.Data, r.DataCRC
+			}
+		}
+		if m.HasData && !m.DataCRC {
+			for _, r := range reads {
+				if r.HasData && !containsData(m.Copies, r.Data) {
+					m.Copies = append(m.Copies, r.Data)
+				}
+			}
+		}
+		if len(m.Copies) < 2 {
+			m.Copies = nil
+		}
+		sectors = append(sectors, m)
+	}
+	return sectors
+}
+
+func containsData(copies [][]byte, data []byte) bool {
+	for _, c := range copies {
+		if bytes.Equal(c, data) {
+			return true
+		}
+	}
+	return false
+}
+
+// ToDSK decodes the MFM tracks of the flux image into an extended dsk.
+// The revolution with the most sectors gives the layout of each track,
+// the data of a sector comes from the first revolution with a good CRC.
+// The CRC errors and deleted data marks are stored in the FDC status of
+// the sectors and the sector positions in the Offset-Info block.
+// Tracks without any sector are unformatted.
+func (img *Image) ToDSK() (*dsk.DSK, error) {
+	if len(img.Tracks) == 0 {
+		return nil, ErrorNoTrack
+	}
+	cyls, heads := 0, 1
+	for _, t := range img.Tracks {
+		cyls = max(cyls, t.Cylinder+1)
+		heads = max(heads, t.Head+1)
+	}
+	if cyls > 0xFF || heads > 2 {
+		return nil, fmt.Errorf("%w: %d cylinders, %d heads", ErrorNoTrack, cyls, heads)
+	}
+	d := dsk.FormatDsk(9, uint8(cyls), uint8(heads), dsk.DataFormat, dsk.EXTENDED_DSK_TYPE)
+	for i := range d.Tracks {
+		d.Tracks[i] = dsk.CPCEMUTrack{}
+	}
+	d.OffsetInfo = make([]dsk.TrackOffsets, len(d.Tracks))
+	var found bool
+	for _, t := range img.Tracks {
+		if len(t.Revolutions) == 0 {
+			continue
+		}
+		revs, ref, length := decodeTrack(t, cellDD)
+		rate := dsk.DataRateDD
+		if hd, hdRef, hdLength := decodeTrack(t, cellHD); len(hd[hdRef]) > len(revs[ref]) {
+			revs, ref, length, rate = hd, hdRef, hdLength, dsk.DataRateHD
+		}
+		if len(revs[ref]) == 0 {
+			continue
+		}
+		found = true
+		i := d.TrackIndex(t.Cylinder, t.Head)
+		track, offsets := fdc.BuildTrack(t.Cylinder, t.Head, mergeRevolutions(revs, ref), rate, dsk.RecordingMFM)
+		d.Tracks[i] = track
+		d.OffsetInfo[i] = dsk.TrackOffsets{Length: uint16(min(length, 0xFFFF)), Sectors: offsets}
+	}
+	if !found {
+		return nil, ErrorNoSector
+	}
+	return d, nil
+}
@@ -0,0 +1,330 @@
+package flux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/dsk/dsk"
+	"github.com/jeromelesaux/dsk/internal/fdc"
+	"github.com/stretchr/testify/require"
+)
+
+// --- helpers ---
+
+// testSector is a sector to write on a synthetic track
+type testSector struct {
+	id      [4]byte
+	data    []byte
+	deleted bool
+	badCRC  bool
+}
+
+// mfmTrack returns the MFM cells of a track of 6250 bytes holding the sectors
+func mfmTrack(sectors []testSector) []byte {
+	cells := make([]byte, 0, 6250*16)
+	var prev byte
+	put := func(data ...byte) {
+		for _, b := range data {
+			for k := 7; k >= 0; k-- {
+				bit := (b >> k) & 1
+				var clock byte
+				if bit == 0 && prev == 0 {
+					clock = 1
+				}
+				cells = append(cells, clock, bit)
+				prev = bit
+			}
+		}
+	}
+	sync := func() {
+		for range 3 {
+			for k := 15; k >= 0; k-- {
+				cells = append(cells, byte(uint16(0x4489)>>k&1))
+			}
+		}
+		prev = 1
+	}
+	fill := func(b byte, n int) {
+		put(bytes.Repeat([]byte{b}, n)...)
+	}
+	fill(0x4E, 80)
+	for _, s := range sectors {
+		fill(0x00, 12)
+		sync()
+		id := append([]byte{0xFE}, s.id[:]...)
+		crc := fdc.CRC16(append([]byte{0xA1, 0xA1, 0xA1}, id...))
+		put(id...)
+		put(byte(crc>>8), byte(crc))
+		fill(0x4E, 22)
+		fill(0x00, 12)
+		sync()
+		mark := byte(0xFB)
+		if s.deleted {
+			mark = 0xF8
+		}
+		field := append([]byte{mark}, s.data...)
+		crc = fdc.CRC16(append([]byte{0xA1, 0xA1, 0xA1}, field...))
+		if s.badCRC {
+			crc ^= 0xFFFF
+		}
+		put(field...)
+		put(byte(crc>>8), byte(crc))
+		fill(0x4E, 0x2A)
+	}
+	for len(cells) < 6250*16 {
+		fill(0x4E, 1)
+	}
+	return cells
+}
+
+// fluxIntervals returns the intervals in nanoseconds between the transitions
+// of the cells, with some jitter
+func fluxIntervals(cells []byte, cell float64, rng *rand.Rand) []uint32 {
+	flux := make([]uint32, 0, len(cells)/2)
+	var n int
+	for _, c := range cells {
+		n++
+		if c == 1 {
+			flux = append(flux, uint32(float64(n)*cell+float64(rng.Intn(200)-100)))
+			n = 0
+		}
+	}
+	return flux
+}
+
+// dosSectors returns the 9 sectors #C1 to #C9 of a data format track
+func dosSectors(cyl int, rng *rand.Rand) []testSector {
+	sectors := make([]testSector, 9)
+	for i := range sectors {
+		sectors[i].id = [4]byte{byte(cyl), 0, byte(0xC1 + i), 2}
+		sectors[i].data = make([]byte, 512)
+		rng.Read(sectors[i].data)
+	}
+	return sectors
+}
+
+// scpImage returns a SuperCard Pro image of the revolutions of the tracks
+func scpImage(tracks map[int][][]uint32, revolutions int) []byte {
+	header := make([]byte, scpHeaderSize+scpTrackCount*4)
+	copy(header, "SCP")
+	header[5] = byte(revolutions)
+	content := header
+	for n := 0; n < scpTrackCount; n++ {
+		revs, ok := tracks[n]
+		if !ok {
+			continue
+		}
+		offset := len(content)
+		binary.LittleEndian.PutUint32(content[scpHeaderSize+n*4:], uint32(offset))
+		trk := append([]byte("TRK"), byte(n))
+		trk = append(trk, make([]byte, revolutions*scpRevHeader)...)
+		for rev, flux := range revs {
+			h := trk[scpTrackHeader+rev*scpRevHeader:]
+			binary.LittleEndian.PutUint32(h[4:], uint32(len(flux)))
+			binary.LittleEndian.PutUint32(h[8:], uint32(len(trk)))
+			for _, f := range flux {
+				trk = binary.BigEndian.AppendUint16(trk, uint16((f+scpBaseTick/2)/scpBaseTick))
+			}
+		}
+		content = append(content, trk...)
+	}
+	return content
+}
+
+// kryoFluxStream returns the KryoFlux stream of the revolutions, an index
+// pulse before each revolution and after the last one
+func kryoFluxStream(revs [][]uint32) []byte {
+	stream := make([]byte, 0)
+	var pos int
+	index := func() {
+		stream = append(stream, kfOOB, kfOOBIndex, 12, 0)
+		stream = binary.LittleEndian.AppendUint32(stream, uint32(pos))
+		stream = append(stream, make([]byte, 8)...)
+	}
+	for _, flux := range revs {
+		index()
+		for _, f := range flux {
+			v := uint32(float64(f) * kryoFluxSampleClock / 1e9)
+			switch {
+			case v >= 0x0E && v <= 0xFF:
+				stream = append(stream, byte(v))
+				pos++
+			case v < 0x800:
+				stream = append(stream, byte(v>>8), byte(v))
+				pos += 2
+			default:
+				stream = append(stream, kfFlux3, byte(v>>8), byte(v))
+				pos += 3
+			}
+		}
+	}
+	index()
+	return append(stream, kfOOB, kfOOBEOF, 0x0D, 0x0D)
+}
+
+func requireSectors(t *testing.T, d *dsk.DSK, cyl, head int, sectors []testSector) {
+	t.Helper()
+	track := d.Track(cyl, head)
+	require.NotNil(t, track)
+	require.Equal(t, len(sectors), int(track.NbSect))
+	for i, s := range sectors {
+		require.Equal(t, s.id[2], track.Sect[i].R)
+		data, err := d.ReadSector(cyl, head, s.id[2])
+		require.NoError(t, err)
+		require.Equal(t, s.data, data, "cylinder %d sector #%.2X", cyl, s.id[2])
+	}
+}
+
+// --- tests ---
+
+func TestDecodeCells_SpeedVariation(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	sectors := dosSectors(0, rng)
+	cells := mfmTrack(sectors)
+	// a drive 3% too slow
+	found := fdc.MFMSectors(decodeCells(fluxIntervals(cells, cellDD*1.03, rng), cellDD))
+	require.Len(t, found, 9)
+	for i, s := range found {
+		require.True(t, s.IDCRC)
+		require.True(t, s.DataCRC)
+		require.Equal(t, sectors[i].data, s.Data)
+	}
+}
+
+func TestReadSCP_ToDSK(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	tracks := make(map[int][][]uint32)
+	expected := make([][]testSector, 2)
+	for cyl := 0; cyl < 2; cyl++ {
+		expected[cyl] = dosSectors(cyl, rng)
+		cells := mfmTrack(expected[cyl])
+		tracks[cyl*2] = [][]uint32{fluxIntervals(cells, cellDD, rng), fluxIntervals(cells, cellDD, rng)}
+	}
+	img, err := ReadSCP(bytes.NewReader(scpImage(tracks, 2)))
+	require.NoError(t, err)
+	require.Len(t, img.Tracks, 2)
+	require.Len(t, img.Tracks[1].Revolutions, 2)
+	require.Equal(t, 1, img.Tracks[1].Cylinder)
+
+	d, err := img.ToDSK()
+	require.NoError(t, err)
+	require.True(t, d.Extended)
+	require.Equal(t, uint8(2), d.Entry.NbTracks)
+	for cyl := 0; cyl < 2; cyl++ {
+		requireSectors(t, d, cyl, 0, expected[cyl])
+		track := d.Track(cyl, 0)
+		require.Equal(t, dsk.DataRateDD, track.DataRate)
+		require.Equal(t, dsk.RecordingMFM, track.RecordingMode)
+		for s := 0; s < int(track.NbSect); s++ {
+			require.Zero(t, track.Sect[s].Un1)
+		}
+	}
+	offsets, ok := d.SectorOffsets(0)
+	require.True(t, ok)
+	require.InDelta(t, 6250, int(offsets.Length), 2)
+	require.Equal(t, uint16(80+12), offsets.Sectors[0])
+
+	// the dsk is written and read back as any extended dsk
+	var buf bytes.Buffer
+	require.NoError(t, d.Write(&buf))
+	var back dsk.DSK
+	require.NoError(t, back.Read(&buf))
+	requireSectors(t, &back, 1, 0, expected[1])
+}
+
+func TestOpenKryoFlux_ToDSK(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	dir := t.TempDir()
+	expected := make([][]testSector, 2)
+	for head := 0; head < 2; head++ {
+		expected[head] = dosSectors(0, rng)
+		for i := range expected[head] {
+			expected[head][i].id[1] = byte(head)
+		}
+		cells := mfmTrack(expected[head])
+		revs := [][]uint32{fluxIntervals(cells, cellDD, rng), fluxIntervals(cells, cellDD, rng), fluxIntervals(cells, cellDD, rng)}
+		name := filepath.Join(dir, "track00."+string(rune('0'+head))+".raw")
+		require.NoError(t, os.WriteFile(name, kryoFluxStream(revs), 0644))
+	}
+	img, err := Open(dir)
+	require.NoError(t, err)
+	require.Len(t, img.Tracks, 2)
+	require.Len(t, img.Tracks[0].Revolutions, 3)
+
+	d, err := img.ToDSK()
+	require.NoError(t, err)
+	require.Equal(t, uint8(2), d.Entry.NbHeads)
+	requireSectors(t, d, 0, 0, expected[0])
+	requireSectors(t, d, 0, 1, expected[1])
+}
+
+func TestToDSK_WeakSector(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	sectors := dosSectors(0, rng)
+	revs := make([][]uint32, 0)
+	for rev := 0; rev < 3; rev++ {
+		weak := append([]byte{}, sectors[3].data...)
+		weak[100] = byte(rev)
+		revSectors := append([]testSector{}, sectors...)
+		revSectors[3] = testSector{id: sectors[3].id, data: weak, badCRC: true}
+		revs = append(revs, fluxIntervals(mfmTrack(revSectors), cellDD, rng))
+	}
+	d, err := (&Image{Tracks: []Track{{Revolutions: revs}}}).ToDSK()
+	require.NoError(t, err)
+	track := d.Track(0, 0)
+	require.True(t, track.IsWeakSector(3))
+	require.Len(t, track.SectorCopies(3), 3)
+	require.True(t, track.WeakBytes(3)[100])
+	require.False(t, track.WeakBytes(3)[99])
+	require.Equal(t, uint16(0x2020), track.Sect[3].Un1)
+	require.False(t, track.IsWeakSector(2))
+}
+
+func TestToDSK_StatusBits(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	sectors := dosSectors(0, rng)
+	sectors[1].deleted = true
+	sectors[2].badCRC = true
+	cells := mfmTrack(sectors)
+	revs := [][]uint32{fluxIntervals(cells, cellDD, rng), fluxIntervals(cells, cellDD, rng)}
+	d, err := (&Image{Tracks: []Track{{Revolutions: revs}}}).ToDSK()
+	require.NoError(t, err)
+	track := d.Track(0, 0)
+	require.Equal(t, uint16(0x4000), track.Sect[1].Un1)
+	// the same bad data on every revolution is a CRC error, not a weak sector
+	require.Equal(t, uint16(0x2020), track.Sect[2].Un1)
+	require.False(t, track.IsWeakSector(2))
+	require.Equal(t, sectors[2].data, track.SectorCopies(2)[0])
+}
+
+func TestToDSK_HighDensity(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	sectors := dosSectors(0, rng)
+	cells := mfmTrack(sectors)
+	d, err := (&Image{Tracks: []Track{{Revolutions: [][]uint32{fluxIntervals(cells, cellHD, rng)}}}}).ToDSK()
+	require.NoError(t, err)
+	require.Equal(t, dsk.DataRateHD, d.Track(0, 0).DataRate)
+	requireSectors(t, d, 0, 0, sectors)
+}
+
+func TestToDSK_Errors(t *testing.T) {
+	_, err := (&Image{}).ToDSK()
+	require.ErrorIs(t, err, ErrorNoTrack)
+
+	noise := make([]uint32, 5000)
+	for i := range noise {
+		noise[i] = 4000
+	}
+	_, err = (&Image{Tracks: []Track{{Revolutions: [][]uint32{noise}}}}).ToDSK()
+	require.ErrorIs(t, err, ErrorNoSector)
+
+	_, err = ReadSCP(bytes.NewReader([]byte("not a flux image")))
+	require.ErrorIs(t, err, ErrorSCPFormat)
+
+	_, err = ReadKryoFluxStream(bytes.NewReader([]byte{kfOOB, kfOOBEOF, 0x0D, 0x0D}))
+	require.ErrorIs(t, err, ErrorKryoFluxFormat)
+}
@@ -0,0 +1,150 @@
+package flux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var ErrorKryoFluxFormat = errors.New("not a KryoFlux stream")
+
+// sample clock of the KryoFlux board in Hz
+const kryoFluxSampleClock = 18432000.0 * 73 / 14 / 2
+
+// KryoFlux stream blocks
+const (
+	kfFlux2Max = 0x07 // 0x00-0x07: flux on two bytes
+	kfNop1     = 0x08
+	kfNop2     = 0x09
+	kfNop3     = 0x0A
+	kfOvl16    = 0x0B
+	kfFlux3    = 0x0C
+	kfOOB      = 0x0D // 0x0E-0xFF: flux on one byte
+)
+
+// KryoFlux out of band blocks
+const (
+	kfOOBIndex     = 0x02
+	kfOOBStreamEnd = 0x03
+	kfOOBEOF       = 0x0D
+)
+
+// OpenKryoFlux reads the KryoFlux streams of the directory, one file
+// trackCC.H.raw for the head H of the cylinder CC
+func OpenKryoFlux(dir string) (*Image, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "track*.raw"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	img := &Image{}
+	for _, file := range files {
+		var t Track
+		if _, err := fmt.Sscanf(filepath.Base(file), "track%d.%d.raw", &t.Cylinder, &t.Head); err != nil {
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		t.Revolutions, err = ReadKryoFluxStream(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		img.Tracks = append(img.Tracks, t)
+	}
+	if len(img.Tracks) == 0 {
+		return nil, fmt.Errorf("%w: no trackCC.H.raw file in %s", ErrorNoTrack, dir)
+	}
+	return img, nil
+}
+
+// ReadKryoFluxStream reads the KryoFlux stream of a track and returns the
+// flux intervals in nanoseconds of each revolution between two index pulses
+func ReadKryoFluxStream(r io.Reader) ([][]uint32, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	flux := make([]uint32, 0, len(content))
+	positions := make([]int, 0, len(content)) // stream position of each flux
+	indexes := make([]int, 0)                 // stream position of each index pulse
+	var overflow uint32
+	var pos int // position in the stream, out of band blocks excluded
+	addFlux := func(v uint32) {
+		flux = append(flux, uint32(float64(overflow+v)*1e9/kryoFluxSampleClock))
+		positions = append(positions, pos)
+		overflow = 0
+	}
+	i := 0
+loop:
+	for i < len(content) {
+		b := content[i]
+		switch {
+		case b <= kfFlux2Max:
+			if i+2 > len(content) {
+				return nil, fmt.Errorf("%w: truncated flux at %d", ErrorKryoFluxFormat, i)
+			}
+			addFlux(uint32(b)<<8 | uint32(content[i+1]))
+			i, pos = i+2, pos+2
+		case b == kfNop1, b == kfNop2, b == kfNop3:
+			n := int(b-kfNop1) + 1
+			i, pos = i+n, pos+n
+		case b == kfOvl16:
+			overflow += 0x10000
+			i, pos = i+1, pos+1
+		case b == kfFlux3:
+			if i+3 > len(content) {
+				return nil, fmt.Errorf("%w: truncated flux at %d", ErrorKryoFluxFormat, i)
+			}
+			addFlux(uint32(binary.BigEndian.Uint16(content[i+1:])))
+			i, pos = i+3, pos+3
+		case b == kfOOB:
+			if i+4 > len(content) {
+				return nil, fmt.Errorf("%w: truncated block at %d", ErrorKryoFluxFormat, i)
+			}
+			kind := content[i+1]
+			if kind == kfOOBEOF {
+				break loop
+			}
+			size := int(binary.LittleEndian.Uint16(content[i+2:]))
+			if i+4+size > len(content) {
+				return nil, fmt.Errorf("%w: truncated block at %d", ErrorKryoFluxFormat, i)
+			}
+			switch kind {
+			case kfOOBIndex:
+				if size < 4 {
+					return nil, fmt.Errorf("%w: index block of %d bytes", ErrorKryoFluxFormat, size)
+				}
+				indexes = append(indexes, int(binary.LittleEndian.Uint32(content[i+4:])))
+			case kfOOBStreamEnd:
+				break loop
+			}
+			i += 4 + size
+		default:
+			addFlux(uint32(b))
+			i, pos = i+1, pos+1
+		}
+	}
+	if len(flux) == 0 {
+		return nil, fmt.Errorf("%w: no flux", ErrorKryoFluxFormat)
+	}
+	// the flux whose block starts at the stream position of an index pulse
+	// is the first one of a revolution
+	starts := make([]int, 0, len(indexes))
+	for _, index := range indexes {
+		starts = append(starts, sort.SearchInts(positions, index))
+	}
+	revolutions := make([][]uint32, 0)
+	for k := 0; k+1 < len(starts); k++ {
+		if starts[k+1] > starts[k] {
+			revolutions = append(revolutions, flux[starts[k]:starts[k+1]])
+		}
+	}
+	return revolutions, nil
+}
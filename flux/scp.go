@@ -0,0 +1,95 @@
+package flux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var ErrorSCPFormat = errors.New("not a SuperCard Pro image")
+
+// SuperCard Pro file layout
+const (
+	scpHeaderSize  = 0x10
+	scpTrackCount  = 168 // entries of the track offsets table
+	scpBaseTick    = 25  // nanoseconds
+	scpTrackHeader = 4   // "TRK" and the track number
+	scpRevHeader   = 12  // index time, number of flux, data offset
+)
+
+// OpenSCP reads the SuperCard Pro image of the file
+func OpenSCP(path string) (*Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadSCP(f)
+}
+
+// ReadSCP reads a SuperCard Pro image. Track n of the image is the head n%2
+// of the cylinder n/2, its flux times are counted in ticks of 25ns times
+// the resolution of the header.
+func ReadSCP(r io.Reader) (*Image, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < scpHeaderSize+scpTrackCount*4 || string(content[0:3]) != "SCP" {
+		return nil, ErrorSCPFormat
+	}
+	revolutions := int(content[5])
+	cellWidth := int(content[9])
+	if cellWidth == 0 {
+		cellWidth = 16
+	}
+	if cellWidth != 8 && cellWidth != 16 {
+		return nil, fmt.Errorf("%w: flux values of %d bits", ErrorSCPFormat, cellWidth)
+	}
+	tick := uint32(scpBaseTick * (int(content[11]) + 1))
+	img := &Image{}
+	for n := 0; n < scpTrackCount; n++ {
+		offset := int(binary.LittleEndian.Uint32(content[scpHeaderSize+n*4:]))
+		if offset == 0 {
+			continue
+		}
+		if offset+scpTrackHeader+revolutions*scpRevHeader > len(content) || string(content[offset:offset+3]) != "TRK" {
+			return nil, fmt.Errorf("%w: track %d header at %d", ErrorSCPFormat, n, offset)
+		}
+		t := Track{Cylinder: int(content[offset+3]) / 2, Head: int(content[offset+3]) % 2}
+		for rev := 0; rev < revolutions; rev++ {
+			h := content[offset+scpTrackHeader+rev*scpRevHeader:]
+			count := int(binary.LittleEndian.Uint32(h[4:]))
+			start := offset + int(binary.LittleEndian.Uint32(h[8:]))
+			end := start + count*cellWidth/8
+			if start < offset || end > len(content) {
+				return nil, fmt.Errorf("%w: track %d revolution %d out of the file", ErrorSCPFormat, n, rev)
+			}
+			t.Revolutions = append(t.Revolutions, scpFlux(content[start:end], cellWidth, tick))
+		}
+		img.Tracks = append(img.Tracks, t)
+	}
+	return img, nil
+}
+
+// scpFlux returns the flux intervals in nanoseconds of the data of a
+// revolution, a value of 0 adds its whole range to the next one
+func scpFlux(data []byte, cellWidth int, tick uint32) []uint32 {
+	flux := make([]uint32, 0, len(data)*8/cellWidth)
+	var overflow uint32
+	for i := 0; i < len(data); i += cellWidth / 8 {
+		v := uint32(data[i])
+		if cellWidth == 16 {
+			v = uint32(binary.BigEndian.Uint16(data[i:]))
+		}
+		if v == 0 {
+			overflow += 1 << cellWidth
+			continue
+		}
+		flux = append(flux, (overflow+v)*tick)
+		overflow = 0
+	}
+	return flux
+}
@@ -0,0 +1,167 @@
+package hfe
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultRPM = 300
+	// tolerance of the bitstream length around the expected length, in percent
+	lengthTolerance = 5
+)
+
+// SectorReport is a sector decoded from the bitstream of a track
+type SectorReport struct {
+	C, H, R, N uint8
+	Offset     int // offset of the ID address mark in the decoded bytes
+	Size       int // bytes of data read
+	IDCRC      bool
+	HasData    bool // false when the data address mark is missing
+	Deleted    bool
+	DataCRC    bool
+}
+
+// Problems returns the errors of the sector
+func (s SectorReport) Problems() []string {
+	problems := make([]string, 0)
+	if !s.IDCRC {
+		problems = append(problems, "ID CRC error")
+	}
+	switch {
+	case !s.HasData:
+		problems = append(problems, "missing data address mark")
+	case s.Size != 128<<(s.N&7):
+		problems = append(problems, fmt.Sprintf("%d bytes of %d read", s.Size, 128<<(s.N&7)))
+	case !s.DataCRC:
+		problems = append(problems, "data CRC error")
+	}
+	return problems
+}
+
+func (s SectorReport) String() string {
+	str := fmt.Sprintf("C:%d H:%d R:#%.2X N:%d at %d", s.C, s.H, s.R, s.N, s.Offset)
+	if s.Deleted {
+		str += " deleted"
+	}
+	if problems := s.Problems(); len(problems) > 0 {
+		return str + ": " + strings.Join(problems, ", ")
+	}
+	return str + ": ok"
+}
+
+// TrackReport is the decoding of a side of a track
+type TrackReport struct {
+	Cylinder int
+	Head     int
+	BitRate  uint16 // kbps
+	FM       bool   // sectors decoded in FM
+	Length   int    // bytes of the bitstream
+	Expected int    // bytes of the bitstream of a revolution at the bit rate and RPM
+	Sectors  []SectorReport
+}
+
+// Problems returns the errors of the track and of its sectors
+func (t TrackReport) Problems() []string {
+	problems := make([]string, 0)
+	if len(t.Sectors) == 0 {
+		problems = append(problems, "no sector found")
+	}
+	if diff := t.Length - t.Expected; diff*100 > t.Expected*lengthTolerance || -diff*100 > t.Expected*lengthTolerance {
+		problems = append(problems, fmt.Sprintf("bitstream of %d bytes instead of %d", t.Length, t.Expected))
+	}
+	for _, s := range t.Sectors {
+		if p := s.Problems(); len(p) > 0 {
+			problems = append(problems, fmt.Sprintf("sector R:#%.2X %s", s.R, strings.Join(p, ", ")))
+		}
+	}
+	return problems
+}
+
+// OK is true when the track has no error
+func (t TrackReport) OK() bool {
+	return len(t.Problems()) == 0
+}
+
+func (t TrackReport) String() string {
+	var sb strings.Builder
+	encoding := "MFM"
+	if t.FM {
+		encoding = "FM"
+	}
+	fmt.Fprintf(&sb, "track %.2d side %d: %d %s sectors, %d kbps, %d bytes of bitstream (expected %d)\n",
+		t.Cylinder, t.Head, len(t.Sectors), encoding, t.BitRate, t.Length, t.Expected)
+	for _, s := range t.Sectors {
+		fmt.Fprintf(&sb, "  %s\n", s.String())
+	}
+	return sb.String()
+}
+
+// Report is the decoding of every track of an HFE image
+type Report struct {
+	Tracks []TrackReport
+}
+
+// OK is true when no track has an error
+func (r Report) OK() bool {
+	return r.Errors() == 0
+}
+
+// Errors returns the number of tracks with an error
+func (r Report) Errors() int {
+	var n int
+	for _, t := range r.Tracks {
+		if !t.OK() {
+			n++
+		}
+	}
+	return n
+}
+
+// Analyze decodes every side of every track of the HFE image in MFM or FM and reports
+// the sectors found with their IDs, sizes and CRC status, the missing data
+// address marks and the length of the bitstream against the length of a
+// revolution at the bit rate and RPM of the image.
+func Analyze(h *HFE) Report {
+	numSides := max(int(h.Header.NumSides), 1)
+	rpm := int(h.Header.FloppyRPM)
+	if rpm == 0 {
+		rpm = defaultRPM
+	}
+	var r Report
+	for t, track := range h.Tracks {
+		rate := h.Header.BitRate
+		if track.BitRate != 0 {
+			rate = track.BitRate
+		}
+		sides := [][]byte{track.Side0, track.Side1}
+		for head := 0; head < numSides && head < len(sides); head++ {
+			tr := TrackReport{
+				Cylinder: t,
+				Head:     head,
+				BitRate:  rate,
+				Length:   len(sides[head]),
+				// two cells of one bit per data bit
+				Expected: int(rate) * 1000 * 2 * 60 / rpm / 8,
+			}
+			sectors, fm, _ := decodeSide(sides[head], h.isFM(t, head))
+			tr.FM = fm
+			for _, s := range sectors {
+				tr.Sectors = append(tr.Sectors, SectorReport{
+					C:       s.ID[0],
+					H:       s.ID[1],
+					R:       s.ID[2],
+					N:       s.ID[3],
+					Offset:  s.Offset,
+					Size:    len(s.Data),
+					IDCRC:   s.IDCRC,
+					HasData: s.HasData,
+					Deleted: s.Deleted,
+					DataCRC: s.DataCRC,
+				})
+			}
+			r.Tracks = append(r.Tracks, tr)
+		}
+	}
+	return r
+}
@@ -0,0 +1,53 @@
+package hfe
+
+import (
+	"testing"
+
+	"github.com/jeromelesaux/dsk/internal/fdc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyze_Clean(t *testing.T) {
+	d := makeDSK(3, 2)
+	h, err := Open(writeHFE(t, d))
+	require.NoError(t, err)
+	r := Analyze(h)
+	require.True(t, r.OK(), "%v", r.Tracks)
+	require.Len(t, r.Tracks, 6)
+	for _, tr := range r.Tracks {
+		require.Len(t, tr.Sectors, 9)
+		require.Equal(t, 12500, tr.Expected)
+		require.Equal(t, tr.Expected, tr.Length)
+		require.Equal(t, uint8(0xC1), tr.Sectors[0].R)
+		require.Equal(t, 512, tr.Sectors[0].Size)
+	}
+	require.Equal(t, 1, r.Tracks[3].Head)
+	require.Equal(t, 1, r.Tracks[3].Cylinder)
+}
+
+func TestAnalyze_Errors(t *testing.T) {
+	d := makeDSK(2, 1)
+	sect := &d.Tracks[0].Sect
+	sect[2].Un1 = fdc.ST1DataError | uint16(fdc.ST2DataErrorInData)<<8
+	sect[3].Un1 = fdc.ST1DataError
+	sect[4].Un1 = uint16(fdc.ST2ControlMark) << 8
+	h, err := Open(writeHFE(t, d))
+	require.NoError(t, err)
+	// a dump stopped before the end of the revolution
+	h.Tracks[1].Side0 = h.Tracks[1].Side0[:len(h.Tracks[1].Side0)/2]
+
+	r := Analyze(h)
+	require.False(t, r.OK())
+	require.Equal(t, 2, r.Errors())
+
+	sectors := r.Tracks[0].Sectors
+	require.False(t, sectors[2].DataCRC)
+	require.True(t, sectors[2].IDCRC)
+	require.False(t, sectors[3].IDCRC)
+	require.True(t, sectors[4].Deleted)
+	require.Empty(t, sectors[4].Problems())
+	require.Equal(t, []string{"sector R:#C2 data CRC error", "sector R:#C7 ID CRC error"}, r.Tracks[0].Problems())
+
+	require.Contains(t, r.Tracks[1].Problems(), "bitstream of 6250 bytes instead of 12500")
+	require.Contains(t, r.Tracks[1].String(), "track 01 side 0")
+}
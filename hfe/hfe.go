@@ -2,14 +2,43 @@ package hfe
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math/bits"
 	"os"
 
 	extdsk "github.com/jeromelesaux/dsk/dsk"
+	"github.com/jeromelesaux/dsk/internal/fdc"
 )
 
 const blockSize = 512
 
+// file signatures
+const (
+	signatureV1 = "HXCPICFE"
+	signatureV3 = "HXCHFEV3"
+)
+
+// HFE v3 opcodes, a byte of the track stream starting with 4 set bits
+const (
+	opNop        = 0xF0
+	opSetIndex   = 0xF1
+	opSetBitRate = 0xF2 // followed by the bit rate: 18000 / kbps
+	opSkipBits   = 0xF3 // followed by the number of bits to skip in the next byte
+	opRand       = 0xF4 // a byte read randomly
+)
+
+var ErrorTrackTooLong = errors.New("track too long for the HFE track list")
+
+// track encodings of the header
+const (
+	EncodingISOIBMMFM byte = 0x00
+	EncodingAmigaMFM  byte = 0x01
+	EncodingISOIBMFM  byte = 0x02
+	EncodingEmuFM     byte = 0x03
+	EncodingUnknown   byte = 0xFF
+)
+
 type Header struct {
 	Signature       string
 	FormatRevision  byte
@@ -21,6 +50,11 @@ type Header struct {
 	FloppyInterface byte
 	MCUVersion      byte // byte at index 17
 	TrackListOffset uint16
+	// encoding of the sides of track 0 when their alternate encoding is 0x00
+	Track0S0AltEncoding byte
+	Track0S0Encoding    byte
+	Track0S1AltEncoding byte
+	Track0S1Encoding    byte
 }
 
 type TrackEntry struct {
@@ -29,8 +63,9 @@ type TrackEntry struct {
 }
 
 type Track struct {
-	Side0 []byte
-	Side1 []byte
+	Side0   []byte
+	Side1   []byte
+	BitRate uint16 // kbps set by the HFE v3 opcodes of the track, 0 for the bit rate of the header
 }
 
 type HFE struct {
@@ -47,7 +82,7 @@ func Open(path string) (*HFE, error) {
 	if len(data) < blockSize {
 		return nil, fmt.Errorf("file too small")
 	}
-	if string(data[:8]) != "HXCPICFE" {
+	if string(data[:8]) != signatureV1 && string(data[:8]) != signatureV3 {
 		return nil, fmt.Errorf("invalid HFE signature")
 	}
 
@@ -63,6 +98,11 @@ func Open(path string) (*HFE, error) {
 		FloppyInterface: data[16],
 		MCUVersion:      data[17],
 		TrackListOffset: binary.LittleEndian.Uint16(data[18:20]),
+
+		Track0S0AltEncoding: data[22],
+		Track0S0Encoding:    data[23],
+		Track0S1AltEncoding: data[24],
+		Track0S1Encoding:    data[25],
 	}
 
 	lutOffset := int(h.Header.TrackListOffset) * blockSize
@@ -110,6 +150,17 @@ func Open(path string) (*HFE, error) {
 			side1 = side1[:perSideLen]
 		}
 
+		if h.Header.Signature == signatureV3 {
+			var rate0, rate1 uint16
+			side0, rate0 = decodeV3(side0)
+			side1, rate1 = decodeV3(side1)
+			t := Track{Side0: side0, BitRate: max(rate0, rate1)}
+			if numSides > 1 {
+				t.Side1 = side1
+			}
+			h.Tracks = append(h.Tracks, t)
+			continue
+		}
 		t := Track{Side0: side0}
 		if numSides > 1 {
 			t.Side1 = side1
@@ -120,105 +171,157 @@ func Open(path string) (*HFE, error) {
 	return h, nil
 }
 
-// mfmDecode extracts data bits from an HFE MFM bitstream.
-// HFE stores bits LSB-first per byte when the track data is written to the file.
-func mfmDecode(mfm []byte) []byte {
-	totalBits := len(mfm) * 8
-	out := make([]byte, 0, totalBits/16)
-	var current byte
-	bitCount := 0
-	for i := 1; i < totalBits; i += 2 { // start at 1: skip clock bits, take data bits
-		byteIdx := i / 8
-		bitPos := i % 8 // LSB-first
-		if byteIdx >= len(mfm) {
-			break
-		}
-		bit := (mfm[byteIdx] >> uint(bitPos)) & 1
-		current = (current << 1) | bit
-		bitCount++
-		if bitCount == 8 {
-			out = append(out, current)
-			current = 0
-			bitCount = 0
+// decodeV3 returns the plain bitstream of an HFE v3 track stream and the
+// bit rate in kbps of its last set bit rate opcode. The skipped bits are
+// removed, shifting the following bits out of the phase of the bytes, and
+// the random bytes are read as bytes without flux transition.
+func decodeV3(stream []byte) ([]byte, uint16) {
+	out := make([]byte, 0, len(stream))
+	var rate uint16
+	var count int
+	appendBits := func(b byte, n int) { // the last n bits of b, LSB-first
+		for i := 8 - n; i < 8; i++ {
+			if count%8 == 0 {
+				out = append(out, 0)
+			}
+			out[count/8] |= (b >> uint(i) & 1) << uint(count%8)
+			count++
 		}
 	}
-	return out
-}
-
-// extractSectorData scans a decoded MFM byte stream and returns the raw sector data in order
-func extractSectorData(raw []byte) []byte {
-	var result []byte
-	i := 0
-	for i < len(raw)-10 {
-		// IDAM: A1 A1 A1 FE
-		if raw[i] == 0xA1 && i+3 < len(raw) && raw[i+1] == 0xA1 && raw[i+2] == 0xA1 && raw[i+3] == 0xFE {
-			i += 4
-			if i+4 > len(raw) {
-				break
-			}
-			sectorN := raw[i+3]
-			sectorSize := int(128) << sectorN
-			i += 4 + 2 // skip C H R N + CRC
-
-			// find DAM: A1 A1 A1 FB/F8
-			found := false
-			for j := 0; j < 60 && i+j+3 < len(raw); j++ {
-				if raw[i+j] == 0xA1 && raw[i+j+1] == 0xA1 && raw[i+j+2] == 0xA1 &&
-					(raw[i+j+3] == 0xFB || raw[i+j+3] == 0xF8) {
-					i = i + j + 4
-					found = true
-					break
-				}
-			}
-			if !found {
-				continue
-			}
-			if i+sectorSize <= len(raw) {
-				result = append(result, raw[i:i+sectorSize]...)
+	for i := 0; i < len(stream); i++ {
+		op := bits.Reverse8(stream[i])
+		if op&0xF0 != 0xF0 {
+			appendBits(stream[i], 8)
+			continue
+		}
+		switch op {
+		case opSetBitRate:
+			if i+1 < len(stream) && stream[i+1] != 0 {
+				rate = uint16(18000 / int(bits.Reverse8(stream[i+1])))
 			}
-			i += sectorSize
-		} else {
 			i++
+		case opSkipBits:
+			if i+2 < len(stream) {
+				skip := min(int(bits.Reverse8(stream[i+1])), 8)
+				appendBits(stream[i+2], 8-skip)
+			}
+			i += 2
+		case opRand:
+			appendBits(0, 8)
 		}
 	}
-	return result
+	return out, rate
 }
 
-// ToDSK converts the HFE image into a *dsk.DSK using the external jeromelesaux/dsk package.
-// It decodes the MFM bitstream of each track and injects the sector data.
-func (h *HFE) ToDSK() (*extdsk.DSK, error) {
-	numTracks := int(h.Header.NumTracks)
-	numSides := max(int(h.Header.NumSides), 1)
+// streamCells returns the cells of an HFE bitstream, one byte per bit of
+// the stream, LSB-first per byte
+func streamCells(stream []byte) []byte {
+	cells := make([]byte, len(stream)*8)
+	for i := range cells {
+		cells[i] = stream[i/8] >> uint(i%8) & 1
+	}
+	return cells
+}
+
+// decodeSectors returns the sectors of an HFE MFM bitstream, in their
+// order on the track. The address marks are found at any bit of the stream.
+func decodeSectors(stream []byte) []fdc.Sector {
+	return fdc.MFMSectors(streamCells(stream))
+}
+
+// decodeFMSectors returns the sectors of an HFE FM bitstream, each FM cell
+// lasting two bits of the stream. The address marks are found at any bit of
+// the stream.
+func decodeFMSectors(stream []byte) []fdc.Sector {
+	return fdc.FMSectors(streamCells(stream))
+}
 
-	// Detect sector count from first track
-	nbSect := uint8(9)
-	if numTracks > 0 && len(h.Tracks) > 0 {
-		raw := mfmDecode(h.Tracks[0].Side0)
-		count := countSectors(raw)
-		if count > 0 {
-			nbSect = uint8(count)
+// decodeSide returns the sectors of the bitstream of a side decoded with
+// the encoding of the header, with the other encoding when it finds none,
+// if the sectors are FM and the number of decoded bytes
+func decodeSide(stream []byte, fm bool) ([]fdc.Sector, bool, int) {
+	decode := func(fm bool) ([]fdc.Sector, int) {
+		if fm {
+			return decodeFMSectors(stream), len(stream) / 4
+		}
+		return decodeSectors(stream), len(stream) / 2
+	}
+	sectors, n := decode(fm)
+	if len(sectors) == 0 {
+		if other, m := decode(!fm); len(other) > 0 {
+			return other, !fm, m
 		}
 	}
+	return sectors, fm, n
+}
 
-	d := extdsk.FormatDsk(nbSect, uint8(numTracks), uint8(numSides), extdsk.DataFormat, extdsk.DSK_TYPE)
+// isFM returns if the header gives an FM encoding to the side of the track
+func (h *HFE) isFM(track, head int) bool {
+	encoding := h.Header.TrackEncoding
+	switch {
+	case track == 0 && head == 0 && h.Header.Track0S0AltEncoding == 0x00:
+		encoding = h.Header.Track0S0Encoding
+	case track == 0 && head == 1 && h.Header.Track0S1AltEncoding == 0x00:
+		encoding = h.Header.Track0S1Encoding
+	}
+	return encoding == EncodingISOIBMFM || encoding == EncodingEmuFM
+}
 
-	for t := 0; t < numTracks && t < len(h.Tracks); t++ {
-		raw0 := mfmDecode(h.Tracks[t].Side0)
-		sectorData := extractSectorData(raw0)
-		if len(sectorData) > 0 && len(sectorData) <= len(d.Tracks[t].Data) {
-			copy(d.Tracks[t].Data, sectorData)
-		}
+// dataRate returns the data rate of the bit rate in kbps
+func dataRate(kbps uint16) extdsk.DataRate {
+	switch {
+	case kbps >= 1000:
+		return extdsk.DataRateED
+	case kbps >= 500:
+		return extdsk.DataRateHD
+	default:
+		return extdsk.DataRateDD
+	}
+}
 
-		if numSides > 1 && len(h.Tracks[t].Side1) > 0 {
-			raw1 := mfmDecode(h.Tracks[t].Side1)
-			sectorData1 := extractSectorData(raw1)
-			idx1 := t*2 + 1
-			if idx1 < len(d.Tracks) && len(sectorData1) > 0 && len(sectorData1) <= len(d.Tracks[idx1].Data) {
-				copy(d.Tracks[idx1].Data, sectorData1)
+// ToDSK converts the HFE image into a *dsk.DSK. Each track is built from
+// the sectors decoded from its MFM or FM bitstream: their IDs, sizes and order,
+// the deleted data marks and the CRC errors as FDC status. The dsk is a
+// standard dsk when its tracks allow it, an extended dsk keeping the
+// positions of the sectors, the data rates and the FM tracks otherwise.
+// Sides without sector are unformatted.
+func (h *HFE) ToDSK() (*extdsk.DSK, error) {
+	numTracks := int(h.Header.NumTracks)
+	numSides := max(int(h.Header.NumSides), 1)
+
+	d := extdsk.FormatDsk(9, uint8(numTracks), uint8(numSides), extdsk.DataFormat, extdsk.EXTENDED_DSK_TYPE)
+	d.OffsetInfo = make([]extdsk.TrackOffsets, len(d.Tracks))
+	for t := range numTracks {
+		var sides [][]byte
+		rate := dataRate(h.Header.BitRate)
+		if t < len(h.Tracks) {
+			sides = [][]byte{h.Tracks[t].Side0, h.Tracks[t].Side1}
+			if h.Tracks[t].BitRate != 0 {
+				rate = dataRate(h.Tracks[t].BitRate)
+			}
+		}
+		for head := range numSides {
+			i := d.TrackIndex(t, head)
+			d.Tracks[i] = extdsk.CPCEMUTrack{}
+			if head >= len(sides) {
+				continue
+			}
+			sectors, fm, length := decodeSide(sides[head], h.isFM(t, head))
+			if len(sectors) == 0 {
+				continue
 			}
+			recording := extdsk.RecordingMFM
+			if fm {
+				recording = extdsk.RecordingFM
+			}
+			track, offsets := fdc.BuildTrack(t, head, sectors, rate, recording)
+			d.Tracks[i] = track
+			d.OffsetInfo[i] = extdsk.TrackOffsets{Length: uint16(min(length, 0xFFFF)), Sectors: offsets}
 		}
 	}
-
+	if standard, err := extdsk.ConvertToStandard(d); err == nil {
+		return standard, nil
+	}
 	return d, nil
 }
 
@@ -247,10 +350,18 @@ func mfmEncode(data []byte) []byte {
 	return out
 }
 
-func mfmEncodeSync(data []byte, sync []bool) []byte {
+// mfmEncodeSync encodes the stream like mfmEncode, the bytes flagged sync are
+// written with the missing clock of the address marks and the bytes flagged
+// weak are written without any flux transition so that they read randomly.
+func mfmEncodeSync(data []byte, sync, weak []bool) []byte {
 	var bits []uint8
 	prevBit := byte(0)
 	for i, b := range data {
+		if i < len(weak) && weak[i] {
+			bits = append(bits, make([]uint8, 16)...)
+			prevBit = 0
+			continue
+		}
 		if i < len(sync) && sync[i] {
 			switch b {
 			case 0xC2:
@@ -284,6 +395,32 @@ func mfmEncodeSync(data []byte, sync []bool) []byte {
 	return out
 }
 
+// fmEncodeSync encodes the stream into an HFE FM bitstream, each FM cell
+// lasting two bits of the stream. The bytes flagged mark are written with
+// the clock of the address marks (D7 for the index mark, C7 otherwise) and
+// the bytes flagged weak without any flux transition.
+func fmEncodeSync(data []byte, mark, weak []bool) []byte {
+	out := make([]byte, len(data)*4)
+	for i, b := range data {
+		if i < len(weak) && weak[i] {
+			continue
+		}
+		clock := byte(0xFF)
+		if i < len(mark) && mark[i] {
+			clock = 0xC7
+			if b == 0xFC {
+				clock = 0xD7
+			}
+		}
+		for j := 0; j < 8; j++ {
+			pos := i*32 + j*4
+			out[pos/8] |= (clock >> uint(7-j) & 1) << uint(pos%8)
+			out[(pos+2)/8] |= (b >> uint(7-j) & 1) << uint((pos+2)%8)
+		}
+	}
+	return out
+}
+
 func byteToBits(b byte) []uint8 {
 	bits := make([]uint8, 8)
 	for i := 0; i < 8; i++ {
@@ -292,112 +429,206 @@ func byteToBits(b byte) []uint8 {
 	return bits
 }
 
-func crc16(data []byte) uint16 {
-	crc := uint16(0xFFFF)
-	for _, b := range data {
-		crc ^= uint16(b) << 8
-		for range 8 {
-			if crc&0x8000 != 0 {
-				crc = (crc << 1) ^ 0x1021
-			} else {
-				crc <<= 1
-			}
-		}
+// trackEncoding gives the gaps and address marks of an encoding
+type trackEncoding struct {
+	fm         bool
+	gap        byte   // filler of the gaps
+	gap4a      int    // bytes before the index mark
+	sync       int    // zeros before each address mark
+	gap1, gap2 int    // bytes after the index mark and after the ID field
+	prefix     []byte // sync bytes of the address marks
+	indexSync  []byte // sync bytes of the index mark
+}
+
+var (
+	encodingMFM = trackEncoding{gap: 0x4E, gap4a: 80, sync: 12, gap1: 50, gap2: 22,
+		prefix: []byte{0xA1, 0xA1, 0xA1}, indexSync: []byte{0xC2, 0xC2, 0xC2}}
+	// the FM address marks are told apart by their clock
+	encodingFM = trackEncoding{fm: true, gap: 0xFF, gap4a: 40, sync: 6, gap1: 26, gap2: 11}
+)
+
+// encodingOf returns the encoding of the recording mode of the track
+func encodingOf(track extdsk.CPCEMUTrack) trackEncoding {
+	if track.RecordingMode == extdsk.RecordingFM {
+		return encodingFM
+	}
+	return encodingMFM
+}
+
+// encode returns the HFE bitstream of the raw bytes of a track and the
+// number of bytes of bitstream of each raw byte
+func (e trackEncoding) encode(raw []byte, sync, weak []bool) ([]byte, int) {
+	if e.fm {
+		return fmEncodeSync(raw, sync, weak), 4
+	}
+	return mfmEncodeSync(raw, sync, weak), 2
+}
+
+// trackLayout places the sectors of a track in the raw track
+type trackLayout struct {
+	length  int      // raw bytes of the track
+	offsets []uint16 // offset of the ID address mark of each sector, nil for the standard gaps
+}
+
+// rawTrackLength returns the raw bytes of a track at the data rate
+func rawTrackLength(rate extdsk.DataRate) int {
+	switch rate {
+	case extdsk.DataRateHD:
+		return 12500
+	case extdsk.DataRateED:
+		return 25000
+	default:
+		return 6250
+	}
+}
+
+// gap3 returns the length of the gap following each sector, the gap of
+// the track if the sectors fit in the raw track, the largest gap which fits otherwise
+func gap3(track extdsk.CPCEMUTrack, length int) int {
+	e := encodingOf(track)
+	mark := len(e.prefix) + 1
+	used := e.gap4a + e.sync + mark + e.gap1
+	for s := 0; s < int(track.NbSect); s++ {
+		used += e.sync + mark + 4 + 2 + e.gap2 + e.sync + mark + track.SectorSize(s) + 2
+	}
+	if track.NbSect == 0 || used+int(track.Gap3)*int(track.NbSect) <= length {
+		return int(track.Gap3)
 	}
-	return crc
+	return max(0, (length-used)/int(track.NbSect))
 }
 
 // buildMFMTrack encodes a DSK track (sectors + data) into a raw MFM byte stream
+// with the standard gaps
 func buildMFMTrack(track extdsk.CPCEMUTrack) []byte {
+	return buildTrack(track, trackLayout{length: rawTrackLength(track.DataRate)})
+}
+
+// buildTrack encodes a DSK track into an MFM or FM bitstream following its
+// recording mode, the sectors are placed at their offsets when the layout has them
+func buildTrack(track extdsk.CPCEMUTrack, layout trackLayout) []byte {
+	stream, _ := encodingOf(track).encode(trackBytes(track, layout))
+	return stream
+}
+
+// buildTrackV3 encodes a DSK track like buildTrack into an HFE v3 stream,
+// starting with the index and the bit rate of the track when it differs
+// from the bit rate of the file, its weak bytes written as random bytes
+func buildTrackV3(track extdsk.CPCEMUTrack, layout trackLayout, rate extdsk.DataRate) []byte {
+	raw, sync, weak := trackBytes(track, layout)
+	encoded, n := encodingOf(track).encode(raw, sync, weak)
+	for i, w := range weak {
+		for j := 0; w && j < n; j++ {
+			encoded[n*i+j] = bits.Reverse8(opRand)
+		}
+	}
+	stream := []byte{bits.Reverse8(opSetIndex)}
+	if trackRate := max(track.DataRate, extdsk.DataRateDD); trackRate != rate {
+		stream = append(stream, bits.Reverse8(opSetBitRate), bits.Reverse8(byte(18000/(rawTrackLength(trackRate)/25))))
+	}
+	return append(stream, encoded...)
+}
+
+// trackBytes returns the raw bytes of a DSK track in its encoding with the
+// flags of the sync bytes of the address marks (the marks themselves in FM)
+// and of the weak bytes of the sectors
+func trackBytes(track extdsk.CPCEMUTrack, layout trackLayout) ([]byte, []bool, []bool) {
+	e := encodingOf(track)
 	var raw []byte
-	var syncFlags []bool
+	var syncFlags, weakFlags []bool
 
 	appendRaw := func(b byte, sync bool) {
 		raw = append(raw, b)
 		syncFlags = append(syncFlags, sync)
+		weakFlags = append(weakFlags, false)
 	}
 	appendBytes := func(bs ...byte) {
 		for _, b := range bs {
 			appendRaw(b, false)
 		}
 	}
-
-	// GAP4a
-	for range 80 {
-		appendRaw(0x4E, false)
+	appendGap := func(n int) {
+		for range n {
+			appendRaw(e.gap, false)
+		}
 	}
-	// Sync
-	for range 12 {
-		appendRaw(0x00, false)
+	appendSync := func() {
+		for range e.sync {
+			appendRaw(0x00, false)
+		}
+	}
+	appendMark := func(sync []byte, mark byte) {
+		for _, b := range sync {
+			appendRaw(b, true)
+		}
+		appendRaw(mark, e.fm)
 	}
+	gap := gap3(track, layout.length)
+
+	// GAP4a
+	appendGap(e.gap4a)
+	appendSync()
 	// IAM
-	appendRaw(0xC2, true)
-	appendRaw(0xC2, true)
-	appendRaw(0xC2, true)
-	appendRaw(0xFC, false)
+	appendMark(e.indexSync, 0xFC)
 	// GAP1
-	for range 50 {
-		appendRaw(0x4E, false)
-	}
+	appendGap(e.gap1)
 
-	dataOffset := 0
 	for s := 0; s < int(track.NbSect); s++ {
 		sec := track.Sect[s]
-		sectorSize := int(sec.SizeByte)
-		if sectorSize == 0 {
-			sectorSize = int(128) << sec.N
-		}
+		sectorSize := track.SectorSize(s)
 
-		// Sync
-		for range 12 {
-			appendRaw(0x00, false)
+		if s < len(layout.offsets) {
+			// gap up to the sync of the ID address mark
+			for len(raw) < int(layout.offsets[s])-e.sync {
+				appendRaw(e.gap, false)
+			}
 		}
+		appendSync()
+		st1, st2 := uint8(sec.Un1), uint8(sec.Un1>>8)
 		// IDAM
-		appendRaw(0xA1, true)
-		appendRaw(0xA1, true)
-		appendRaw(0xA1, true)
-		appendRaw(0xFE, false)
+		appendMark(e.prefix, 0xFE)
 		idam := []byte{sec.C, sec.H, sec.R, sec.N}
 		appendBytes(idam...)
-		crc := crc16(append([]byte{0xA1, 0xA1, 0xA1, 0xFE}, idam...))
+		crc := fdc.CRC16(append(append(append([]byte{}, e.prefix...), 0xFE), idam...))
+		if st1&fdc.ST1DataError != 0 && st2&fdc.ST2DataErrorInData == 0 {
+			crc = ^crc // CRC error in the ID field
+		}
 		appendBytes(byte(crc>>8), byte(crc))
 		// GAP2
-		for range 22 {
-			appendRaw(0x4E, false)
-		}
-		for range 12 {
-			appendRaw(0x00, false)
-		}
-		// DAM
-		appendRaw(0xA1, true)
-		appendRaw(0xA1, true)
-		appendRaw(0xA1, true)
-		appendRaw(0xFB, false)
+		appendGap(e.gap2)
+		// no data field when the status has a missing data mark
+		if st2&fdc.ST2MissingDataMark == 0 {
+			appendSync()
+			// DAM, deleted data when the status has the control mark
+			mark := byte(0xFB)
+			if st2&fdc.ST2ControlMark != 0 {
+				mark = 0xF8
+			}
+			appendMark(e.prefix, mark)
 
-		// sector data
-		sdata := make([]byte, sectorSize)
-		end := dataOffset + sectorSize
-		if end > len(track.Data) {
-			end = len(track.Data)
-		}
-		if dataOffset < len(track.Data) {
-			copy(sdata, track.Data[dataOffset:end])
-		}
-		appendBytes(sdata...)
-		dataOffset += sectorSize
+			// sector data, first copy of a weak sector with its weak bytes
+			sdata := make([]byte, sectorSize)
+			if copies := track.SectorCopies(s); len(copies) > 0 {
+				copy(sdata, copies[0])
+			}
+			appendBytes(sdata...)
+			copy(weakFlags[len(weakFlags)-sectorSize:], track.WeakBytes(s))
 
-		crc = crc16(append([]byte{0xA1, 0xA1, 0xA1, 0xFB}, sdata...))
-		appendBytes(byte(crc>>8), byte(crc))
-		// GAP3
-		for i := 0; i < 54; i++ {
-			appendRaw(0x4E, false)
+			crc = fdc.CRC16(append(append(append([]byte{}, e.prefix...), mark), sdata...))
+			if st2&fdc.ST2DataErrorInData != 0 {
+				crc = ^crc // CRC error in the data field
+			}
+			appendBytes(byte(crc>>8), byte(crc))
+		}
+		// GAP3, up to the next offset when the layout has them
+		if layout.offsets == nil {
+			appendGap(gap)
 		}
 	}
 	// GAP4b
-	for len(raw) < 6254 {
-		appendRaw(0x4E, false)
+	for len(raw) < layout.length {
+		appendRaw(e.gap, false)
 	}
-	return mfmEncodeSync(raw, syncFlags)
+	return raw, syncFlags, weakFlags
 }
 
 // interleave merges side0 and side1 into 512-byte blocks (256 per side)
@@ -420,42 +651,114 @@ func interleave(side0, side1 []byte) []byte {
 	return out
 }
 
+// dskTrackLayout returns the layout of the track at index i of the dsk, from
+// its Offset-Info when the dsk has one
+func dskTrackLayout(d *extdsk.DSK, i int) trackLayout {
+	layout := trackLayout{length: rawTrackLength(d.Tracks[i].DataRate)}
+	if encodingOf(d.Tracks[i]).fm {
+		// an FM byte lasts twice an MFM byte
+		layout.length /= 2
+	}
+	if offsets, ok := d.SectorOffsets(i); ok {
+		layout.offsets = offsets.Sectors
+		if offsets.Length != 0 {
+			layout.length = int(offsets.Length)
+		}
+	}
+	return layout
+}
+
+// headerEncoding returns the track encoding of the header for FM or MFM tracks
+func headerEncoding(fm bool) byte {
+	if fm {
+		return EncodingISOIBMFM
+	}
+	return EncodingISOIBMMFM
+}
+
 // FromDSK converts a *extdsk.DSK into an HFE file written at path.
+// The bit rate follows the data rate of the tracks, each track is encoded
+// in MFM or FM following its recording mode and the sectors are placed at
+// the positions of the Offset-Info block of the dsk. ErrorTrackTooLong is
+// returned for a track whose two sides do not fit in 64K of bitstream, as
+// the tracks at extended density.
 func FromDSK(d *extdsk.DSK, path string) error {
+	return fromDSK(d, path, false)
+}
+
+// FromDSKV3 converts a *extdsk.DSK into an HFE v3 file written at path.
+// The bit rate of the file is the data rate of the first track, a track at
+// another data rate sets its own bit rate and the weak bytes of the
+// sectors are written as random bytes.
+func FromDSKV3(d *extdsk.DSK, path string) error {
+	return fromDSK(d, path, true)
+}
+
+func fromDSK(d *extdsk.DSK, path string, v3 bool) error {
 	numTracks := int(d.Entry.NbTracks)
 	numSides := max(int(d.Entry.NbHeads), 1)
 
+	rate := extdsk.DataRateDD
+	// the file has the encoding of the tracks following track 0, whose sides
+	// have their own encoding in the header
+	fm := len(d.Tracks) > 0
+	for i, t := range d.Tracks {
+		if i >= numSides || len(d.Tracks) <= numSides {
+			fm = fm && encodingOf(t).fm
+		}
+		if !v3 {
+			rate = max(rate, t.DataRate)
+		}
+	}
+	if v3 && len(d.Tracks) > 0 {
+		rate = max(rate, d.Tracks[0].DataRate)
+	}
+
 	type trackData struct {
 		interleaved []byte
 		mfmLen      uint16
 	}
 	tracks := make([]trackData, numTracks)
 
-	for t := range numTracks {
-		var side0, side1 []byte
-
-		idx0 := t * numSides
-		if idx0 < len(d.Tracks) {
-			side0 = buildMFMTrack(d.Tracks[idx0])
-		} else {
-			side0 = mfmEncode(make([]byte, 6250))
+	// side returns the bitstream of the track of the cylinder and head
+	side := func(cyl, head int) []byte {
+		i := d.TrackIndex(cyl, head)
+		switch {
+		case i >= 0 && v3:
+			return buildTrackV3(d.Tracks[i], dskTrackLayout(d, i), rate)
+		case i >= 0:
+			return buildTrack(d.Tracks[i], dskTrackLayout(d, i))
+		case v3:
+			return append([]byte{bits.Reverse8(opSetIndex)}, mfmEncode(make([]byte, rawTrackLength(rate)))...)
 		}
+		return mfmEncode(make([]byte, rawTrackLength(rate)))
+	}
 
+	for t := range numTracks {
+		side0 := side(t, 0)
+		side1 := make([]byte, len(side0))
 		if numSides > 1 {
-			idx1 := t*numSides + 1
-			if idx1 < len(d.Tracks) {
-				side1 = buildMFMTrack(d.Tracks[idx1])
-			} else {
-				side1 = mfmEncode(make([]byte, 6250))
+			side1 = side(t, 1)
+		}
+		if v3 {
+			// both sides are read with the same length, the shorter one ends with no-ops
+			for len(side0) < len(side1) {
+				side0 = append(side0, bits.Reverse8(opNop))
+			}
+			for len(side1) < len(side0) {
+				side1 = append(side1, bits.Reverse8(opNop))
 			}
-		} else {
-			side1 = make([]byte, len(side0))
 		}
 
+		// the track list stores the length of both sides on 16 bits
+		length := len(side0) + len(side1)
+		if length > 0xFFFF {
+			return fmt.Errorf("%w: track %d holds %d bytes of bitstream", ErrorTrackTooLong, t, length)
+		}
 		interleaved := interleave(side0, side1)
 		tracks[t] = trackData{
 			interleaved: interleaved,
-			mfmLen:      uint16(len(side0) + len(side1)), // actual MFM stream length (both sides)
+			mfmLen:      uint16(length), // actual stream length (both sides)
 		}
 	}
 
@@ -485,16 +788,26 @@ func FromDSK(d *extdsk.DSK, path string) error {
 	for i := range hdr {
 		hdr[i] = 0xff
 	}
-	copy(hdr[:8], "HXCPICFE")
+	if v3 {
+		copy(hdr[:8], signatureV3)
+	} else {
+		copy(hdr[:8], signatureV1)
+	}
 	hdr[8] = 0 // revision
 	hdr[9] = byte(numTracks)
 	hdr[10] = byte(numSides)
-	hdr[11] = 0                                   // ISOIBM_MFM
-	binary.LittleEndian.PutUint16(hdr[12:], 0xFA) // bitrate kbps
-	binary.LittleEndian.PutUint16(hdr[14:], 0)    // RPM
-	hdr[16] = 0x06                                // generic shugart
-	hdr[17] = 1                                   // MCU version
-	binary.LittleEndian.PutUint16(hdr[18:], 1)    // LUT at block 1
+	hdr[11] = headerEncoding(fm)
+	binary.LittleEndian.PutUint16(hdr[12:], uint16(rawTrackLength(rate)/25)) // bitrate kbps
+	binary.LittleEndian.PutUint16(hdr[14:], 0)                               // RPM
+	hdr[16] = 0x06                                                           // generic shugart
+	hdr[17] = 1                                                              // MCU version
+	binary.LittleEndian.PutUint16(hdr[18:], 1)                               // LUT at block 1
+	for head := 0; head < numSides; head++ {
+		if i := d.TrackIndex(0, head); i >= 0 && encodingOf(d.Tracks[i]).fm != fm {
+			hdr[22+2*head] = 0x00
+			hdr[23+2*head] = headerEncoding(!fm)
+		}
+	}
 
 	if _, err := f.Write(hdr); err != nil {
 		return err
@@ -517,14 +830,3 @@ func FromDSK(d *extdsk.DSK, path string) error {
 	}
 	return nil
 }
-
-func countSectors(raw []byte) int {
-	count := 0
-	for i := 0; i < len(raw)-3; i++ {
-		if raw[i] == 0xA1 && raw[i+1] == 0xA1 && raw[i+2] == 0xA1 && raw[i+3] == 0xFE {
-			count++
-			i += 3
-		}
-	}
-	return count
-}
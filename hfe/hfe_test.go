@@ -3,11 +3,13 @@ package hfe
 import (
 	"bytes"
 	"encoding/binary"
+	"math/bits"
 	"os"
 	"path/filepath"
 	"testing"
 
 	extdsk "github.com/jeromelesaux/dsk/dsk"
+	"github.com/jeromelesaux/dsk/internal/fdc"
 	"github.com/stretchr/testify/require"
 )
 
@@ -32,29 +34,27 @@ func writeHFE(t *testing.T, d *extdsk.DSK) string {
 	return path
 }
 
-// --- crc16 ---
+// --- mfmEncode ---
 
-func TestCRC16_Deterministic(t *testing.T) {
-	data := []byte{0xFE, 0x00, 0x00, 0x02}
-	if crc16(data) != crc16(data) {
-		t.Error("crc16 is not deterministic")
-	}
-}
-
-func TestCRC16_EmptyInput(t *testing.T) {
-	if got := crc16(nil); got != 0xFFFF {
-		t.Errorf("crc16(nil) = 0x%04X, want 0xFFFF", got)
-	}
+// addressField returns the sync bytes, the address mark, the content and the
+// CRC of an MFM field, with the flags of its sync bytes
+func addressField(mark byte, content ...byte) ([]byte, []bool) {
+	field := append([]byte{0xA1, 0xA1, 0xA1, mark}, content...)
+	field = binary.BigEndian.AppendUint16(field, fdc.CRC16(field))
+	sync := make([]bool, len(field))
+	copy(sync, []bool{true, true, true})
+	return field, sync
 }
 
-func TestCRC16_DifferentInputs(t *testing.T) {
-	if crc16([]byte{0x00}) == crc16([]byte{0xFF}) {
-		t.Error("crc16 collision on different inputs")
+// sectorData returns the data of the sectors joined
+func sectorData(sectors []fdc.Sector) []byte {
+	var data []byte
+	for _, s := range sectors {
+		data = append(data, s.Data...)
 	}
+	return data
 }
 
-// --- mfmEncode / mfmDecode ---
-
 func TestMFMRoundTrip(t *testing.T) {
 	cases := [][]byte{
 		{0x00},
@@ -65,14 +65,15 @@ func TestMFMRoundTrip(t *testing.T) {
 		make([]byte, 32),
 	}
 	for _, input := range cases {
-		encoded := mfmEncode(input)
-		decoded := mfmDecode(encoded)
-		if len(decoded) < len(input) {
-			t.Errorf("decoded too short for input %v", input)
-			continue
+		cells := streamCells(mfmEncode(input))
+		decoded := make([]byte, len(input))
+		for i := range decoded {
+			for k := range 8 {
+				decoded[i] = decoded[i]<<1 | cells[i*16+2*k+1] // the data cells
+			}
 		}
-		if !bytes.Equal(decoded[:len(input)], input) {
-			t.Errorf("round-trip failed for %v: got %v", input, decoded[:len(input)])
+		if !bytes.Equal(decoded, input) {
+			t.Errorf("round-trip failed for %v: got %v", input, decoded)
 		}
 	}
 }
@@ -85,9 +86,9 @@ func TestMFMEncode_OutputSize(t *testing.T) {
 	}
 }
 
-func TestMFMDecode_Empty(t *testing.T) {
-	if got := mfmDecode(nil); len(got) != 0 {
-		t.Errorf("expected empty, got %d bytes", len(got))
+func TestDecodeSectors_Empty(t *testing.T) {
+	if got := decodeSectors(nil); len(got) != 0 {
+		t.Errorf("expected empty, got %d sectors", len(got))
 	}
 }
 
@@ -132,52 +133,48 @@ func TestInterleave_AsymmetricSides(t *testing.T) {
 	}
 }
 
-// --- countSectors ---
+// --- decodeSectors ---
 
-func TestCountSectors_None(t *testing.T) {
-	if n := countSectors(make([]byte, 100)); n != 0 {
+func TestDecodeSectors_None(t *testing.T) {
+	if n := len(decodeSectors(mfmEncode(make([]byte, 100)))); n != 0 {
 		t.Errorf("expected 0, got %d", n)
 	}
 }
 
-func TestCountSectors_One(t *testing.T) {
-	raw := []byte{0xA1, 0xA1, 0xA1, 0xFE, 0x00, 0x00, 0x00, 0x00}
-	if n := countSectors(raw); n != 1 {
-		t.Errorf("expected 1, got %d", n)
+func TestDecodeSectors_One(t *testing.T) {
+	raw, sync := addressField(0xFE, 0x00, 0x00, 0x00, 0x00)
+	sectors := decodeSectors(mfmEncodeSync(raw, sync, nil))
+	if len(sectors) != 1 || !sectors[0].IDCRC {
+		t.Errorf("expected 1 sector with a valid ID CRC, got %v", sectors)
 	}
 }
 
-func TestCountSectors_Nine(t *testing.T) {
+func TestDecodeSectors_Nine(t *testing.T) {
 	var raw []byte
+	var sync []bool
 	for i := 0; i < 9; i++ {
-		raw = append(raw, 0xA1, 0xA1, 0xA1, 0xFE, 0x00, 0x00, 0x00, 0x00)
-		raw = append(raw, make([]byte, 512)...)
+		id, idSync := addressField(0xFE, 0x00, 0x00, byte(0xC1+i), 0x02)
+		raw = append(append(raw, id...), make([]byte, 512)...)
+		sync = append(append(sync, idSync...), make([]bool, 512)...)
 	}
-	if n := countSectors(raw); n != 9 {
+	if n := len(decodeSectors(mfmEncodeSync(raw, sync, nil))); n != 9 {
 		t.Errorf("expected 9, got %d", n)
 	}
 }
 
-// --- extractSectorData ---
-
-func TestExtractSectorData_Empty(t *testing.T) {
-	if got := extractSectorData(nil); len(got) != 0 {
-		t.Errorf("expected empty, got %d bytes", len(got))
-	}
-}
-
-func TestExtractSectorData_OneSector(t *testing.T) {
+func TestDecodeSectors_OneSector(t *testing.T) {
 	sectorData := bytes.Repeat([]byte{0x42}, 512)
-	var raw []byte
-	raw = append(raw, 0xA1, 0xA1, 0xA1, 0xFE)
-	raw = append(raw, 0x00, 0x00, 0x01, 0x02) // C H R N (N=2 → 512 bytes)
-	raw = append(raw, 0x00, 0x00)             // CRC
-	raw = append(raw, 0xA1, 0xA1, 0xA1, 0xFB)
-	raw = append(raw, sectorData...)
+	raw, sync := addressField(0xFE, 0x00, 0x00, 0x01, 0x02) // C H R N (N=2 → 512 bytes)
+	gap := bytes.Repeat([]byte{0x4E}, 22)
+	raw = append(raw, gap...)
+	sync = append(sync, make([]bool, len(gap))...)
+	data, dataSync := addressField(0xFB, sectorData...)
+	raw = append(raw, data...)
+	sync = append(sync, dataSync...)
 
-	got := extractSectorData(raw)
-	if !bytes.Equal(got, sectorData) {
-		t.Errorf("sector data mismatch: got %d bytes", len(got))
+	got := decodeSectors(mfmEncodeSync(raw, sync, nil))
+	if len(got) != 1 || !got[0].DataCRC || !bytes.Equal(got[0].Data, sectorData) {
+		t.Errorf("sector data mismatch: got %v", got)
 	}
 }
 
@@ -195,8 +192,7 @@ func TestBuildMFMTrack_MinLength(t *testing.T) {
 func TestBuildMFMTrack_ContainsSectors(t *testing.T) {
 	d := makeDSK(1, 1)
 	mfm := buildMFMTrack(d.Tracks[0])
-	decoded := mfmDecode(mfm)
-	n := countSectors(decoded)
+	n := len(decodeSectors(mfm))
 	if n != int(d.Tracks[0].NbSect) {
 		t.Errorf("expected %d sectors in MFM stream, got %d", d.Tracks[0].NbSect, n)
 	}
@@ -209,8 +205,7 @@ func TestBuildMFMTrack_DataRoundTrip(t *testing.T) {
 		d.Tracks[0].Data[i] = byte(i & 0xFF)
 	}
 	mfm := buildMFMTrack(d.Tracks[0])
-	decoded := mfmDecode(mfm)
-	recovered := extractSectorData(decoded)
+	recovered := sectorData(decodeSectors(mfm))
 
 	if !bytes.Equal(recovered, d.Tracks[0].Data) {
 		t.Errorf("sector data round-trip failed: got %d bytes, want %d", len(recovered), len(d.Tracks[0].Data))
@@ -554,3 +549,424 @@ func TestLoadDefaultHFE(t *testing.T) {
 	require.NoError(t, err)
 
 }
+
+func TestBuildMFMTrack_WeakSector(t *testing.T) {
+	d := extdsk.FormatDsk(9, 1, 1, extdsk.DataFormat, extdsk.EXTENDED_DSK_TYPE)
+	copies := make([][]byte, 2)
+	for i := range copies {
+		copies[i] = bytes.Repeat([]byte{0x55}, 512)
+		copies[i][100] = byte(0x80 + i)
+	}
+	require.NoError(t, d.SetSectorCopies(0, 0, 1, copies))
+	for i := range d.Tracks[0].Data[2048:] {
+		d.Tracks[0].Data[2048+i] = byte(i)
+	}
+
+	sectors := decodeSectors(buildMFMTrack(d.Tracks[0]))
+	require.Len(t, sectors, 9)
+	recovered := sectorData(sectors)
+	require.Len(t, recovered, 9*512)
+	weak := recovered[512 : 2*512]
+	require.Equal(t, copies[0][:100], weak[:100])
+	require.Equal(t, byte(0), weak[100], "weak byte written without flux transition")
+	require.Equal(t, copies[0][101:], weak[101:])
+	// the sectors following the weak one are not shifted
+	require.Equal(t, d.Tracks[0].Data[2048:], recovered[2*512+512:])
+}
+
+func TestBuildTrack_SectorOffsets(t *testing.T) {
+	d := makeDSK(1, 1)
+	offsets := make([]uint16, d.Tracks[0].NbSect)
+	for s := range offsets {
+		offsets[s] = uint16(300 + s*640)
+	}
+	stream := buildTrack(d.Tracks[0], trackLayout{length: 6250, offsets: offsets})
+	if len(stream) != 2*6250 {
+		t.Fatalf("expected 6250 raw bytes, got %d", len(stream)/2)
+	}
+	var found []uint16
+	for _, s := range decodeSectors(stream) {
+		found = append(found, uint16(s.Offset))
+	}
+	require.Equal(t, offsets, found)
+}
+
+func TestFromDSK_HighDensity(t *testing.T) {
+	d := makeDSK(2, 1)
+	for i := range d.Tracks {
+		d.Tracks[i].DataRate = extdsk.DataRateHD
+	}
+	path := writeHFE(t, d)
+	raw, _ := os.ReadFile(path)
+	if binary.LittleEndian.Uint16(raw[12:14]) != 500 {
+		t.Errorf("BitRate: expected 500, got %d", binary.LittleEndian.Uint16(raw[12:14]))
+	}
+	h, err := Open(path)
+	require.NoError(t, err)
+	recovered, err := h.ToDSK()
+	require.NoError(t, err)
+	require.True(t, recovered.Extended, "a standard dsk has no data rate")
+	require.Equal(t, extdsk.DataRateHD, recovered.Track(1, 0).DataRate)
+}
+
+func TestFromDSK_TrackTooLong(t *testing.T) {
+	d := makeDSK(2, 1)
+	d.Tracks[1].DataRate = extdsk.DataRateED
+	path := filepath.Join(t.TempDir(), "ed.hfe")
+	require.ErrorIs(t, FromDSK(d, path), ErrorTrackTooLong)
+	_, err := os.Stat(path)
+	require.True(t, os.IsNotExist(err), "no file written")
+}
+
+// makeFMTrack turns the track into an FM track of 5 sectors of 256 bytes
+func makeFMTrack(d *extdsk.DSK, i int) {
+	track := &d.Tracks[i]
+	track.RecordingMode = extdsk.RecordingFM
+	track.NbSect = 5
+	track.SectSize = 1
+	track.Data = make([]byte, 5*256)
+	for s := range 5 {
+		track.Sect[s] = extdsk.CPCEMUSect{C: track.Track, H: track.Head, R: uint8(s + 1), N: 1, SizeByte: 256}
+	}
+	for j := range track.Data {
+		track.Data[j] = byte(i + j*3)
+	}
+}
+
+func TestFMRoundTrip(t *testing.T) {
+	data := []byte{0x00, 0xFC, 0xFF, 0x00, 0xFE, 0x5A, 0x00, 0xFB, 0x00, 0xF8}
+	mark := []bool{false, true, false, false, true, false, false, true, false, true}
+	stream := fmEncodeSync(data, mark, nil)
+	require.Len(t, stream, len(data)*4)
+	clocks := []byte{0xFF, 0xD7, 0xFF, 0xFF, 0xC7, 0xFF, 0xFF, 0xC7, 0xFF, 0xC7}
+	cells := streamCells(stream)
+	for i, b := range data {
+		for j := range 8 {
+			bit := cells[i*32+j*4:][:4]
+			require.Equal(t, []byte{clocks[i] >> (7 - j) & 1, 0, b >> (7 - j) & 1, 0}, bit, "byte %d bit %d", i, j)
+		}
+	}
+}
+
+func TestDecodeFMSectors_AddressMarks(t *testing.T) {
+	d := makeDSK(1, 1)
+	d.Extended = true
+	makeFMTrack(d, 0)
+	d.Tracks[0].Sect[1].Un1 = uint16(fdc.ST2ControlMark) << 8
+	d.Tracks[0].Sect[3].Un1 = fdc.ST1DataError | uint16(fdc.ST2DataErrorInData)<<8
+	raw, mark, weak := trackBytes(d.Tracks[0], trackLayout{length: 3125})
+	sectors := decodeFMSectors(fmEncodeSync(raw, mark, weak))
+	require.Len(t, sectors, 5)
+	for s, sec := range sectors {
+		require.Equal(t, uint8(s+1), sec.ID[2])
+		require.True(t, sec.IDCRC)
+		require.Equal(t, d.Tracks[0].Sect[s].Un1, sec.Status(), "sector %d", s)
+	}
+	require.True(t, sectors[1].Deleted)
+	require.Equal(t, d.Tracks[0].Data[:256], sectors[0].Data)
+	// the MFM decoding finds no sector in an FM track
+	require.Empty(t, decodeSectors(fmEncodeSync(raw, mark, weak)))
+}
+
+// shiftBits delays the LSB-first bitstream by n bits
+func shiftBits(stream []byte, n int) []byte {
+	out := make([]byte, len(stream)+(n+7)/8)
+	for i := range len(stream) * 8 {
+		if stream[i/8]>>uint(i%8)&1 != 0 {
+			out[(i+n)/8] |= 1 << uint((i+n)%8)
+		}
+	}
+	return out
+}
+
+func TestDecodeFMSectors_AnyPhase(t *testing.T) {
+	d := makeDSK(1, 1)
+	d.Extended = true
+	makeFMTrack(d, 0)
+	stream := buildTrack(d.Tracks[0], trackLayout{length: 3125})
+	for _, n := range []int{1, 2, 6, 13, 30} {
+		shifted := shiftBits(stream, n)
+		// and a bit slip in the middle of the track
+		slipped := append(append([]byte{}, shifted[:len(shifted)/2]...), shiftBits(shifted[len(shifted)/2:], 3)...)
+		for _, s := range [][]byte{shifted, slipped} {
+			sectors := decodeFMSectors(s)
+			require.Len(t, sectors, 5, "shifted by %d bits", n)
+			for i, sec := range sectors {
+				require.True(t, sec.IDCRC)
+				require.True(t, sec.DataCRC, "sector %d shifted by %d bits", i, n)
+				require.Equal(t, d.Tracks[0].Data[i*256:(i+1)*256], sec.Data)
+			}
+		}
+	}
+}
+
+func TestDecodeSectors_AnyPhase(t *testing.T) {
+	d := makeDSK(1, 1)
+	stream := buildMFMTrack(d.Tracks[0])
+	gap := 2 * (decodeSectors(stream)[5].Offset - 30) // in the gap before the fifth sector
+	for _, n := range []int{1, 2, 7, 13, 31} {
+		shifted := shiftBits(stream, n)
+		// and a bit slip in the middle of the track
+		slipped := append(append([]byte{}, shifted[:gap]...), shiftBits(shifted[gap:], 5)...)
+		for _, s := range [][]byte{shifted, slipped} {
+			sectors := decodeSectors(s)
+			require.Len(t, sectors, 9, "shifted by %d bits", n)
+			for i, sec := range sectors {
+				require.True(t, sec.IDCRC)
+				require.True(t, sec.DataCRC, "sector %d shifted by %d bits", i, n)
+				require.Equal(t, d.Tracks[0].Data[i*512:(i+1)*512], sec.Data)
+			}
+		}
+	}
+}
+
+func TestRoundTrip_MixedFM(t *testing.T) {
+	d := makeDSK(3, 1)
+	d.Extended = true
+	for i := range d.Tracks {
+		d.Tracks[i].RecordingMode = extdsk.RecordingMFM
+	}
+	makeFMTrack(d, 1)
+	h, err := Open(writeHFE(t, d))
+	require.NoError(t, err)
+	require.Equal(t, EncodingISOIBMMFM, h.Header.TrackEncoding)
+	require.Equal(t, EncodingUnknown, h.Header.Track0S0AltEncoding)
+	recovered, err := h.ToDSK()
+	require.NoError(t, err)
+	require.True(t, recovered.Extended)
+	for i := range d.Tracks {
+		got := recovered.Track(i, 0)
+		require.Equal(t, d.Tracks[i].RecordingMode, got.RecordingMode, "track %d", i)
+		require.Equal(t, d.Tracks[i].NbSect, got.NbSect)
+		require.Equal(t, d.Tracks[i].Data, got.Data, "track %d", i)
+	}
+
+	r := Analyze(h)
+	require.True(t, r.OK(), "%v", r.Tracks)
+	require.False(t, r.Tracks[0].FM)
+	require.True(t, r.Tracks[1].FM)
+	require.Contains(t, r.Tracks[1].String(), "5 FM sectors")
+}
+
+func TestFromDSK_FMHeaderEncoding(t *testing.T) {
+	// an FM file whose track 0 is in MFM
+	d := makeDSK(3, 1)
+	d.Extended = true
+	d.Tracks[0].RecordingMode = extdsk.RecordingMFM
+	makeFMTrack(d, 1)
+	makeFMTrack(d, 2)
+	path := filepath.Join(t.TempDir(), "v3.hfe")
+	require.NoError(t, FromDSKV3(d, path))
+	h, err := Open(path)
+	require.NoError(t, err)
+	require.Equal(t, EncodingISOIBMFM, h.Header.TrackEncoding)
+	require.Equal(t, byte(0x00), h.Header.Track0S0AltEncoding)
+	require.Equal(t, EncodingISOIBMMFM, h.Header.Track0S0Encoding)
+	require.False(t, h.isFM(0, 0))
+	require.True(t, h.isFM(1, 0))
+
+	recovered, err := h.ToDSK()
+	require.NoError(t, err)
+	for i := range d.Tracks {
+		require.Equal(t, d.Tracks[i].RecordingMode, recovered.Track(i, 0).RecordingMode, "track %d", i)
+		require.Equal(t, d.Tracks[i].Data, recovered.Track(i, 0).Data, "track %d", i)
+	}
+}
+
+func TestRoundTrip_DoubleSided(t *testing.T) {
+	d := makeDSK(3, 2)
+	h, err := Open(writeHFE(t, d))
+	require.NoError(t, err)
+	recovered, err := h.ToDSK()
+	require.NoError(t, err)
+	for c := 0; c < 3; c++ {
+		for head := 0; head < 2; head++ {
+			if !bytes.Equal(recovered.Track(c, head).Data, d.Track(c, head).Data) {
+				t.Errorf("cylinder %d head %d: sector data mismatch", c, head)
+			}
+		}
+	}
+}
+
+func TestRoundTrip_V3(t *testing.T) {
+	d := makeDSK(3, 2)
+	path := filepath.Join(t.TempDir(), "v3.hfe")
+	require.NoError(t, FromDSKV3(d, path))
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "HXCHFEV3", string(raw[:8]))
+
+	h, err := Open(path)
+	require.NoError(t, err)
+	recovered, err := h.ToDSK()
+	require.NoError(t, err)
+	for c := 0; c < 3; c++ {
+		for head := 0; head < 2; head++ {
+			if !bytes.Equal(recovered.Track(c, head).Data, d.Track(c, head).Data) {
+				t.Errorf("cylinder %d head %d: sector data mismatch", c, head)
+			}
+		}
+	}
+}
+
+func TestFromDSKV3_WeakSector(t *testing.T) {
+	d := extdsk.FormatDsk(9, 1, 1, extdsk.DataFormat, extdsk.EXTENDED_DSK_TYPE)
+	copies := make([][]byte, 2)
+	for i := range copies {
+		copies[i] = bytes.Repeat([]byte{0x55}, 512)
+		copies[i][100] = byte(0x80 + i)
+	}
+	require.NoError(t, d.SetSectorCopies(0, 0, 1, copies))
+
+	stream := buildTrackV3(d.Tracks[0], dskTrackLayout(d, 0), extdsk.DataRateDD)
+	rand := bytes.Repeat([]byte{bits.Reverse8(opRand)}, 2)
+	require.Equal(t, 1, bytes.Count(stream, rand), "one weak byte written as random bytes")
+
+	plain, rate := decodeV3(stream)
+	require.Zero(t, rate)
+	recovered := sectorData(decodeSectors(plain))
+	require.Len(t, recovered, 9*512)
+	require.Equal(t, copies[0][:100], recovered[512:612])
+	require.Equal(t, byte(0), recovered[612], "random byte read without flux transition")
+}
+
+func TestFromDSKV3_BitRatePerTrack(t *testing.T) {
+	d := makeDSK(2, 1)
+	d.Tracks[1].DataRate = extdsk.DataRateHD
+	path := filepath.Join(t.TempDir(), "v3.hfe")
+	require.NoError(t, FromDSKV3(d, path))
+	raw, _ := os.ReadFile(path)
+	require.Equal(t, uint16(250), binary.LittleEndian.Uint16(raw[12:14]))
+
+	h, err := Open(path)
+	require.NoError(t, err)
+	require.Zero(t, h.Tracks[0].BitRate)
+	require.Equal(t, uint16(500), h.Tracks[1].BitRate)
+	require.Len(t, decodeSectors(h.Tracks[1].Side0), 9)
+}
+
+func TestDecodeV3_SkipBits(t *testing.T) {
+	// a data byte, 3 bits skipped from the next one then a no-op
+	stream := []byte{0xAA, bits.Reverse8(opSkipBits), bits.Reverse8(3), 0xFF, bits.Reverse8(opNop), 0x55}
+	plain, _ := decodeV3(stream)
+	// 8 + 5 + 8 bits, LSB-first
+	require.Equal(t, []byte{0xAA, 0xBF, 0x0A}, plain)
+}
+
+func TestDecodeV3_SkipBitsTrack(t *testing.T) {
+	d := makeDSK(1, 1)
+	stream := buildMFMTrack(d.Tracks[0])
+	// a byte of which 3 bits are skipped, in the gap before the fifth sector
+	gap := 2 * (decodeSectors(stream)[5].Offset - 30)
+	v3 := append([]byte{}, stream[:gap]...)
+	v3 = append(v3, bits.Reverse8(opSkipBits), bits.Reverse8(3), stream[gap])
+	v3 = append(v3, stream[gap:]...)
+	plain, _ := decodeV3(v3)
+	sectors := decodeSectors(plain)
+	require.Len(t, sectors, 9)
+	for i, sec := range sectors {
+		require.True(t, sec.IDCRC)
+		require.True(t, sec.DataCRC, "sector %d", i)
+		require.Equal(t, d.Tracks[0].Data[i*512:(i+1)*512], sec.Data)
+	}
+}
+
+func TestToDSK_StandardWhenPossible(t *testing.T) {
+	d := makeDSK(2, 1)
+	h, err := Open(writeHFE(t, d))
+	require.NoError(t, err)
+	recovered, err := h.ToDSK()
+	require.NoError(t, err)
+	require.False(t, recovered.Extended)
+	for i := range d.Tracks {
+		require.Equal(t, d.Tracks[i].Sect[:9], recovered.Tracks[i].Sect[:9])
+		require.Equal(t, d.Tracks[i].Data, recovered.Tracks[i].Data)
+	}
+}
+
+func TestToDSK_SectorIDs(t *testing.T) {
+	d := extdsk.FormatDsk(9, 2, 1, extdsk.DataFormat, extdsk.EXTENDED_DSK_TYPE)
+	// track 1: 5 sectors of 1024 bytes in an interleaved order
+	track := &d.Tracks[1]
+	ids := []uint8{1, 4, 2, 5, 3}
+	track.NbSect = 5
+	track.SectSize = 3
+	track.Data = make([]byte, 5*1024)
+	for s, id := range ids {
+		track.Sect[s] = extdsk.CPCEMUSect{C: 1, H: 0, R: id, N: 3, SizeByte: 1024}
+		for i := range 1024 {
+			track.Data[s*1024+i] = byte(int(id) + i)
+		}
+	}
+	h, err := Open(writeHFE(t, d))
+	require.NoError(t, err)
+	recovered, err := h.ToDSK()
+	require.NoError(t, err)
+	require.True(t, recovered.Extended, "tracks of different sizes need an extended dsk")
+	got := recovered.Track(1, 0)
+	require.Equal(t, uint8(5), got.NbSect)
+	require.Equal(t, uint8(3), got.SectSize)
+	require.Equal(t, track.Sect[:5], got.Sect[:5])
+	require.Equal(t, track.Data, got.Data)
+	require.Equal(t, d.Tracks[0].Data, recovered.Track(0, 0).Data)
+	offsets, ok := recovered.SectorOffsets(1)
+	require.True(t, ok)
+	require.Len(t, offsets.Sectors, 5)
+}
+
+func TestToDSK_DeletedAndCRCErrors(t *testing.T) {
+	d := makeDSK(1, 1)
+	sect := &d.Tracks[0].Sect
+	sect[1].Un1 = uint16(fdc.ST2ControlMark) << 8
+	sect[2].Un1 = fdc.ST1DataError | uint16(fdc.ST2DataErrorInData)<<8
+	sect[3].Un1 = fdc.ST1DataError
+	h, err := Open(writeHFE(t, d))
+	require.NoError(t, err)
+	recovered, err := h.ToDSK()
+	require.NoError(t, err)
+	got := recovered.Track(0, 0)
+	require.Equal(t, uint8(9), got.NbSect)
+	for s := 0; s < 9; s++ {
+		require.Equal(t, sect[s].Un1, got.Sect[s].Un1, "sector %d", s)
+		require.Equal(t, sect[s].R, got.Sect[s].R)
+	}
+	require.Equal(t, d.Tracks[0].Data, got.Data)
+}
+
+func TestToDSK_MissingDataMark(t *testing.T) {
+	d := makeDSK(1, 1)
+	tr := &d.Tracks[0]
+	status := uint16(fdc.ST1MissingAddressMark) | uint16(fdc.ST2MissingDataMark)<<8
+	tr.Sect[4].Un1 = status
+	h, err := Open(writeHFE(t, d))
+	require.NoError(t, err)
+	recovered, err := h.ToDSK()
+	require.NoError(t, err)
+	got := recovered.Track(0, 0)
+	require.Equal(t, uint8(9), got.NbSect)
+	require.Equal(t, status, got.Sect[4].Un1)
+	require.Equal(t, uint16(0), got.Sect[4].SizeByte)
+	require.Equal(t, 8*512, len(got.Data))
+	data, err := recovered.ReadSectorAt(0, 0, 5)
+	require.NoError(t, err)
+	expected, err := d.ReadSectorAt(0, 0, 5)
+	require.NoError(t, err)
+	require.Equal(t, expected, data)
+}
+
+func TestDecodeSectors_MissingData(t *testing.T) {
+	raw := []byte{0xA1, 0xA1, 0xA1, 0xFE, 0, 0, 0xC1, 2}
+	crc := fdc.CRC16(raw)
+	raw = append(raw, byte(crc>>8), byte(crc))
+	raw = append(raw, bytes.Repeat([]byte{0x4E}, 100)...)
+	sectors := decodeSectors(mfmEncodeSync(raw, []bool{true, true, true}, nil))
+	require.Len(t, sectors, 1)
+	require.True(t, sectors[0].IDCRC)
+	require.False(t, sectors[0].HasData)
+	require.Equal(t, uint16(fdc.ST1MissingAddressMark)|uint16(fdc.ST2MissingDataMark)<<8, sectors[0].Status())
+
+	track, offsets := fdc.BuildTrack(0, 0, sectors, extdsk.DataRateDD, extdsk.RecordingMFM)
+	require.Equal(t, []uint16{0}, offsets)
+	require.Equal(t, uint16(0), track.Sect[0].SizeByte)
+	require.Empty(t, track.Data)
+}
@@ -0,0 +1,109 @@
+// Package fdc holds what the floppy disk decoders share: the CRC of the
+// floppy disk controller, the FDC status of a decoded sector and the dsk
+// track built from the decoded sectors.
+package fdc
+
+import "github.com/jeromelesaux/dsk/dsk"
+
+// FDC status bits stored in the sector information of a dsk
+const (
+	ST1MissingAddressMark = 0x01
+	ST1DataError          = 0x20
+	ST2MissingDataMark    = 0x01
+	ST2DataErrorInData    = 0x20
+	ST2ControlMark        = 0x40
+)
+
+// CRC16 is the CRC-CCITT of the floppy disk controller
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Sector is a sector decoded from a track
+type Sector struct {
+	ID      [4]byte // C, H, R, N
+	Offset  int     // bytes from the index to the ID address mark
+	IDCRC   bool
+	HasData bool // false when the data address mark is missing
+	Deleted bool
+	Data    []byte
+	DataCRC bool
+	Copies  [][]byte // the copies read of a weak sector, nil otherwise
+}
+
+// Size returns the size of the sector given by its ID
+func (s Sector) Size() int {
+	return 128 << (s.ID[3] & 7)
+}
+
+// Status returns the FDC status registers ST1 and ST2 of the sector as
+// stored in the sector information of a dsk
+func (s Sector) Status() uint16 {
+	var st1, st2 uint8
+	if !s.IDCRC {
+		st1 |= ST1DataError
+	}
+	if !s.HasData {
+		st1 |= ST1MissingAddressMark
+		st2 |= ST2MissingDataMark
+	} else if !s.DataCRC {
+		st1 |= ST1DataError
+		st2 |= ST2DataErrorInData
+	}
+	if s.Deleted {
+		st2 |= ST2ControlMark
+	}
+	return uint16(st1) | uint16(st2)<<8
+}
+
+// BuildTrack returns the dsk track of the cylinder and head holding the
+// sectors, with the offsets of their ID address marks. A sector without
+// data mark stores no data, every copy of a weak sector is stored.
+func BuildTrack(cyl, head int, sectors []Sector, rate dsk.DataRate, recording dsk.RecordingMode) (dsk.CPCEMUTrack, []uint16) {
+	var track dsk.CPCEMUTrack
+	copy(track.ID[:], "Track-Info\r\n")
+	track.Track = uint8(cyl)
+	track.Head = uint8(head)
+	track.DataRate = rate
+	track.RecordingMode = recording
+	track.Gap3 = 0x4E
+	track.OctRemp = 0xE5
+	sectors = sectors[:min(len(sectors), len(track.Sect))]
+	track.NbSect = uint8(len(sectors))
+	offsets := make([]uint16, 0, len(sectors))
+	for i, s := range sectors {
+		if i == 0 {
+			track.SectSize = s.ID[3]
+		}
+		copies := s.Copies
+		if copies == nil && s.HasData {
+			copies = [][]byte{s.Data}
+		}
+		var stored int
+		for _, c := range copies {
+			track.Data = append(track.Data, c...)
+			stored += len(c)
+		}
+		track.Sect[i] = dsk.CPCEMUSect{
+			C:        s.ID[0],
+			H:        s.ID[1],
+			R:        s.ID[2],
+			N:        s.ID[3],
+			Un1:      s.Status(),
+			SizeByte: uint16(stored),
+		}
+		offsets = append(offsets, uint16(s.Offset))
+	}
+	return track, offsets
+}
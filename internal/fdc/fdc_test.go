@@ -0,0 +1,83 @@
+package fdc
+
+import (
+	"testing"
+
+	"github.com/jeromelesaux/dsk/dsk"
+	"github.com/stretchr/testify/require"
+)
+
+// --- CRC16 ---
+
+func TestCRC16_Deterministic(t *testing.T) {
+	data := []byte{0xFE, 0x00, 0x00, 0x02}
+	if CRC16(data) != CRC16(data) {
+		t.Error("CRC16 is not deterministic")
+	}
+}
+
+func TestCRC16_EmptyInput(t *testing.T) {
+	if got := CRC16(nil); got != 0xFFFF {
+		t.Errorf("CRC16(nil) = 0x%04X, want 0xFFFF", got)
+	}
+}
+
+func TestCRC16_DifferentInputs(t *testing.T) {
+	if CRC16([]byte{0x00}) == CRC16([]byte{0xFF}) {
+		t.Error("CRC16 collision on different inputs")
+	}
+}
+
+// --- Status ---
+
+func TestStatus(t *testing.T) {
+	good := Sector{IDCRC: true, HasData: true, DataCRC: true}
+	require.Equal(t, uint16(0), good.Status())
+
+	badID := good
+	badID.IDCRC = false
+	require.Equal(t, uint16(ST1DataError), badID.Status())
+
+	badData := good
+	badData.DataCRC = false
+	require.Equal(t, uint16(ST1DataError)|uint16(ST2DataErrorInData)<<8, badData.Status())
+
+	missing := Sector{IDCRC: true}
+	require.Equal(t, uint16(ST1MissingAddressMark)|uint16(ST2MissingDataMark)<<8, missing.Status())
+
+	deleted := good
+	deleted.Deleted = true
+	require.Equal(t, uint16(ST2ControlMark)<<8, deleted.Status())
+}
+
+// --- BuildTrack ---
+
+func TestBuildTrack(t *testing.T) {
+	data := func(b byte) []byte {
+		d := make([]byte, 512)
+		for i := range d {
+			d[i] = b
+		}
+		return d
+	}
+	sectors := []Sector{
+		{ID: [4]byte{3, 1, 0xC1, 2}, Offset: 100, IDCRC: true, HasData: true, DataCRC: true, Data: data(1)},
+		{ID: [4]byte{3, 1, 0xC2, 2}, Offset: 700, IDCRC: true},
+		{ID: [4]byte{3, 1, 0xC3, 2}, Offset: 1300, IDCRC: true, HasData: true, Data: data(2), Copies: [][]byte{data(2), data(3)}},
+	}
+	track, offsets := BuildTrack(3, 1, sectors, dsk.DataRateDD, dsk.RecordingMFM)
+	require.Equal(t, uint8(3), track.Track)
+	require.Equal(t, uint8(1), track.Head)
+	require.Equal(t, uint8(3), track.NbSect)
+	require.Equal(t, uint8(2), track.SectSize)
+	require.Equal(t, dsk.RecordingMFM, track.RecordingMode)
+	require.Equal(t, []uint16{100, 700, 1300}, offsets)
+
+	require.Equal(t, [][]byte{data(1)}, track.SectorCopies(0))
+	require.Equal(t, uint16(0), track.Sect[1].SizeByte)
+	require.Equal(t, [][]byte{{}}, track.SectorCopies(1))
+	require.Equal(t, sectors[1].Status(), track.Sect[1].Un1)
+	require.True(t, track.IsWeakSector(2))
+	require.Equal(t, [][]byte{data(2), data(3)}, track.SectorCopies(2))
+	require.Equal(t, uint16(1024), track.Sect[2].SizeByte)
+}
@@ -0,0 +1,151 @@
+package fdc
+
+import "slices"
+
+// maximum distance in bytes between an ID address mark and its data address mark
+const maxDataDistance = 64
+
+// mfmSync is three A1 bytes with a missing clock bit, 0x4489 in MFM
+const mfmSync = 0x448944894489
+
+// mfmPrefix are the sync bytes preceding an MFM address mark, covered by the CRC
+var mfmPrefix = []byte{0xA1, 0xA1, 0xA1}
+
+// fmSync are the cells of a sync byte of FM, preceding each address mark
+var fmSync = fmCells(0xFF, 0x00)
+
+// fmMarks are the cells of the FM address marks written with the clock C7
+var fmMarks = []uint32{
+	fmCells(0xC7, 0xFE),
+	fmCells(0xC7, 0xFB),
+	fmCells(0xC7, 0xF8),
+}
+
+// fmCells returns the 32 cells of an FM byte and its clock, clock, 0, data,
+// 0 for each bit, first bit first
+func fmCells(clock, data byte) uint32 {
+	var cells uint32
+	for j := 7; j >= 0; j-- {
+		cells = cells<<4 | uint32(clock>>uint(j)&1)<<3 | uint32(data>>uint(j)&1)<<1
+	}
+	return cells
+}
+
+// mfmMarks returns the positions of the cells following each group of
+// three A1 sync bytes, found at any cell
+func mfmMarks(cells []byte) []int {
+	marks := make([]int, 0)
+	var reg uint64
+	for i, c := range cells {
+		reg = (reg<<1 | uint64(c)) & (1<<48 - 1)
+		if reg == mfmSync {
+			marks = append(marks, i+1)
+		}
+	}
+	return marks
+}
+
+// mfmBytes returns the n bytes whose MFM cells start at pos, the data bit
+// being the second cell of each pair. It returns less bytes at the end of the cells.
+func mfmBytes(cells []byte, pos, n int) []byte {
+	out := make([]byte, 0, n)
+	for ; len(out) < n && pos+16 <= len(cells); pos += 16 {
+		var b byte
+		for k := 0; k < 8; k++ {
+			b = b<<1 | cells[pos+2*k+1]
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// fmAddressMarks returns the positions of the address marks following an FM sync
+// byte, found at any cell
+func fmAddressMarks(cells []byte) []int {
+	marks := make([]int, 0)
+	var reg uint64
+	for i, c := range cells {
+		reg = reg<<1 | uint64(c)
+		if i >= 63 && uint32(reg>>32) == fmSync && slices.Contains(fmMarks, uint32(reg)) {
+			marks = append(marks, i-31)
+		}
+	}
+	return marks
+}
+
+// fmBytes returns the n bytes whose FM cells start at pos, the data bit
+// being the third cell of each group of four. It returns less bytes at the
+// end of the cells.
+func fmBytes(cells []byte, pos, n int) []byte {
+	out := make([]byte, 0, n)
+	for ; len(out) < n && pos+32 <= len(cells); pos += 32 {
+		var b byte
+		for k := 0; k < 8; k++ {
+			b = b<<1 | cells[pos+4*k+2]
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// MFMSectors returns the sectors of the MFM cells of a track, one byte per
+// cell holding 1 for a flux transition. The address marks are found at any
+// cell and each field is read in the phase of its sync bytes. The offsets
+// of the sectors are counted in bytes of 16 cells from the first cell.
+func MFMSectors(cells []byte) []Sector {
+	read := func(pos, n int) []byte { return mfmBytes(cells, pos, n) }
+	return scanSectors(mfmMarks(cells), read, 16, mfmPrefix)
+}
+
+// FMSectors returns the sectors of the FM cells of a track sampled at the
+// MFM cell rate, each bit lasting four cells. The address marks are found at
+// any cell and the offsets of the sectors are counted in bytes of 32 cells.
+func FMSectors(cells []byte) []Sector {
+	read := func(pos, n int) []byte { return fmBytes(cells, pos, n) }
+	return scanSectors(fmAddressMarks(cells), read, 32, nil)
+}
+
+// checkCRC is true if the field ends with the CRC of the sync bytes, the mark and its content
+func checkCRC(prefix, field []byte) bool {
+	if len(field) < 3 {
+		return false
+	}
+	crc := CRC16(append(append([]byte{}, prefix...), field[:len(field)-2]...))
+	return crc == uint16(field[len(field)-2])<<8|uint16(field[len(field)-1])
+}
+
+// scanSectors returns the sectors of the address marks starting at the
+// positions in cells, read returning the n bytes from a position and a byte
+// lasting size cells. The marks follow the prefix sync bytes.
+func scanSectors(marks []int, read func(pos, n int) []byte, size int, prefix []byte) []Sector {
+	sectors := make([]Sector, 0)
+	lastID := -1
+	for _, pos := range marks {
+		mark := read(pos, 1)
+		if len(mark) == 0 {
+			continue
+		}
+		switch mark[0] {
+		case 0xFE:
+			field := read(pos, 7)
+			if len(field) < 7 {
+				continue
+			}
+			s := Sector{Offset: pos/size - len(prefix), IDCRC: checkCRC(prefix, field)}
+			copy(s.ID[:], field[1:5])
+			sectors = append(sectors, s)
+			lastID = pos
+		case 0xFB, 0xF8:
+			if lastID < 0 || sectors[len(sectors)-1].HasData || pos-lastID > maxDataDistance*size {
+				continue
+			}
+			s := &sectors[len(sectors)-1]
+			field := read(pos, s.Size()+3)
+			s.HasData = true
+			s.Deleted = mark[0] == 0xF8
+			s.DataCRC = len(field) == s.Size()+3 && checkCRC(prefix, field)
+			s.Data = field[1:min(len(field), s.Size()+1)]
+		}
+	}
+	return sectors
+}
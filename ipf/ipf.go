@@ -0,0 +1,454 @@
+// Package ipf reads the IPF images of the Software Preservation Society
+// (CAPS/SPS) and decodes their tracks into MFM bitstreams.
+package ipf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"os"
+
+	extdsk "github.com/jeromelesaux/dsk/dsk"
+	"github.com/jeromelesaux/dsk/hfe"
+)
+
+var (
+	ErrorIPFFormat = errors.New("not an IPF image")
+	ErrorIPFCRC    = errors.New("IPF record CRC error")
+)
+
+const recordHeaderSize = 12 // type, length and CRC of a record
+
+// encoders of the INFO record
+const (
+	EncoderCAPS uint32 = 1
+	EncoderSPS  uint32 = 2
+)
+
+// densities of the IMGE record
+const (
+	densityNA    uint32 = 0
+	densityNoise uint32 = 1
+)
+
+// block flags
+const (
+	blockForwardGap  uint32 = 1 << 0
+	blockBackwardGap uint32 = 1 << 1
+	blockDataInBit   uint32 = 1 << 2 // sizes of the data stream in bits, bytes otherwise
+)
+
+// block encoders
+const (
+	blockEncoderMFM uint32 = 1
+	blockEncoderRaw uint32 = 2
+)
+
+// elements of the data stream
+const (
+	dataEnd   = 0
+	dataSync  = 1 // MFM cells
+	dataData  = 2 // bytes to encode
+	dataGap   = 3 // bytes to encode
+	dataRaw   = 4 // MFM cells
+	dataFuzzy = 5 // weak bytes, no value stored
+)
+
+// elements of the gap stream
+const (
+	gapEnd    = 0
+	gapLength = 1
+	gapSample = 2
+)
+
+// Info is the INFO record of the image
+type Info struct {
+	MediaType    uint32
+	EncoderType  uint32
+	EncoderRev   uint32
+	FileKey      uint32
+	FileRev      uint32
+	Origin       uint32
+	MinTrack     uint32
+	MaxTrack     uint32
+	MinSide      uint32
+	MaxSide      uint32
+	CreationDate uint32
+	CreationTime uint32
+	Platforms    [4]uint32
+	DiskNumber   uint32
+	CreatorID    uint32
+	Reserved     [3]uint32
+}
+
+// Image is the IMGE record of a track
+type Image struct {
+	Track          uint32
+	Side           uint32
+	Density        uint32
+	SignalType     uint32
+	TrackBytes     uint32
+	StartBytePos   uint32
+	StartBitPos    uint32
+	DataBits       uint32
+	GapBits        uint32
+	TrackBits      uint32
+	BlockCount     uint32
+	EncoderProcess uint32
+	TrackFlags     uint32
+	DataKey        uint32
+	Reserved       [3]uint32
+}
+
+// dataRecord is the DATA record followed by the blocks of a track
+type dataRecord struct {
+	Length  uint32
+	BitSize uint32
+	CRC     uint32
+	DataKey uint32
+}
+
+// blockDescriptor describes a block of a track, the third and fourth fields
+// are the data and gap sizes in bytes for the CAPS encoder and the gap
+// stream offset and cell type for the SPS encoder
+type blockDescriptor struct {
+	DataBits    uint32
+	GapBits     uint32
+	GapOffset   uint32
+	CellType    uint32
+	EncoderType uint32
+	BlockFlags  uint32
+	GapDefault  uint32
+	DataOffset  uint32
+}
+
+// Track is a decoded track, its MFM cells are packed most significant bit first
+type Track struct {
+	Cylinder int
+	Head     int
+	Bits     []byte
+	BitCount int
+}
+
+type IPF struct {
+	Info   Info
+	Images []Image
+	Tracks []Track
+}
+
+// Open reads the IPF image of the file
+func Open(path string) (*IPF, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read reads an IPF image: the CAPS record, the INFO record, one IMGE record
+// per track then the DATA records holding the blocks of the tracks.
+// The CRC of every record and block is checked.
+func Read(r io.Reader) (*IPF, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < recordHeaderSize || string(content[0:4]) != "CAPS" {
+		return nil, ErrorIPFFormat
+	}
+	p := &IPF{}
+	images := make(map[uint32]Image) // by data key
+	var hasInfo bool
+	for pos := 0; pos+recordHeaderSize <= len(content); {
+		kind := string(content[pos : pos+4])
+		length := int(binary.BigEndian.Uint32(content[pos+4:]))
+		if length < recordHeaderSize || pos+length > len(content) {
+			return nil, fmt.Errorf("%w: record %s of %d bytes at %d", ErrorIPFFormat, kind, length, pos)
+		}
+		record := content[pos : pos+length]
+		if err := checkRecordCRC(record); err != nil {
+			return nil, fmt.Errorf("%w: record %s at %d", err, kind, pos)
+		}
+		body := record[recordHeaderSize:]
+		pos += length
+		switch kind {
+		case "INFO":
+			if err := readStruct(body, &p.Info); err != nil {
+				return nil, err
+			}
+			hasInfo = true
+		case "IMGE":
+			var img Image
+			if err := readStruct(body, &img); err != nil {
+				return nil, err
+			}
+			p.Images = append(p.Images, img)
+			images[img.DataKey] = img
+		case "DATA":
+			var data dataRecord
+			if err := readStruct(body, &data); err != nil {
+				return nil, err
+			}
+			if pos+int(data.Length) > len(content) {
+				return nil, fmt.Errorf("%w: blocks of data key %d out of the file", ErrorIPFFormat, data.DataKey)
+			}
+			extra := content[pos : pos+int(data.Length)]
+			pos += int(data.Length)
+			if crc32.ChecksumIEEE(extra) != data.CRC {
+				return nil, fmt.Errorf("%w: blocks of data key %d", ErrorIPFCRC, data.DataKey)
+			}
+			img, ok := images[data.DataKey]
+			if !ok {
+				return nil, fmt.Errorf("%w: no track for data key %d", ErrorIPFFormat, data.DataKey)
+			}
+			t, err := decodeTrack(img, extra)
+			if err != nil {
+				return nil, fmt.Errorf("track %d side %d: %w", img.Track, img.Side, err)
+			}
+			p.Tracks = append(p.Tracks, t)
+		}
+	}
+	if !hasInfo {
+		return nil, fmt.Errorf("%w: no INFO record", ErrorIPFFormat)
+	}
+	return p, nil
+}
+
+// checkRecordCRC checks the CRC-32 of the record, computed with its CRC field zeroed
+func checkRecordCRC(record []byte) error {
+	crc := binary.BigEndian.Uint32(record[8:])
+	zeroed := append([]byte{}, record...)
+	binary.BigEndian.PutUint32(zeroed[8:], 0)
+	if crc32.ChecksumIEEE(zeroed) != crc {
+		return ErrorIPFCRC
+	}
+	return nil
+}
+
+func readStruct(body []byte, v any) error {
+	if len(body) < binary.Size(v) {
+		return fmt.Errorf("%w: record of %d bytes instead of %d", ErrorIPFFormat, len(body), binary.Size(v))
+	}
+	_, err := binary.Decode(body, binary.BigEndian, v)
+	return err
+}
+
+// bitWriter appends MFM cells, the clock of the encoded bytes follows the
+// last data bit written
+type bitWriter struct {
+	bits  []byte
+	count int
+	prev  byte
+}
+
+func (w *bitWriter) cell(c byte) {
+	if w.count%8 == 0 {
+		w.bits = append(w.bits, 0)
+	}
+	w.bits[w.count/8] |= c << (7 - w.count%8)
+	w.count++
+}
+
+// raw appends n cells of data, most significant bit first
+func (w *bitWriter) raw(data []byte, n int) {
+	for i := 0; i < n && i/8 < len(data); i++ {
+		w.cell(data[i/8] >> (7 - i%8) & 1)
+	}
+	w.prev = w.lastCell()
+}
+
+// encode appends the MFM cells of n bits of data, most significant bit first
+func (w *bitWriter) encode(data []byte, n int) {
+	for i := 0; i < n && i/8 < len(data); i++ {
+		bit := data[i/8] >> (7 - i%8) & 1
+		var clock byte
+		if bit == 0 && w.prev == 0 {
+			clock = 1
+		}
+		w.cell(clock)
+		w.cell(bit)
+		w.prev = bit
+	}
+}
+
+// weak appends n cells without any flux transition, read randomly by a drive
+func (w *bitWriter) weak(n int) {
+	for range n {
+		w.cell(0)
+	}
+	w.prev = 0
+}
+
+func (w *bitWriter) lastCell() byte {
+	if w.count == 0 {
+		return 0
+	}
+	return w.bits[(w.count-1)/8] >> (7 - (w.count-1)%8) & 1
+}
+
+// fill appends or removes cells to end the block at n cells
+func (w *bitWriter) fill(n int, pattern []byte, patternBits int) {
+	for w.count < n && patternBits > 0 {
+		w.encode(pattern, min(patternBits, (n-w.count)/2))
+		if (n-w.count)/2 == 0 {
+			break
+		}
+	}
+	for w.count < n {
+		w.cell(0)
+	}
+	w.count = min(w.count, n)
+	w.bits = w.bits[:(w.count+7)/8]
+	if w.count%8 != 0 {
+		w.bits[len(w.bits)-1] &= 0xFF << (8 - w.count%8)
+	}
+}
+
+// decodeTrack returns the MFM cells of the blocks of the track, from the
+// start of the first block. Tracks of noise have no cell.
+func decodeTrack(img Image, extra []byte) (Track, error) {
+	t := Track{Cylinder: int(img.Track), Head: int(img.Side)}
+	if img.Density == densityNA || img.Density == densityNoise {
+		return t, nil
+	}
+	if len(extra) < int(img.BlockCount)*32 {
+		return t, fmt.Errorf("%w: %d blocks in %d bytes", ErrorIPFFormat, img.BlockCount, len(extra))
+	}
+	w := &bitWriter{}
+	for b := 0; b < int(img.BlockCount); b++ {
+		var block blockDescriptor
+		if _, err := binary.Decode(extra[b*32:], binary.BigEndian, &block); err != nil {
+			return t, err
+		}
+		start := w.count
+		if err := decodeData(w, block, extra); err != nil {
+			return t, fmt.Errorf("block %d: %w", b, err)
+		}
+		w.fill(start+int(block.DataBits), nil, 0)
+		pattern, patternBits := gapPattern(block, extra)
+		w.fill(start+int(block.DataBits+block.GapBits), pattern, patternBits)
+	}
+	t.Bits, t.BitCount = w.bits, w.count
+	return t, nil
+}
+
+// readElement reads the head of a stream element: its type and its size
+// stored on the number of bytes given by the 3 upper bits of the head
+func readElement(stream []byte, pos int) (kind byte, size, next int, err error) {
+	if pos >= len(stream) {
+		return 0, 0, pos, fmt.Errorf("%w: stream out of the blocks", ErrorIPFFormat)
+	}
+	kind = stream[pos] & 0x1F
+	width := int(stream[pos] >> 5)
+	pos++
+	if pos+width > len(stream) {
+		return 0, 0, pos, fmt.Errorf("%w: stream out of the blocks", ErrorIPFFormat)
+	}
+	for i := 0; i < width; i++ {
+		size = size<<8 | int(stream[pos+i])
+	}
+	return kind, size, pos + width, nil
+}
+
+// decodeData appends the cells of the data stream of the block. Sync and raw
+// elements hold MFM cells, data and gap elements hold bytes to encode and a
+// fuzzy element stands for weak bytes.
+func decodeData(w *bitWriter, block blockDescriptor, extra []byte) error {
+	pos := int(block.DataOffset)
+	for {
+		kind, size, next, err := readElement(extra, pos)
+		if err != nil {
+			return err
+		}
+		if kind == dataEnd {
+			return nil
+		}
+		n := size
+		if block.BlockFlags&blockDataInBit == 0 {
+			n *= 8
+		}
+		stored := (n + 7) / 8
+		if kind == dataFuzzy {
+			stored = 0
+		}
+		if next+stored > len(extra) {
+			return fmt.Errorf("%w: element of %d bits out of the blocks", ErrorIPFFormat, n)
+		}
+		data := extra[next : next+stored]
+		switch {
+		case kind == dataSync, kind == dataRaw, block.EncoderType == blockEncoderRaw:
+			w.raw(data, n)
+		case kind == dataData, kind == dataGap:
+			w.encode(data, n)
+		case kind == dataFuzzy:
+			w.weak(2 * n)
+		default:
+			return fmt.Errorf("%w: data element %d", ErrorIPFFormat, kind)
+		}
+		pos = next + stored
+	}
+}
+
+// gapPattern returns the first sample of the gap stream of the block, or
+// the default gap value without stream
+func gapPattern(block blockDescriptor, extra []byte) ([]byte, int) {
+	defaultGap := []byte{byte(block.GapDefault)}
+	if block.BlockFlags&(blockForwardGap|blockBackwardGap) == 0 || block.GapOffset == 0 {
+		return defaultGap, 8
+	}
+	pos := int(block.GapOffset)
+	for {
+		kind, size, next, err := readElement(extra, pos)
+		if err != nil || kind == gapEnd {
+			return defaultGap, 8
+		}
+		pos = next
+		if kind == gapSample {
+			stored := (size + 7) / 8
+			if size == 0 || pos+stored > len(extra) {
+				return defaultGap, 8
+			}
+			return extra[pos : pos+stored], size
+		}
+	}
+}
+
+// ToHFE returns the tracks of the image as an HFE image, the cells of each
+// track packed least significant bit first
+func (p *IPF) ToHFE() *hfe.HFE {
+	cyls, heads := 0, 1
+	for _, t := range p.Tracks {
+		cyls = max(cyls, t.Cylinder+1)
+		heads = max(heads, t.Head+1)
+	}
+	h := &hfe.HFE{
+		Header: hfe.Header{
+			Signature: "HXCPICFE",
+			NumTracks: byte(cyls),
+			NumSides:  byte(heads),
+			BitRate:   250,
+		},
+		Tracks: make([]hfe.Track, cyls),
+	}
+	for _, t := range p.Tracks {
+		stream := make([]byte, len(t.Bits))
+		for i, b := range t.Bits {
+			stream[i] = bits.Reverse8(b)
+		}
+		if t.Head == 0 {
+			h.Tracks[t.Cylinder].Side0 = stream
+		} else {
+			h.Tracks[t.Cylinder].Side1 = stream
+		}
+	}
+	return h
+}
+
+// ToDSK decodes the sectors of the tracks as hfe.ToDSK does
+func (p *IPF) ToDSK() (*extdsk.DSK, error) {
+	return p.ToHFE().ToDSK()
+}
@@ -0,0 +1,206 @@
+package ipf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/dsk/internal/fdc"
+	"github.com/stretchr/testify/require"
+)
+
+// --- helpers ---
+
+func record(kind string, body any) []byte {
+	b, _ := binary.Append(nil, binary.BigEndian, body)
+	r := append([]byte(kind), make([]byte, 8)...)
+	r = append(r, b...)
+	binary.BigEndian.PutUint32(r[4:], uint32(len(r)))
+	binary.BigEndian.PutUint32(r[8:], crc32.ChecksumIEEE(r))
+	return r
+}
+
+// element returns a stream element of the size, stored on 2 bytes
+func element(kind byte, size int, data ...byte) []byte {
+	return append([]byte{2<<5 | kind, byte(size >> 8), byte(size)}, data...)
+}
+
+// sectorBlocks returns the blocks of a track of 512 bytes sectors, one block
+// per sector ending with a gap of 0x4E of gapBits cells, the sizes in bits
+// or in bytes
+func sectorBlocks(cyl, head int, data [][]byte, inBit bool, gapBits func(i int) int) []byte {
+	unit := func(n int) int {
+		if inBit {
+			return n * 8
+		}
+		return n
+	}
+	sync := []byte{0x44, 0x89, 0x44, 0x89, 0x44, 0x89}
+	streams := make([][]byte, len(data))
+	for i, sector := range data {
+		id := []byte{0xFE, byte(cyl), byte(head), byte(0xC1 + i), 2}
+		crc := fdc.CRC16(append([]byte{0xA1, 0xA1, 0xA1}, id...))
+		id = binary.BigEndian.AppendUint16(id, crc)
+		field := append([]byte{0xFB}, sector...)
+		crc = fdc.CRC16(append([]byte{0xA1, 0xA1, 0xA1}, field...))
+		field = binary.BigEndian.AppendUint16(field, crc)
+		s := element(dataGap, unit(12), make([]byte, 12)...)
+		s = append(s, element(dataSync, unit(6), sync...)...)
+		s = append(s, element(dataData, unit(len(id)), id...)...)
+		s = append(s, element(dataGap, unit(22), bytes.Repeat([]byte{0x4E}, 22)...)...)
+		s = append(s, element(dataGap, unit(12), make([]byte, 12)...)...)
+		s = append(s, element(dataSync, unit(6), sync...)...)
+		s = append(s, element(dataData, unit(len(field)), field...)...)
+		streams[i] = append(s, dataEnd)
+	}
+	gaps := append(element(gapSample, 8, 0x4E), gapEnd)
+	offset := 32*len(data) + len(gaps)
+	descriptors := make([]byte, 0)
+	payload := append([]byte{}, gaps...)
+	for i, s := range streams {
+		dataBits := (12 + 6 + 7 + 22 + 12 + 6 + 515) * 16
+		block := blockDescriptor{
+			DataBits:    uint32(dataBits),
+			GapBits:     uint32(gapBits(i)),
+			EncoderType: blockEncoderMFM,
+			GapDefault:  0x4E,
+			DataOffset:  uint32(offset),
+		}
+		if inBit {
+			block.BlockFlags = blockDataInBit | blockForwardGap
+			block.GapOffset = uint32(32 * len(data))
+			block.GapDefault = 0xFF // the gap stream gives the value
+		}
+		descriptors, _ = binary.Append(descriptors, binary.BigEndian, block)
+		payload = append(payload, s...)
+		offset += len(s)
+	}
+	return append(descriptors, payload...)
+}
+
+// gapBytes gives gaps of 40 bytes to the blocks
+func gapBytes(int) int { return 40 * 16 }
+
+func sectors(rng *rand.Rand) [][]byte {
+	data := make([][]byte, 9)
+	for i := range data {
+		data[i] = make([]byte, 512)
+		rng.Read(data[i])
+	}
+	return data
+}
+
+// ipfImage returns an IPF image of the tracks (blocks by cylinder and head),
+// nil blocks for a track of noise
+func ipfImage(encoder uint32, tracks [][2][]byte, heads int) []byte {
+	content := record("CAPS", struct{}{})
+	content = append(content, record("INFO", Info{
+		MediaType:   1,
+		EncoderType: encoder,
+		MaxTrack:    uint32(len(tracks) - 1),
+		MaxSide:     uint32(heads - 1),
+	})...)
+	key := uint32(1)
+	data := make([]byte, 0)
+	for cyl, sides := range tracks {
+		for head := 0; head < heads; head++ {
+			blocks := sides[head]
+			img := Image{Track: uint32(cyl), Side: uint32(head), Density: 2, BlockCount: 9, DataKey: key}
+			if blocks == nil {
+				img.Density, img.BlockCount = densityNoise, 0
+			}
+			content = append(content, record("IMGE", img)...)
+			data = append(data, record("DATA", dataRecord{
+				Length:  uint32(len(blocks)),
+				BitSize: uint32(len(blocks) * 8),
+				CRC:     crc32.ChecksumIEEE(blocks),
+				DataKey: key,
+			})...)
+			data = append(data, blocks...)
+			key++
+		}
+	}
+	return append(content, data...)
+}
+
+// --- tests ---
+
+func TestRead_SPS(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	expected := [][]byte{}
+	tracks := make([][2][]byte, 3)
+	for cyl := 0; cyl < 2; cyl++ {
+		for head := 0; head < 2; head++ {
+			data := sectors(rng)
+			tracks[cyl][head] = sectorBlocks(cyl, head, data, true, gapBytes)
+			expected = append(expected, bytes.Join(data, nil))
+		}
+	}
+	p, err := Read(bytes.NewReader(ipfImage(EncoderSPS, tracks, 2)))
+	require.NoError(t, err)
+	require.Equal(t, EncoderSPS, p.Info.EncoderType)
+	require.Len(t, p.Images, 6)
+	require.Len(t, p.Tracks, 6)
+	require.Equal(t, 9*(580+40)*16, p.Tracks[0].BitCount)
+	require.Zero(t, p.Tracks[4].BitCount, "track of noise")
+
+	d, err := p.ToDSK()
+	require.NoError(t, err)
+	require.Equal(t, uint8(3), d.Entry.NbTracks)
+	require.Equal(t, uint8(2), d.Entry.NbHeads)
+	for i, data := range expected {
+		track := d.Track(i/2, i%2)
+		require.NotNil(t, track)
+		require.Equal(t, data, track.Data, "cylinder %d head %d", i/2, i%2)
+	}
+}
+
+func TestToDSK_OddGaps(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	data := sectors(rng)
+	// gaps ending out of the phase of the cells of the bytes
+	gaps := func(i int) int { return 40*16 + 2*i + 1 }
+	p, err := Read(bytes.NewReader(ipfImage(EncoderSPS, [][2][]byte{{sectorBlocks(0, 0, data, true, gaps)}}, 1)))
+	require.NoError(t, err)
+	d, err := p.ToDSK()
+	require.NoError(t, err)
+	track := d.Track(0, 0)
+	require.NotNil(t, track)
+	require.Equal(t, uint8(9), track.NbSect)
+	require.Equal(t, bytes.Join(data, nil), track.Data)
+}
+
+func TestOpen_CAPS(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	data := sectors(rng)
+	tracks := [][2][]byte{{sectorBlocks(0, 0, data, false, gapBytes)}}
+	path := filepath.Join(t.TempDir(), "test.ipf")
+	require.NoError(t, os.WriteFile(path, ipfImage(EncoderCAPS, tracks, 1), 0644))
+
+	p, err := Open(path)
+	require.NoError(t, err)
+	d, err := p.ToDSK()
+	require.NoError(t, err)
+	require.Equal(t, bytes.Join(data, nil), d.Tracks[0].Data)
+}
+
+func TestRead_Errors(t *testing.T) {
+	_, err := Read(bytes.NewReader([]byte("not an ipf image")))
+	require.ErrorIs(t, err, ErrorIPFFormat)
+
+	rng := rand.New(rand.NewSource(3))
+	image := ipfImage(EncoderSPS, [][2][]byte{{sectorBlocks(0, 0, sectors(rng), true, gapBytes)}}, 1)
+	corrupted := append([]byte{}, image...)
+	corrupted[len(corrupted)-100] ^= 0xFF
+	_, err = Read(bytes.NewReader(corrupted))
+	require.ErrorIs(t, err, ErrorIPFCRC)
+
+	corrupted = append([]byte{}, image...)
+	corrupted[recordHeaderSize+recordHeaderSize] ^= 0xFF // INFO media type
+	_, err = Read(bytes.NewReader(corrupted))
+	require.ErrorIs(t, err, ErrorIPFCRC)
+}